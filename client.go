@@ -1,14 +1,14 @@
 package httpcl
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"strconv"
+	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -18,45 +18,70 @@ type Client struct {
 	client     *http.Client
 	redirect   bool
 	request    *http.Request
+	session    *Session
+	middleware []Middleware
+	arrayStyle ArrayStyle
+	route      string
+	//detects the content type of a reader body when the request is sent
+	detectType func() string
 }
 
 type ClientBuilder struct {
 	Method   string
 	Url      string
+	Vars     Vars
 	Redirect bool
 	Body     []interface{}
 }
 
 func (c ClientBuilder) Build() *Client {
-	cl := getRequestWithBody(c.Method, c.Url, c.Body)
+	if c.Method != "" {
+		if err := validMethod(c.Method); err != nil {
+			return &Client{Error: err}
+		}
+	}
+	body := c.Body
+	if c.Vars != nil {
+		body = append([]interface{}{c.Vars}, body...)
+	}
+	cl := getRequestWithBody(c.Method, c.Url, body)
 	cl.redirect = c.Redirect
 	return cl
 }
 
-//creates a http client using GET
-func Get(url string) *Client {
-	c := &Client{}
-	c.request, c.Error = http.NewRequest("GET", url, nil)
+//creates a http client using GET, url is expanded as uri template if vars are given
+func Get(url string, vars ...Vars) *Client {
+	c := newClient("GET", url, vars)
 	c.redirect = true
 	return c
 }
 
-//creates a http client using HEAD
-func Head(url string) *Client {
-	c := &Client{}
-	c.request, c.Error = http.NewRequest("HEAD", url, nil)
+//creates a http client using HEAD, url is expanded as uri template if vars are given
+func Head(url string, vars ...Vars) *Client {
+	c := newClient("HEAD", url, vars)
 	c.redirect = true
 	return c
 }
 
-//creates a http client using DElETE
-func Delete(url string) *Client {
-	c := &Client{}
-	c.request, c.Error = http.NewRequest("DELETE", url, nil)
+//creates a http client using DElETE, url is expanded as uri template if vars are given
+func Delete(url string, vars ...Vars) *Client {
+	c := newClient("DELETE", url, vars)
 	c.redirect = true
 	return c
 }
 
+//creates a http client without body
+func newClient(method, url string, vars []Vars) *Client {
+	c := &Client{}
+	url, route, err := expandURL(url, vars)
+	if err != nil {
+		c.Error = err
+		return c
+	}
+	c.request, c.Error = http.NewRequest(method, url, nil)
+	return withRoute(c, route)
+}
+
 //creates a http client using PATCH with the given params
 func Patch(purl string, params ...interface{}) *Client {
 	return getRequestWithBody("PATCH", purl, params)
@@ -72,22 +97,40 @@ func Put(purl string, params ...interface{}) *Client {
 	return getRequestWithBody("PUT", purl, params)
 }
 
-//creates a request with a body
+//creates a request with a body, leading Vars params expand purl as uri template
 func getRequestWithBody(method, purl string, params []interface{}) *Client {
+	vars, params := splitVars(params)
+	purl, route, err := expandURL(purl, vars)
+	if err != nil {
+		return &Client{Error: err}
+	}
+	return withRoute(requestWithBody(method, purl, params), route)
+}
+
+func requestWithBody(method, purl string, params []interface{}) *Client {
 	if len(params) == 1 {
 		switch params[0].(type) {
 		case map[string]interface{}:
 			return postMap(method, purl, params[0].(map[string]interface{}))
 		case io.Reader:
 			c := &Client{}
-			c.request, c.Error = http.NewRequest(method, purl, params[0].(io.Reader))
+			c.request, c.detectType, c.Error = readerRequest(method, purl, params[0].(io.Reader))
 			return c
 		case url.Values:
 			c := &Client{}
-			c.request, c.Error = http.NewRequest(method, purl, strings.NewReader(params[0].(url.Values).Encode()))
+			c.request, c.Error = formRequest(method, purl, params[0].(url.Values))
 			return c
 		default:
 			c := &Client{}
+			if v := indirect(reflect.ValueOf(params[0])); v.IsValid() && (v.Kind() == reflect.Struct || v.Kind() == reflect.Map) {
+				values, err := EncodeForm(params[0])
+				if err != nil {
+					c.Error = err
+					return c
+				}
+				c.request, c.Error = formRequest(method, purl, values)
+				return c
+			}
 			c.Error = errors.New(fmt.Sprintf("parameters not correct %T", params[0]))
 			return c
 		}
@@ -96,13 +139,19 @@ func getRequestWithBody(method, purl string, params []interface{}) *Client {
 		if len(arr)%2 == 0 {
 			c := &Client{}
 			values := url.Values{}
+			var errs paramErrors
 			for i := 0; i < len(arr)-1; i += 2 {
-				c.Error = addToPost(arr[i].(string), arr[i+1], &values)
+				key, ok := arr[i].(string)
+				if !ok {
+					errs.add(&ParamError{Key: fmt.Sprint(arr[i]), Err: fmt.Errorf("key must be a string, got %T", arr[i])})
+					continue
+				}
+				errs.add(addToPost(key, arr[i+1], &values))
 			}
-			if c.Error != nil {
+			if c.Error = errs.err(); c.Error != nil {
 				return c
 			}
-			c.request, c.Error = http.NewRequest(method, purl, strings.NewReader(values.Encode()))
+			c.request, c.Error = formRequest(method, purl, values)
 			return c
 		} else {
 			c := &Client{}
@@ -116,37 +165,25 @@ func getRequestWithBody(method, purl string, params []interface{}) *Client {
 }
 
 func addToPost(key string, value interface{}, values *url.Values) (err error) {
-	switch value.(type) {
-	case bool:
-		values.Add(key, strconv.FormatBool(value.(bool)))
-	case float64:
-		values.Add(key, strconv.FormatFloat(value.(float64), 'f', 6, 64))
-	case int:
-		values.Add(key, strconv.Itoa(value.(int)))
-	case int64:
-		values.Add(key, strconv.FormatInt(value.(int64), 10))
-	case rune:
-		values.Add(key, string(value.(rune)))
-	case string:
-		values.Add(key, value.(string))
-	case uint64:
-		values.Add(key, strconv.FormatUint(value.(uint64), 10))
-	default:
-		err = errors.New(fmt.Sprintf("unsupported type for post %T", value))
-	}
-	return
+	return encodeFormValue(*values, key, reflect.ValueOf(value), formOptions{})
 }
 
 func postMap(method, purl string, param map[string]interface{}) *Client {
 	c := &Client{}
 	values := url.Values{}
-	for key, value := range param {
-		c.Error = addToPost(key, value, &values)
+	keys := make([]string, 0, len(param))
+	for key := range param {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var errs paramErrors
+	for _, key := range keys {
+		errs.add(addToPost(key, param[key], &values))
 	}
-	if c.Error != nil {
+	if c.Error = errs.err(); c.Error != nil {
 		return c
 	}
-	c.request, c.Error = http.NewRequest(method, purl, strings.NewReader(values.Encode()))
+	c.request, c.Error = formRequest(method, purl, values)
 	return c
 }
 
@@ -181,6 +218,10 @@ func (c *Client) GetRequest() *http.Request {
 
 //sets the underlying http.Request
 func (c *Client) SetRequest(req *http.Request) *Client {
+	if c.request == nil || req == nil || req.Body != c.request.Body {
+		//the detected content type belongs to the old body
+		c.detectType = nil
+	}
 	c.request = req
 	return c
 }
@@ -262,8 +303,16 @@ func (c *Client) Do() (*http.Response, error) {
 						CheckRedirect: redirect,
 					}
 				}
+				if c.session != nil {
+					c.client.Transport = c.session.transport
+					c.client.Jar = c.session.Jar
+					c.client.Timeout = c.session.Timeout
+				} else if c.request.URL.Scheme == unixScheme {
+					c.client.Transport = defaultUnixTransport
+				}
 			}
-			resp, err := c.client.Do(c.request)
+			c.applyContentType()
+			resp, err := c.httpClient().Do(c.request)
 			if err != nil {
 				if !c.redirect {
 					if !strings.Contains(err.Error(), "no redirect") {
@@ -286,6 +335,27 @@ func (c *Client) Do() (*http.Response, error) {
 	}
 }
 
+//returns the http.Client used for sending, with the middleware of the
+//session and the client wrapped around its transport
+func (c *Client) httpClient() *http.Client {
+	mw := c.allMiddleware()
+	if len(mw) == 0 {
+		return c.client
+	}
+	cl := *c.client
+	cl.Transport = chain(cl.Transport, mw)
+	return &cl
+}
+
+//returns the middleware of the session followed by the one of the client
+func (c *Client) allMiddleware() []Middleware {
+	var mw []Middleware
+	if c.session != nil {
+		mw = append(mw, c.session.middleware...)
+	}
+	return append(mw, c.middleware...)
+}
+
 //starts the request and transforms the response with the given function
 func (c *Client) DoTransform(trans func(resp *http.Response, c interface{}) error, b interface{}) (resp *http.Response, err error) {
 	resp, err = c.Do()
@@ -296,15 +366,9 @@ func (c *Client) DoTransform(trans func(resp *http.Response, c interface{}) erro
 	return resp, trans(resp, b)
 }
 
-//simple json transform
+//simple json transform, the body is decoded while it is read
 func TransformToJson(resp *http.Response, c interface{}) (err error) {
-	defer resp.Body.Close()
-	by, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
-	}
-	err = json.Unmarshal(by, c)
-	return
+	return DecodeJSON(resp, c, JSONOptions{})
 }
 
 //simple string transform
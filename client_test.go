@@ -75,7 +75,7 @@ func Test_BasicAuth(t *testing.T) {
 	cl := Get("http://httpbin.org/basic-auth/user/passwd").SetBasicAuth("user", "passwd")
 	cl.Do()
 	if cl.StatusCode != 200 {
-		t.Error("statuscode should be 200 is %v", cl.StatusCode)
+		t.Errorf("statuscode should be 200 is %v", cl.StatusCode)
 	}
 }
 
@@ -259,7 +259,7 @@ func Test_UserAgent(t *testing.T) {
 	agent := respjson.(map[string]interface{})["user-agent"]
 
 	if agent != "httpcl" {
-		t.Error("agent should be \"httpcl\" is \"%v\"", agent)
+		t.Errorf("agent should be \"httpcl\" is \"%v\"", agent)
 	}
 
 }
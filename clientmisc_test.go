@@ -40,7 +40,7 @@ func Test_Delete(t *testing.T) {
 
 func Test_Head(t *testing.T) {
 	var str string
-	err := Head("http://www.google.com").DoTransform(TransformToString, &str)
+	_, err := Head("http://www.google.com").DoTransform(TransformToString, &str)
 	if err != nil {
 		t.Error(err.Error())
 	}
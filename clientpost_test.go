@@ -98,18 +98,17 @@ func Test_PostFail(t *testing.T) {
 		t.Error(err1.Error())
 	}
 
-	var i int16
-	i = 1
+	i := make(chan int)
 	vals := map[string]interface{}{
 		"test": i,
 	}
 	err2 := Post("http://httpbin.org/post", vals).Error
-	if err2.Error() != "unsupported type for post int16" {
+	if err2.Error() != "test: unsupported type for post chan int" {
 		t.Error(err2.Error())
 	}
 
-	err3 := Post("http://httpbin.org/post", "test", int16(1)).Error
-	if err3.Error() != "unsupported type for post int16" {
+	err3 := Post("http://httpbin.org/post", "test", i).Error
+	if err3.Error() != "test: unsupported type for post chan int" {
 		t.Error(err3.Error())
 	}
 }
@@ -132,7 +131,7 @@ func Test_PostEmpty(t *testing.T) {
 	m := i.(map[string]interface{})
 	data := m["data"].(string)
 	if data != "" {
-		t.Error("post params should be empty, is %v", data)
+		t.Errorf("post params should be empty, is %v", data)
 	}
 
 }
@@ -145,7 +144,7 @@ func Test_PostMap(t *testing.T) {
 		"test1": 2,
 		"test2": true,
 		"test3": int64(2),
-		"test4": rune('a'),
+		"test4": Char('a'),
 		"test5": uint64(20),
 		"test6": test6,
 	}
@@ -165,7 +164,7 @@ func Test_PostMap(t *testing.T) {
 
 	m := i.(map[string]interface{})
 	data := m["data"].(string)
-	verifyPost(t, data, 7, "test=value2&test1=2&test2=true&test3=2&test4=a&test5=20&test6=2.344000")
+	verifyPost(t, data, 7, "test=value2&test1=2&test2=true&test3=2&test4=a&test5=20&test6=2.344")
 }
 
 func verifyPost(t *testing.T, data string, length int, actual string) {
@@ -29,7 +29,7 @@ func transform_to_useragent(resp *http.Response, c interface{}) (err error) {
 
 func Test_DoTransform(t *testing.T) {
 	var agent UserAgent
-	err := Get("http://httpbin.org/user-agent").
+	_, err := Get("http://httpbin.org/user-agent").
 		SetUserAgent("httpcl").
 		DoTransform(transform_to_useragent, &agent)
 	if err != nil {
@@ -40,7 +40,7 @@ func Test_DoTransform(t *testing.T) {
 		t.Errorf("Name should be \"%v\" is \"%v\"", "httpcl", agent.Name)
 	}
 
-	err2 := Get("http://httpbin.org/user-agen").SetUserAgent("httpcl").DoTransform(transform_to_useragent, &agent)
+	_, err2 := Get("http://httpbin.org/user-agen").SetUserAgent("httpcl").DoTransform(transform_to_useragent, &agent)
 	if err2.Error() != "status not 200" {
 		t.Error(err2.Error())
 	}
@@ -48,7 +48,7 @@ func Test_DoTransform(t *testing.T) {
 	cl := &Client{}
 	cl.SetUserAgent("httpcl")
 	cl.request, _ = http.NewRequest("GET", "http://httpbin.org/user-agent", nil)
-	err3 := cl.DoTransform(transform_to_useragent, &agent)
+	_, err3 := cl.DoTransform(transform_to_useragent, &agent)
 	if err3 == nil {
 		t.Error("should fail because of no request")
 	}
@@ -56,7 +56,7 @@ func Test_DoTransform(t *testing.T) {
 
 func Test_DoTransformJson(t *testing.T) {
 	var json map[string]interface{}
-	err := Get("http://httpbin.org/user-agent").
+	_, err := Get("http://httpbin.org/user-agent").
 		SetUserAgent("httpcl").
 		DoTransform(TransformToJson, &json)
 
@@ -64,13 +64,13 @@ func Test_DoTransformJson(t *testing.T) {
 		t.Error(err.Error())
 	}
 	if json["user-agent"] != "httpcl" {
-		t.Error("user-agent should be \"httpcl\" is \"%s\"", json["user-agent"])
+		t.Errorf("user-agent should be \"httpcl\" is \"%s\"", json["user-agent"])
 	}
 }
 
 func Test_DoTransformString(t *testing.T) {
 	var str string
-	err := Get("http://httpbin.org/user-agent").
+	_, err := Get("http://httpbin.org/user-agent").
 		SetUserAgent("httpcl").
 		DoTransform(TransformToString, &str)
 
@@ -84,7 +84,7 @@ func Test_DoTransformString(t *testing.T) {
 
 func Test_DoTransformStringFail(t *testing.T) {
 	var c interface{}
-	err := Get("http://httpbin.org/user-agent").
+	_, err := Get("http://httpbin.org/user-agent").
 		SetUserAgent("httpcl").
 		DoTransform(TransformToString, c)
 
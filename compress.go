@@ -0,0 +1,305 @@
+package httpcl
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+//content codings understood by Compression and Decompress
+const (
+	Gzip    = "gzip"
+	Deflate = "deflate"
+	Zstd    = "zstd"
+	Brotli  = "br"
+)
+
+//Compression compresses request bodies with the given content coding while
+//they are sent. Hosts answering 415 Unsupported Media Type get the request
+//again uncompressed and are remembered, so following requests to them are
+//not compressed anymore.
+type Compression struct {
+	Encoding string
+	//bodies with a known length below Threshold are sent as they are,
+	//bodies of unknown length are always compressed
+	Threshold int64
+	//compression level of the encoder, 0 uses its default
+	Level int
+
+	mu          sync.Mutex
+	unsupported map[string]bool
+}
+
+//creates a compressor for the content coding
+func NewCompression(encoding string, threshold int64) *Compression {
+	return &Compression{Encoding: encoding, Threshold: threshold}
+}
+
+//compresses the request body with the content coding
+func (c *Client) Compress(encoding string) *Client {
+	return c.runWithHasRequest(func() {
+		c.Use(NewCompression(encoding, 0).Wrap)
+	})
+}
+
+//compresses request bodies of at least threshold bytes for all requests
+//of the session, hosts rejecting the encoding are remembered
+func (s *Session) Compress(encoding string, threshold int64) *Session {
+	return s.Use(NewCompression(encoding, threshold).Wrap)
+}
+
+//reports if requests to host are sent uncompressed since it answered 415
+func (c *Compression) Unsupported(host string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.unsupported[host]
+}
+
+//Wrap is the Middleware of the compressor
+func (c *Compression) Wrap(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !c.shouldCompress(req) {
+			return next.RoundTrip(req)
+		}
+		switch c.Encoding {
+		case Gzip, Deflate, Zstd, Brotli:
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %q", c.Encoding)
+		}
+
+		r := req.Clone(req.Context())
+		r.Body = compressBody(req.Body, c.Encoding, c.Level)
+		r.ContentLength = -1
+		r.Header.Set("Content-Encoding", c.Encoding)
+		r.Header.Del("Content-Length")
+		if req.GetBody != nil {
+			r.GetBody = func() (io.ReadCloser, error) {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				return compressBody(body, c.Encoding, c.Level), nil
+			}
+		}
+
+		resp, err := next.RoundTrip(r)
+		if err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+			return resp, err
+		}
+		c.mu.Lock()
+		if c.unsupported == nil {
+			c.unsupported = map[string]bool{}
+		}
+		c.unsupported[req.URL.Host] = true
+		c.mu.Unlock()
+		if req.GetBody == nil {
+			//the body is gone, the caller gets the 415
+			return resp, nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		drainBody(resp.Body)
+		retry := req.Clone(req.Context())
+		retry.Body = body
+		return next.RoundTrip(retry)
+	})
+}
+
+func (c *Compression) shouldCompress(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if req.ContentLength > 0 && req.ContentLength < c.Threshold {
+		return false
+	}
+	return !c.Unsupported(req.URL.Host)
+}
+
+//compresses body while it is read, nothing is buffered beyond the encoder
+func compressBody(body io.ReadCloser, encoding string, level int) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := newEncoder(encoding, level, pw)
+		if err == nil {
+			_, err = io.Copy(w, body)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func newEncoder(encoding string, level int, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case Gzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Deflate:
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		return zlib.NewWriterLevel(w, level)
+	case Zstd:
+		if level == 0 {
+			return zstd.NewWriter(w)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	case Brotli:
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(w, level), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+//the Accept-Encoding sent by Decompress
+const acceptEncoding = "zstd, br, gzip, deflate"
+
+//Decompress asks for compressed responses and decodes gzip, deflate, zstd
+//and brotli bodies, the Content-Encoding and Content-Length headers are
+//removed from decoded responses. Requests that set Accept-Encoding
+//themselves are decoded too.
+func Decompress(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("Accept-Encoding") == "" {
+			req = req.Clone(req.Context())
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		decodeResponse(req, resp)
+		return resp, nil
+	})
+}
+
+//asks for a compressed response and decodes it
+func (c *Client) AcceptCompressed() *Client {
+	return c.runWithHasRequest(func() {
+		c.Use(Decompress)
+	})
+}
+
+//asks for compressed responses and decodes them for all requests of the session
+func (s *Session) AcceptCompressed() *Session {
+	return s.Use(Decompress)
+}
+
+//replaces the body of the response with a decoding reader
+func decodeResponse(req *http.Request, resp *http.Response) {
+	header := resp.Header.Get("Content-Encoding")
+	if header == "" || req.Method == "HEAD" || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return
+	}
+	var codings []string
+	for _, coding := range strings.Split(header, ",") {
+		if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+	for _, coding := range codings {
+		if coding != Gzip && coding != "x-gzip" && coding != Deflate && coding != Zstd && coding != Brotli {
+			//unknown codings are handed to the caller as they are
+			return
+		}
+	}
+
+	body := resp.Body
+	//codings are listed in the order they were applied
+	for i := len(codings) - 1; i >= 0; i-- {
+		body = &lazyDecoder{coding: codings[i], src: body}
+	}
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+//creates the decoder on the first read, so empty bodies don't fail
+//because of a missing header
+type lazyDecoder struct {
+	coding string
+	src    io.ReadCloser
+	r      io.Reader
+	close  func()
+	err    error
+}
+
+func (d *lazyDecoder) Read(p []byte) (int, error) {
+	if d.r == nil && d.err == nil {
+		d.r, d.close, d.err = newDecoder(d.coding, d.src)
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *lazyDecoder) Close() error {
+	if d.close != nil {
+		d.close()
+	}
+	return d.src.Close()
+}
+
+func newDecoder(coding string, r io.Reader) (io.Reader, func(), error) {
+	switch coding {
+	case Gzip, "x-gzip":
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gr, func() { gr.Close() }, nil
+	case Deflate:
+		//deflate should be zlib wrapped but some servers send raw deflate
+		br := bufio.NewReader(r)
+		head, err := br.Peek(2)
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if len(head) == 2 && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, nil, err
+			}
+			return zr, func() { zr.Close() }, nil
+		}
+		fr := flate.NewReader(br)
+		return fr, func() { fr.Close() }, nil
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	case Brotli:
+		return brotli.NewReader(r), nil, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported content encoding %q", coding)
+}
+
+//reads a little of the body so the connection can be reused and closes it
+func drainBody(body io.ReadCloser) {
+	io.CopyN(ioutil.Discard, body, 4<<10)
+	body.Close()
+}
@@ -0,0 +1,168 @@
+package httpcl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//answers with the decoded request body and the content encoding it was sent with
+func compressEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.Header.Get("Content-Encoding")
+		body, close, err := newDecoder(encoding, r.Body)
+		if encoding == "" {
+			body, close, err = r.Body, nil, nil
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if close != nil {
+			defer close()
+		}
+		by, err := ioutil.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Encoding", encoding)
+		w.Write(by)
+	}))
+}
+
+func Test_CompressRequest(t *testing.T) {
+	ts := compressEchoServer()
+	defer ts.Close()
+
+	payload := strings.Repeat(`{"id":1,"name":"batch"}`, 200)
+	for _, encoding := range []string{Gzip, Deflate, Zstd, Brotli} {
+		resp, err := Post(ts.URL, bytes.NewBufferString(payload)).Compress(encoding).Do()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		TransformToString(resp, &got)
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: status should be 200 is %v: %s", encoding, resp.StatusCode, got)
+		}
+		if resp.Header.Get("X-Encoding") != encoding {
+			t.Errorf("content encoding should be %s is %s", encoding, resp.Header.Get("X-Encoding"))
+		}
+		if got != payload {
+			t.Errorf("%s: decoded body should be the payload is %q", encoding, got)
+		}
+	}
+
+	_, err := Post(ts.URL, strings.NewReader(payload)).Compress("lzma").Do()
+	if err == nil || !strings.Contains(err.Error(), "unsupported content encoding") {
+		t.Errorf("error should be unsupported content encoding is %v", err)
+	}
+}
+
+func Test_CompressThreshold(t *testing.T) {
+	ts := compressEchoServer()
+	defer ts.Close()
+
+	s := NewSession().Compress(Gzip, 1024)
+	resp, err := s.Post(ts.URL, strings.NewReader("small")).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if enc := resp.Header.Get("X-Encoding"); enc != "" {
+		t.Errorf("small body should not be compressed is %s", enc)
+	}
+
+	resp, err = s.Post(ts.URL, strings.NewReader(strings.Repeat("a", 2048))).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if enc := resp.Header.Get("X-Encoding"); enc != Gzip {
+		t.Errorf("large body should be gzip is %s", enc)
+	}
+}
+
+func Test_CompressUnsupportedMediaType(t *testing.T) {
+	var encodings []string
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		by, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(by))
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		}
+	}))
+	defer ts.Close()
+
+	comp := NewCompression(Zstd, 0)
+	s := NewSession().Use(comp.Wrap)
+	resp, err := s.Post(ts.URL, strings.NewReader("first")).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("status should be 200 after the fallback is %v", resp.StatusCode)
+	}
+	if len(encodings) != 2 || encodings[0] != Zstd || encodings[1] != "" || bodies[1] != "first" {
+		t.Fatalf("request should be retried uncompressed, got %q %q", encodings, bodies)
+	}
+	if !comp.Unsupported(strings.TrimPrefix(ts.URL, "http://")) {
+		t.Error("host should be remembered as unsupported")
+	}
+
+	resp, err = s.Post(ts.URL, strings.NewReader("second")).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(encodings) != 3 || encodings[2] != "" || bodies[2] != "second" {
+		t.Errorf("second request should be sent uncompressed once, got %q %q", encodings, bodies)
+	}
+}
+
+func Test_DecompressResponse(t *testing.T) {
+	payload := strings.Repeat("compressed response ", 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Accept", r.Header.Get("Accept-Encoding"))
+		codings := strings.Split(r.URL.Query().Get("enc"), ",")
+		var buf bytes.Buffer
+		buf.WriteString(payload)
+		for _, coding := range codings {
+			var out bytes.Buffer
+			enc, _ := newEncoder(coding, 0, &out)
+			enc.Write(buf.Bytes())
+			enc.Close()
+			buf = out
+		}
+		w.Header().Set("Content-Encoding", strings.Join(codings, ", "))
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+
+	for _, enc := range []string{Zstd, Brotli, Gzip, Deflate, "gzip,br"} {
+		resp, err := Get(ts.URL + "?enc=" + enc).AcceptCompressed().Do()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if err := TransformToString(resp, &got); err != nil {
+			t.Fatalf("%s: %v", enc, err)
+		}
+		if got != payload {
+			t.Errorf("%s: body should be decoded is %q", enc, got)
+		}
+		if resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("content encoding should be removed is %s", resp.Header.Get("Content-Encoding"))
+		}
+		if resp.Header.Get("X-Accept") != acceptEncoding {
+			t.Errorf("accept encoding should be %s is %s", acceptEncoding, resp.Header.Get("X-Accept"))
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package httpcl
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+//creates a request with the url encoded values as body
+func formRequest(method, purl string, values url.Values) (*http.Request, error) {
+	req, err := http.NewRequest(method, purl, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", formContentType)
+	return req, nil
+}
+
+//creates a request with the reader as body, the content length is set for
+//files and readers reporting their length. The returned function detects the
+//content type when the request is sent, it is nil if the reader can't be
+//peeked without consuming it.
+func readerRequest(method, purl string, r io.Reader) (*http.Request, func() string, error) {
+	req, err := http.NewRequest(method, purl, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.ContentLength == 0 {
+		if length := bodyLength(r); length > 0 {
+			req.ContentLength = length
+		}
+	}
+	return req, contentTypeDetector(r), nil
+}
+
+//returns the length of the reader or -1
+func bodyLength(r io.Reader) int64 {
+	if l, ok := r.(interface{ Len() int }); ok {
+		return int64(l.Len())
+	}
+	if f, ok := r.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			if pos, err := f.Seek(0, io.SeekCurrent); err == nil {
+				return info.Size() - pos
+			}
+		}
+	}
+	return -1
+}
+
+//the content type is taken from a ContentType method, the file extension of
+//an *os.File or sniffed from the first 512 bytes of buffers and seekable
+//readers. Other readers like pipes aren't read before they are sent.
+func contentTypeDetector(r io.Reader) func() string {
+	if t, ok := r.(interface{ ContentType() string }); ok {
+		return t.ContentType
+	}
+	return func() string {
+		if f, ok := r.(*os.File); ok {
+			if t := mime.TypeByExtension(filepath.Ext(f.Name())); t != "" {
+				return t
+			}
+		}
+		switch b := r.(type) {
+		case *bytes.Buffer:
+			return sniff(b.Bytes())
+		case io.ReadSeeker:
+			pos, err := b.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return ""
+			}
+			buf := make([]byte, 512)
+			n, err := io.ReadFull(b, buf)
+			if _, serr := b.Seek(pos, io.SeekStart); serr != nil || (err != nil && err != io.EOF && err != io.ErrUnexpectedEOF) {
+				return ""
+			}
+			return sniff(buf[:n])
+		}
+		return ""
+	}
+}
+
+//a streamed request body reporting its content type, so it is not sniffed
+type typedBody struct {
+	io.ReadCloser
+	contentType string
+}
+
+func (b typedBody) ContentType() string {
+	return b.contentType
+}
+
+//lazyPipe is a pipe whose writer is started by the first Read, so a body
+//that is never sent doesn't leave the writer blocked
+type lazyPipe struct {
+	once  sync.Once
+	pr    *io.PipeReader
+	pw    *io.PipeWriter
+	write func(pw *io.PipeWriter)
+}
+
+func newLazyPipe(write func(pw *io.PipeWriter)) *lazyPipe {
+	pr, pw := io.Pipe()
+	return &lazyPipe{pr: pr, pw: pw, write: write}
+}
+
+func (p *lazyPipe) Read(b []byte) (int, error) {
+	p.once.Do(func() {
+		go p.write(p.pw)
+	})
+	return p.pr.Read(b)
+}
+
+//stops the writer if it was started
+func (p *lazyPipe) Close() error {
+	p.once.Do(func() {})
+	return p.pr.Close()
+}
+
+//detects the content type of data, empty bodies have none
+func sniff(data []byte) string {
+	if len(data) > 512 {
+		data = data[:512]
+	}
+	if len(data) == 0 {
+		return ""
+	}
+	return http.DetectContentType(data)
+}
+
+//sets the detected content type if no Content-Type was set
+func (c *Client) applyContentType() {
+	detect := c.detectType
+	c.detectType = nil
+	if detect == nil || c.request == nil {
+		return
+	}
+	if _, ok := c.request.Header["Content-Type"]; !ok {
+		if t := detect(); t != "" {
+			c.request.Header.Set("Content-Type", t)
+		}
+	}
+}
+
+//sets the content type of the request, replacing the detected one.
+//An empty value removes the header.
+func (c *Client) SetContentType(value string) *Client {
+	return c.runWithHasRequest(func() {
+		c.detectType = nil
+		if value == "" {
+			c.request.Header.Del("Content-Type")
+		} else {
+			c.request.Header.Set("Content-Type", value)
+		}
+	})
+}
+
+//sets the content length of the request, -1 means unknown
+func (c *Client) SetContentLength(length int64) *Client {
+	return c.runWithHasRequest(func() {
+		c.request.ContentLength = length
+	})
+}
@@ -0,0 +1,136 @@
+package httpcl
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type contentRecorder struct {
+	contentType string
+	length      int64
+	body        string
+}
+
+func (rec *contentRecorder) server() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec.contentType = r.Header.Get("Content-Type")
+		rec.length = r.ContentLength
+		by, _ := ioutil.ReadAll(r.Body)
+		rec.body = string(by)
+	}))
+}
+
+func Test_FormContentType(t *testing.T) {
+	rec := &contentRecorder{}
+	ts := rec.server()
+	defer ts.Close()
+
+	for _, cl := range []*Client{
+		Post(ts.URL, "a", "b"),
+		Post(ts.URL, map[string]interface{}{"a": "b"}),
+		Post(ts.URL, formAddress{Street: "b"}),
+		Put(ts.URL, "a", 1),
+	} {
+		cl.Do()
+		if rec.contentType != formContentType {
+			t.Errorf("content type should be %s is %s", formContentType, rec.contentType)
+		}
+		if rec.length != int64(len(rec.body)) {
+			t.Errorf("content length should be %v is %v", len(rec.body), rec.length)
+		}
+	}
+
+	Post(ts.URL, "a", "b").SetContentType("text/plain").Do()
+	if rec.contentType != "text/plain" {
+		t.Errorf("content type should be text/plain is %s", rec.contentType)
+	}
+}
+
+func Test_ReaderContentType(t *testing.T) {
+	rec := &contentRecorder{}
+	ts := rec.server()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "data.json")
+	ioutil.WriteFile(jsonFile, []byte(`{"a":1}`), 0600)
+	plainFile := filepath.Join(dir, "data")
+	ioutil.WriteFile(plainFile, []byte("<html><body>x</body></html>"), 0600)
+
+	f1, _ := os.Open(jsonFile)
+	defer f1.Close()
+	f2, _ := os.Open(plainFile)
+	defer f2.Close()
+	png := "\x89PNG\x0D\x0A\x1A\x0A" + strings.Repeat("x", 600)
+
+	cases := []struct {
+		body        io.Reader
+		contentType string
+		length      int64
+		content     string
+	}{
+		{f1, "application/json", 7, `{"a":1}`},
+		{f2, "text/html; charset=utf-8", 27, "<html><body>x</body></html>"},
+		{strings.NewReader("hello"), "text/plain; charset=utf-8", 5, "hello"},
+		{bytes.NewBufferString(png), "image/png", int64(len(png)), png},
+		{ioutil.NopCloser(strings.NewReader(png)), "", -1, png},
+	}
+	for i, c := range cases {
+		cl := Post(ts.URL, c.body)
+		if cl.Error != nil {
+			t.Fatal(cl.Error.Error())
+		}
+		cl.Do()
+		if rec.contentType != c.contentType {
+			t.Errorf("%d: content type should be %s is %s", i, c.contentType, rec.contentType)
+		}
+		if rec.length != c.length {
+			t.Errorf("%d: content length should be %v is %v", i, c.length, rec.length)
+		}
+		if rec.body != c.content {
+			t.Errorf("%d: body should be %q is %q", i, c.content, rec.body)
+		}
+	}
+}
+
+func Test_ReaderContentTypeSet(t *testing.T) {
+	var contentTypes []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentTypes = r.Header.Values("Content-Type")
+	}))
+	defer ts.Close()
+
+	Post(ts.URL, strings.NewReader(`{"a":1}`)).AddHeader("Content-Type", "application/json").Do()
+	if len(contentTypes) != 1 || contentTypes[0] != "application/json" {
+		t.Errorf("content type should be application/json is %v", contentTypes)
+	}
+	Post(ts.URL, strings.NewReader("hello")).SetContentType("").Do()
+	if len(contentTypes) != 0 {
+		t.Errorf("content type should be removed is %v", contentTypes)
+	}
+}
+
+func Test_ReaderPipe(t *testing.T) {
+	rec := &contentRecorder{}
+	ts := rec.server()
+	defer ts.Close()
+
+	//the pipe is written after the request is built, Post must not read it
+	pr, pw := io.Pipe()
+	cl := Post(ts.URL, pr)
+	go func() {
+		pw.Write([]byte("streamed"))
+		pw.Close()
+	}()
+	cl.Do()
+	if rec.body != "streamed" || rec.contentType != "" {
+		t.Errorf("body should be streamed without content type, got %q %q", rec.body, rec.contentType)
+	}
+}
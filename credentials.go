@@ -0,0 +1,475 @@
+package httpcl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Credentials are the secrets handed out by a CredentialProvider, depending on
+//how they are applied either Username and Password or Token is used
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+	//zero if the credentials don't expire
+	Expires time.Time
+}
+
+//a CredentialProvider is consulted every time a request is sent, so rotated
+//secrets are picked up without recreating the session
+type CredentialProvider interface {
+	Credentials(host string) (Credentials, error)
+}
+
+//a HostScopedProvider hands out credentials meant for particular hosts.
+//Credentials are only sent to the host of the original request, after a
+//redirect to another host only if the provider is scoped to it.
+type HostScopedProvider interface {
+	CredentialProvider
+	ScopedTo(host string) bool
+}
+
+//returns itself, static credentials are a provider too
+func (c Credentials) Credentials(host string) (Credentials, error) {
+	return c, nil
+}
+
+//sets basic auth from the provider when the request is sent
+func BasicAuthFrom(p CredentialProvider) Middleware {
+	return credentialMiddleware(p, func(req *http.Request, c Credentials) {
+		req.SetBasicAuth(c.Username, c.Password)
+	})
+}
+
+//sets a bearer token from the provider when the request is sent
+func BearerAuthFrom(p CredentialProvider) Middleware {
+	return credentialMiddleware(p, func(req *http.Request, c Credentials) {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	})
+}
+
+//sets the header to the token from the provider when the request is sent
+func APIKeyFrom(header string, p CredentialProvider) Middleware {
+	return credentialMiddleware(p, func(req *http.Request, c Credentials) {
+		req.Header.Set(header, c.Token)
+	})
+}
+
+func credentialMiddleware(p CredentialProvider, apply func(*http.Request, Credentials)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if originalRequest(req).URL.Host != req.URL.Host {
+				scoped, ok := p.(HostScopedProvider)
+				if !ok || !scoped.ScopedTo(req.URL.Hostname()) {
+					return next.RoundTrip(req)
+				}
+			}
+			c, err := p.Credentials(req.URL.Hostname())
+			if err != nil {
+				return nil, err
+			}
+			r := req.Clone(req.Context())
+			apply(r, c)
+			return next.RoundTrip(r)
+		})
+	}
+}
+
+//returns the first request of a redirect chain
+func originalRequest(req *http.Request) *http.Request {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req
+}
+
+//sets basic auth from the provider when the request is sent
+func (c *Client) SetBasicAuthFrom(p CredentialProvider) *Client {
+	return c.runWithHasRequest(func() {
+		c.Use(BasicAuthFrom(p))
+	})
+}
+
+//sets a bearer token from the provider when the request is sent
+func (c *Client) SetBearerAuthFrom(p CredentialProvider) *Client {
+	return c.runWithHasRequest(func() {
+		c.Use(BearerAuthFrom(p))
+	})
+}
+
+//sets the header to the token from the provider when the request is sent
+func (c *Client) SetAPIKeyFrom(header string, p CredentialProvider) *Client {
+	return c.runWithHasRequest(func() {
+		c.Use(APIKeyFrom(header, p))
+	})
+}
+
+//sets basic auth from the provider for all requests of the session
+func (s *Session) SetBasicAuthFrom(p CredentialProvider) *Session {
+	return s.Use(BasicAuthFrom(p))
+}
+
+//sets a bearer token from the provider for all requests of the session
+func (s *Session) SetBearerAuthFrom(p CredentialProvider) *Session {
+	return s.Use(BearerAuthFrom(p))
+}
+
+//sets the header to the token from the provider for all requests of the session
+func (s *Session) SetAPIKeyFrom(header string, p CredentialProvider) *Session {
+	return s.Use(APIKeyFrom(header, p))
+}
+
+//tries each provider in order and returns the first credentials found
+type CredentialChain []CredentialProvider
+
+func (chain CredentialChain) Credentials(host string) (Credentials, error) {
+	var errs []string
+	for _, p := range chain {
+		c, err := p.Credentials(host)
+		if err == nil {
+			return c, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return Credentials{}, fmt.Errorf("no credentials: %s", strings.Join(errs, "; "))
+}
+
+//reads the credentials from environment variables on every request,
+//empty variable names are skipped
+type EnvCredentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+func (e EnvCredentials) Credentials(host string) (Credentials, error) {
+	var c Credentials
+	found := false
+	lookup := func(name string, dst *string) {
+		if name == "" {
+			return
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			*dst = value
+			found = true
+		}
+	}
+	lookup(e.Username, &c.Username)
+	lookup(e.Password, &c.Password)
+	lookup(e.Token, &c.Token)
+	if !found {
+		return c, errors.New("credentials not found in environment")
+	}
+	return c, nil
+}
+
+//reads the credentials from a file that is read again whenever it changes.
+//A file holding a JSON object is decoded into username, password, token and
+//expires (RFC 3339), otherwise the trimmed content is the token or, with
+//UserPass set, "username:password".
+type FileCredentials struct {
+	Path     string
+	UserPass bool
+
+	cache fileCache
+}
+
+func (f *FileCredentials) Credentials(host string) (Credentials, error) {
+	v, err := f.cache.load(f.Path, func(b []byte) (interface{}, error) {
+		b = bytes.TrimSpace(b)
+		if len(b) > 0 && b[0] == '{' {
+			return decodeCredentialJSON(b)
+		}
+		if f.UserPass {
+			i := bytes.IndexByte(b, ':')
+			if i < 0 {
+				return nil, fmt.Errorf("%s does not contain username:password", f.Path)
+			}
+			return Credentials{Username: string(b[:i]), Password: string(b[i+1:])}, nil
+		}
+		return Credentials{Token: string(b)}, nil
+	})
+	if err != nil {
+		return Credentials{}, err
+	}
+	return v.(Credentials), nil
+}
+
+//reads the login and password for the host from a .netrc file, which is read
+//again whenever it changes. An empty Path means $NETRC or ~/.netrc.
+type NetrcCredentials struct {
+	Path string
+
+	cache fileCache
+}
+
+type netrcMachine struct {
+	login    string
+	password string
+}
+
+func (n *NetrcCredentials) Credentials(host string) (Credentials, error) {
+	machines, err := n.machines()
+	if err != nil {
+		return Credentials{}, err
+	}
+	m, ok := machines[strings.ToLower(host)]
+	if !ok {
+		m, ok = machines[""]
+	}
+	if !ok {
+		return Credentials{}, fmt.Errorf("no netrc entry for %s", host)
+	}
+	return Credentials{Username: m.login, Password: m.password}, nil
+}
+
+//reports whether the file has a machine entry for the host, the default
+//entry isn't scoped to a host
+func (n *NetrcCredentials) ScopedTo(host string) bool {
+	machines, err := n.machines()
+	if err != nil {
+		return false
+	}
+	_, ok := machines[strings.ToLower(host)]
+	return ok
+}
+
+func (n *NetrcCredentials) machines() (map[string]netrcMachine, error) {
+	path := n.Path
+	if path == "" {
+		path = os.Getenv("NETRC")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	v, err := n.cache.load(path, func(b []byte) (interface{}, error) {
+		return parseNetrc(b), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]netrcMachine), nil
+}
+
+//parses a netrc file, the default entry is stored with an empty name
+func parseNetrc(b []byte) map[string]netrcMachine {
+	machines := map[string]netrcMachine{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+
+	var tokens []string
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			//a macro definition ends with an empty line
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		for i, f := range fields {
+			if f == "macdef" {
+				tokens = append(tokens, fields[:i]...)
+				inMacro = true
+				break
+			}
+		}
+		if !inMacro {
+			tokens = append(tokens, fields...)
+		}
+	}
+
+	name := ""
+	current := netrcMachine{}
+	inEntry := false
+	flush := func() {
+		if inEntry {
+			if _, ok := machines[name]; !ok {
+				machines[name] = current
+			}
+		}
+	}
+	for i := 0; i < len(tokens); i++ {
+		next := func() string {
+			if i+1 < len(tokens) {
+				i++
+				return tokens[i]
+			}
+			return ""
+		}
+		switch tokens[i] {
+		case "machine":
+			flush()
+			name, current, inEntry = strings.ToLower(next()), netrcMachine{}, true
+		case "default":
+			flush()
+			name, current, inEntry = "", netrcMachine{}, true
+		case "login":
+			current.login = next()
+		case "password":
+			current.password = next()
+		case "account":
+			next()
+		}
+	}
+	flush()
+	return machines
+}
+
+//runs a command that prints the credentials as JSON, like the
+//credential_process of the AWS cli. The output may use the keys username,
+//password, token and expires or AccessKeyId, SecretAccessKey, SessionToken
+//and Expiration. The credentials are cached until they expire or TTL passes.
+type CommandCredentials struct {
+	Command []string
+	//how long credentials without expiry are cached, forever if 0
+	TTL time.Duration
+	//credentials are refreshed this long before they expire, defaults to a minute
+	Margin time.Duration
+
+	mu      sync.Mutex
+	cached  *Credentials
+	fetched time.Time
+}
+
+func (c *CommandCredentials) Credentials(host string) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	margin := c.Margin
+	if margin == 0 {
+		margin = time.Minute
+	}
+	now := time.Now()
+	if c.cached != nil {
+		fresh := true
+		if !c.cached.Expires.IsZero() && now.Add(margin).After(c.cached.Expires) {
+			fresh = false
+		}
+		if c.cached.Expires.IsZero() && c.TTL > 0 && now.Sub(c.fetched) > c.TTL {
+			fresh = false
+		}
+		if fresh {
+			return *c.cached, nil
+		}
+	}
+
+	if len(c.Command) == 0 {
+		return Credentials{}, errors.New("no credential command")
+	}
+	cmd := exec.Command(c.Command[0], c.Command[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("credential command failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	creds, err := decodeCredentialJSON(out)
+	if err != nil {
+		return Credentials{}, err
+	}
+	c.cached = &creds
+	c.fetched = now
+	return creds, nil
+}
+
+func decodeCredentialJSON(b []byte) (Credentials, error) {
+	var raw struct {
+		Username        string `json:"username"`
+		Password        string `json:"password"`
+		Token           string `json:"token"`
+		Expires         string `json:"expires"`
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		SessionToken    string `json:"SessionToken"`
+		Expiration      string `json:"Expiration"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return Credentials{}, err
+	}
+	c := Credentials{Username: raw.Username, Password: raw.Password, Token: raw.Token}
+	if raw.AccessKeyID != "" {
+		c.Username, c.Password, c.Token = raw.AccessKeyID, raw.SecretAccessKey, raw.SessionToken
+	}
+	expires := raw.Expires
+	if expires == "" {
+		expires = raw.Expiration
+	}
+	if expires != "" {
+		t, err := time.Parse(time.RFC3339, expires)
+		if err != nil {
+			return c, err
+		}
+		c.Expires = t
+	}
+	return c, nil
+}
+
+//adapts a CredentialProvider to sign AWS requests, Username is the access key
+//id, Password the secret key and Token the session token
+func AWSCredentialsFrom(p CredentialProvider) AWSCredentialsProvider {
+	return awsCredentialAdapter{p}
+}
+
+type awsCredentialAdapter struct {
+	p CredentialProvider
+}
+
+func (a awsCredentialAdapter) Retrieve() (AWSCredentials, error) {
+	c, err := a.p.Credentials("")
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	return AWSCredentials{AccessKeyID: c.Username, SecretAccessKey: c.Password, SessionToken: c.Token}.Retrieve()
+}
+
+//caches the parsed content of a file until its size or modification time changes
+type fileCache struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	value   interface{}
+}
+
+func (f *fileCache) load(path string, parse func([]byte) (interface{}, error)) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if f.value != nil && f.path == path && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	value, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	f.path, f.modTime, f.size, f.value = path, info.ModTime(), info.Size(), value
+	return value, nil
+}
@@ -0,0 +1,165 @@
+package httpcl
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_FileCredentialsRotation(t *testing.T) {
+	var auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "token")
+	ioutil.WriteFile(path, []byte("first\n"), 0600)
+	s := NewSession().SetBearerAuthFrom(&FileCredentials{Path: path})
+
+	s.Get(ts.URL).Do()
+	if auth != "Bearer first" {
+		t.Errorf("Authorization should be \"Bearer first\" is \"%s\"", auth)
+	}
+
+	ioutil.WriteFile(path, []byte("second-token"), 0600)
+	os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second))
+	s.Get(ts.URL).Do()
+	if auth != "Bearer second-token" {
+		t.Errorf("Authorization should be \"Bearer second-token\" is \"%s\"", auth)
+	}
+}
+
+func Test_FileCredentialsFormats(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "json"), []byte(`{"username":"u","password":"p"}`), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "userpass"), []byte("user:pa:ss\n"), 0600)
+
+	c, err := (&FileCredentials{Path: filepath.Join(dir, "json")}).Credentials("")
+	if err != nil || c.Username != "u" || c.Password != "p" {
+		t.Errorf("unexpected credentials %v %v", c, err)
+	}
+	c, err = (&FileCredentials{Path: filepath.Join(dir, "userpass"), UserPass: true}).Credentials("")
+	if err != nil || c.Username != "user" || c.Password != "pa:ss" {
+		t.Errorf("unexpected credentials %v %v", c, err)
+	}
+	if _, err := (&FileCredentials{Path: filepath.Join(dir, "missing")}).Credentials(""); err == nil {
+		t.Error("missing file should fail")
+	}
+}
+
+func Test_NetrcCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	ioutil.WriteFile(path, []byte(`# comment
+machine api.example.com login alice password secret1
+macdef init
+  machine evil.example.com login x password y
+
+machine other.example.com
+  login bob
+  password secret2
+default login anon password guest
+`), 0600)
+	n := &NetrcCredentials{Path: path}
+
+	for host, expected := range map[string]Credentials{
+		"api.example.com":   {Username: "alice", Password: "secret1"},
+		"other.example.com": {Username: "bob", Password: "secret2"},
+		"evil.example.com":  {Username: "anon", Password: "guest"},
+	} {
+		c, err := n.Credentials(host)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if c != expected {
+			t.Errorf("%s: credentials should be %v are %v", host, expected, c)
+		}
+	}
+}
+
+func Test_EnvCredentials(t *testing.T) {
+	os.Setenv("HTTPCL_TEST_USER", "user")
+	os.Setenv("HTTPCL_TEST_PASS", "passwd")
+	defer os.Unsetenv("HTTPCL_TEST_USER")
+	defer os.Unsetenv("HTTPCL_TEST_PASS")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "passwd" {
+			w.WriteHeader(401)
+		}
+	}))
+	defer ts.Close()
+
+	cl := Get(ts.URL).SetBasicAuthFrom(EnvCredentials{Username: "HTTPCL_TEST_USER", Password: "HTTPCL_TEST_PASS"})
+	cl.Do()
+	if cl.StatusCode != 200 {
+		t.Errorf("statuscode should be 200 is %v", cl.StatusCode)
+	}
+
+	if _, err := (EnvCredentials{Token: "HTTPCL_TEST_MISSING"}).Credentials(""); err == nil {
+		t.Error("missing variable should fail")
+	}
+}
+
+func Test_CommandCredentials(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "count")
+	c := &CommandCredentials{
+		Command: []string{"sh", "-c", `echo x >> ` + counter + `; echo '{"Version":1,"AccessKeyId":"AK","SecretAccessKey":"SK","SessionToken":"ST","Expiration":"2999-01-01T00:00:00Z"}'`},
+	}
+	for i := 0; i < 2; i++ {
+		creds, err := AWSCredentialsFrom(c).Retrieve()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if creds.AccessKeyID != "AK" || creds.SecretAccessKey != "SK" || creds.SessionToken != "ST" {
+			t.Errorf("unexpected credentials %v", creds)
+		}
+	}
+	by, _ := ioutil.ReadFile(counter)
+	if string(by) != "x\n" {
+		t.Errorf("command should run once, ran %q", by)
+	}
+
+	failing := &CommandCredentials{Command: []string{"sh", "-c", "echo nope >&2; exit 1"}}
+	if _, err := failing.Credentials(""); err == nil {
+		t.Error("failing command should fail")
+	}
+}
+
+func Test_CredentialsRedirect(t *testing.T) {
+	var headers []http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header)
+	}))
+	defer other.Close()
+	var auth, key string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, key = r.Header.Get("Authorization"), r.Header.Get("X-Api-Key")
+		http.Redirect(w, r, other.URL, http.StatusFound)
+	}))
+	defer ts.Close()
+
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	ioutil.WriteFile(netrc, []byte("default login anon password guest\n"), 0600)
+	s := NewSession().
+		SetBearerAuthFrom(Credentials{Token: "secret"}).
+		SetAPIKeyFrom("X-Api-Key", Credentials{Token: "key"})
+	s.Get(ts.URL).Do()
+	NewSession().SetBasicAuthFrom(&NetrcCredentials{Path: netrc}).Get(ts.URL).Do()
+	if auth != "Basic YW5vbjpndWVzdA==" || key != "" {
+		t.Errorf("original host should get the credentials, got %s %s", auth, key)
+	}
+	if len(headers) != 2 {
+		t.Fatalf("redirect target should have received 2 requests, received %v", len(headers))
+	}
+	for _, h := range headers {
+		if h.Get("Authorization") != "" || h.Get("X-Api-Key") != "" {
+			t.Errorf("redirect target should get no credentials, got %v", h)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package httpcl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+//DestinationPolicy restricts the destinations a session connects to, to
+//send requests to urls built from user input. Host names are resolved when
+//the connection is opened and only addresses passing the policy are dialed,
+//so a host can't resolve to another address after the check. Every
+//redirect is checked again.
+//
+//With a proxy the proxy address is dialed and has to be allowed, the hosts
+//requested through the proxy are resolved and checked before the request.
+type DestinationPolicy struct {
+	//allowed url schemes, defaults to http and https
+	Schemes []string
+	//allowed ports, defaults to 80 and 443
+	Ports []int
+	//allow loopback, private, link-local and other special purpose
+	//addresses, which are blocked by default
+	AllowPrivate bool
+	//cidr ranges or addresses that are allowed even if they are blocked
+	Allow []string
+	//cidr ranges or addresses that are blocked in addition
+	Deny []string
+	//resolves host names, defaults to net.DefaultResolver
+	LookupIP func(ctx context.Context, host string) ([]net.IP, error)
+}
+
+//ErrDestinationBlocked is returned for requests the DestinationPolicy
+//doesn't allow
+type ErrDestinationBlocked struct {
+	URL  string
+	Host string
+	//the blocked address, nil if the scheme or port is blocked
+	IP net.IP
+	//like loopback, private, link-local, denied, scheme or port
+	Reason string
+}
+
+func (e *ErrDestinationBlocked) Error() string {
+	if e.IP != nil {
+		return fmt.Sprintf("destination blocked: %s resolves to %s address %s", e.Host, e.Reason, e.IP)
+	}
+	return fmt.Sprintf("destination blocked: %s of %s not allowed", e.Reason, e.URL)
+}
+
+//special purpose ranges blocked unless AllowPrivate is set, besides the ones
+//netip reports as loopback, private, link-local, multicast or unspecified
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+//restricts the destinations of the session. The policy is checked by the
+//dialer of the session, so it stays in place when UnixSocket, SetProxy or
+//SetTLS are called later. The socket of UnixSocket is configured by the
+//session and isn't checked. An invalid policy is returned as error of every
+//request.
+func (s *Session) SetDestinationPolicy(p DestinationPolicy) *Session {
+	g, err := p.compile()
+	if err != nil {
+		return s.Use(errorMiddleware(err))
+	}
+	s.guard = g
+	s.useDialer()
+	s.transportChanged()
+	return s.Use(func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if err := g.checkURL(req); err != nil {
+				return nil, err
+			}
+			//the proxy resolves the host, so all its addresses have to pass
+			if u, err := s.requestProxy(req); err == nil && u != nil {
+				if _, err := g.resolveAll(req.Context(), req.URL.Hostname()); err != nil {
+					return nil, err
+				}
+			}
+			return next.RoundTrip(req)
+		})
+	})
+}
+
+type destinationGuard struct {
+	schemes  []string
+	ports    []string
+	private  bool
+	allow    []netip.Prefix
+	deny     []netip.Prefix
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+}
+
+func (p DestinationPolicy) compile() (*destinationGuard, error) {
+	g := &destinationGuard{schemes: p.Schemes, private: p.AllowPrivate, lookupIP: p.LookupIP}
+	if len(g.schemes) == 0 {
+		g.schemes = []string{"http", "https"}
+	}
+	ports := p.Ports
+	if len(ports) == 0 {
+		ports = []int{80, 443}
+	}
+	for _, port := range ports {
+		g.ports = append(g.ports, strconv.Itoa(port))
+	}
+	var err error
+	if g.allow, err = parsePrefixes(p.Allow); err != nil {
+		return nil, err
+	}
+	if g.deny, err = parsePrefixes(p.Deny); err != nil {
+		return nil, err
+	}
+	if g.lookupIP == nil {
+		g.lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		}
+	}
+	return g, nil
+}
+
+//parses cidr ranges and single addresses
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr range %q", s)
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (g *destinationGuard) checkURL(req *http.Request) error {
+	u := req.URL
+	scheme := strings.ToLower(u.Scheme)
+	if !contains(g.schemes, scheme, true) {
+		return &ErrDestinationBlocked{URL: u.String(), Host: u.Hostname(), Reason: "scheme"}
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort(scheme)
+	}
+	if !contains(g.ports, port, false) {
+		return &ErrDestinationBlocked{URL: u.String(), Host: u.Hostname(), Reason: "port"}
+	}
+	return nil
+}
+
+//dials the first allowed address of the host in addr
+func (g *destinationGuard) dial(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := g.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		conn, derr := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+		if derr == nil {
+			return conn, nil
+		}
+		err = derr
+	}
+	return nil, err
+}
+
+//returns the reason the address is blocked, empty if it is allowed
+func (g *destinationGuard) blocked(addr netip.Addr) string {
+	addr = addr.Unmap()
+	for _, prefix := range g.allow {
+		if prefix.Contains(addr) {
+			return ""
+		}
+	}
+	for _, prefix := range g.deny {
+		if prefix.Contains(addr) {
+			return "denied"
+		}
+	}
+	if g.private {
+		return ""
+	}
+	switch {
+	case addr.IsLoopback():
+		return "loopback"
+	case addr.IsPrivate():
+		return "private"
+	case addr.IsLinkLocalUnicast(), addr.IsLinkLocalMulticast():
+		return "link-local"
+	case addr.IsMulticast(), addr.IsInterfaceLocalMulticast():
+		return "multicast"
+	case addr.IsUnspecified():
+		return "unspecified"
+	case addr == netip.AddrFrom4([4]byte{255, 255, 255, 255}):
+		return "broadcast"
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return "reserved"
+		}
+	}
+	return ""
+}
+
+func (g *destinationGuard) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr}, nil
+	}
+	ips, err := g.lookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []netip.Addr
+	for _, ip := range ips {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address for %s", host)
+	}
+	return addrs, nil
+}
+
+//returns the allowed addresses of the host, an error if all are blocked
+func (g *destinationGuard) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	addrs, err := g.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var allowed []netip.Addr
+	var blockedErr error
+	for _, addr := range addrs {
+		if reason := g.blocked(addr); reason != "" {
+			if blockedErr == nil {
+				blockedErr = &ErrDestinationBlocked{Host: host, IP: net.IP(addr.AsSlice()), Reason: reason}
+			}
+			continue
+		}
+		allowed = append(allowed, addr)
+	}
+	if len(allowed) == 0 {
+		return nil, blockedErr
+	}
+	return allowed, nil
+}
+
+//returns the addresses of the host, an error if any of them is blocked
+func (g *destinationGuard) resolveAll(ctx context.Context, host string) ([]netip.Addr, error) {
+	addrs, err := g.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if reason := g.blocked(addr); reason != "" {
+			return nil, &ErrDestinationBlocked{Host: host, IP: net.IP(addr.AsSlice()), Reason: reason}
+		}
+	}
+	return addrs, nil
+}
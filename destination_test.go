@@ -0,0 +1,272 @@
+package httpcl
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+//returns the port of the test server
+func serverPort(t *testing.T, ts *httptest.Server) int {
+	u, _ := url.Parse(ts.URL)
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+//resolves hosts from the map
+func staticLookup(hosts map[string]string) func(ctx context.Context, host string) ([]net.IP, error) {
+	return func(ctx context.Context, host string) ([]net.IP, error) {
+		ip, ok := hosts[host]
+		if !ok {
+			return nil, errors.New("no such host " + host)
+		}
+		return []net.IP{net.ParseIP(ip)}, nil
+	}
+}
+
+func blockedReason(err error) string {
+	var blocked *ErrDestinationBlocked
+	if !errors.As(err, &blocked) {
+		return ""
+	}
+	return blocked.Reason
+}
+
+func Test_DestinationBlocked(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	port := serverPort(t, ts)
+
+	_, err := NewSession().SetDestinationPolicy(DestinationPolicy{Ports: []int{port}}).Get(ts.URL).Do()
+	var blocked *ErrDestinationBlocked
+	if !errors.As(err, &blocked) {
+		t.Fatalf("loopback should be blocked, got %v", err)
+	}
+	if blocked.Reason != "loopback" || !blocked.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("127.0.0.1 should be blocked as loopback, got %v", blocked)
+	}
+
+	if _, err := NewSession().SetDestinationPolicy(DestinationPolicy{AllowPrivate: true}).Get(ts.URL).Do(); blockedReason(err) != "port" {
+		t.Errorf("port %v should be blocked, got %v", port, err)
+	}
+	if _, err := NewSession().SetDestinationPolicy(DestinationPolicy{}).Get("ftp://example.com/").Do(); blockedReason(err) != "scheme" {
+		t.Errorf("ftp should be blocked, got %v", err)
+	}
+	if _, err := NewSession().SetDestinationPolicy(DestinationPolicy{}).Get("http+unix://%2Ftmp%2Fd.sock/").Do(); blockedReason(err) != "scheme" {
+		t.Errorf("unix sockets should be blocked, got %v", err)
+	}
+
+	var body string
+	s := NewSession().SetDestinationPolicy(DestinationPolicy{Ports: []int{port}, Allow: []string{"127.0.0.1"}})
+	if _, err := s.Get(ts.URL).DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "ok" {
+		t.Errorf("response should be ok is %s", body)
+	}
+
+	s = NewSession().SetDestinationPolicy(DestinationPolicy{Ports: []int{port}, AllowPrivate: true, Deny: []string{"127.0.0.0/8"}})
+	if _, err := s.Get(ts.URL).Do(); blockedReason(err) != "denied" {
+		t.Errorf("denied range should be blocked, got %v", err)
+	}
+
+	s = NewSession().SetDestinationPolicy(DestinationPolicy{Deny: []string{"10.0.0.0/33"}})
+	if _, err := s.Get(ts.URL).Do(); err == nil || blockedReason(err) != "" {
+		t.Errorf("invalid policy should fail, got %v", err)
+	}
+}
+
+func Test_DestinationResolve(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer ts.Close()
+	port := serverPort(t, ts)
+
+	//the first lookup is allowed, the next connection resolves to the
+	//metadata address
+	var lookups int32
+	s := NewSession().SetDestinationPolicy(DestinationPolicy{
+		Ports: []int{port},
+		Allow: []string{"127.0.0.1"},
+		LookupIP: func(ctx context.Context, host string) ([]net.IP, error) {
+			if atomic.AddInt32(&lookups, 1) == 1 {
+				return []net.IP{net.ParseIP("169.254.169.254"), net.ParseIP("127.0.0.1")}, nil
+			}
+			return []net.IP{net.ParseIP("169.254.169.254")}, nil
+		},
+	})
+	target := "http://app.test:" + strconv.Itoa(port) + "/"
+	var body string
+	if _, err := s.Get(target).DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "app.test:"+strconv.Itoa(port) {
+		t.Errorf("response should be the host is %s", body)
+	}
+	s.Transport().CloseIdleConnections()
+	if _, err := s.Get(target).Do(); blockedReason(err) != "link-local" {
+		t.Errorf("rebound host should be blocked as link-local, got %v", err)
+	}
+}
+
+func Test_DestinationRedirect(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("other"))
+	}))
+	defer other.Close()
+	var port int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/port":
+			http.Redirect(w, r, other.URL, http.StatusFound)
+		case "/metadata":
+			http.Redirect(w, r, "http://metadata.test:"+strconv.Itoa(port)+"/", http.StatusFound)
+		case "/done":
+			w.Write([]byte("done"))
+		default:
+			http.Redirect(w, r, "/done", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+	port = serverPort(t, ts)
+
+	s := NewSession().SetDestinationPolicy(DestinationPolicy{
+		Ports:    []int{port},
+		Allow:    []string{"127.0.0.1"},
+		LookupIP: staticLookup(map[string]string{"metadata.test": "169.254.169.254"}),
+	})
+	resp, err := s.Get(ts.URL + "/start").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/done" {
+		t.Errorf("allowed redirect should be followed to /done, got %s", resp.Request.URL.Path)
+	}
+	if _, err := s.Get(ts.URL + "/port").Do(); blockedReason(err) != "port" {
+		t.Errorf("redirect to another port should be blocked, got %v", err)
+	}
+	if _, err := s.Get(ts.URL + "/metadata").Do(); blockedReason(err) != "link-local" {
+		t.Errorf("redirect to the metadata address should be blocked, got %v", err)
+	}
+}
+
+func Test_DestinationProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	s := NewSession().SetProxyURL(proxy.URL).SetDestinationPolicy(DestinationPolicy{
+		Allow: []string{"127.0.0.1"},
+		LookupIP: staticLookup(map[string]string{
+			"example.test":  "93.184.216.34",
+			"metadata.test": "169.254.169.254",
+		}),
+	})
+	var body string
+	if _, err := s.Get("http://example.test/a").DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "proxied http://example.test/a" {
+		t.Errorf("response should be proxied http://example.test/a is %s", body)
+	}
+	if _, err := s.Get("http://metadata.test/latest").Do(); blockedReason(err) != "link-local" {
+		t.Errorf("metadata address behind the proxy should be blocked, got %v", err)
+	}
+}
+
+func Test_DestinationRanges(t *testing.T) {
+	g, err := DestinationPolicy{Deny: []string{"8.8.8.0/24"}, Allow: []string{"::ffff:10.1.0.0/112"}}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"93.184.216.34":      "",
+		"8.8.8.8":            "denied",
+		"10.2.3.4":           "private",
+		"10.1.2.3":           "",
+		"172.16.0.1":         "private",
+		"192.168.1.1":        "private",
+		"127.0.0.1":          "loopback",
+		"::ffff:127.0.0.1":   "loopback",
+		"::1":                "loopback",
+		"169.254.169.254":    "link-local",
+		"fe80::1":            "link-local",
+		"fd00::1":            "private",
+		"0.0.0.0":            "unspecified",
+		"::":                 "unspecified",
+		"239.1.2.3":          "multicast",
+		"255.255.255.255":    "broadcast",
+		"100.64.0.1":         "reserved",
+		"64:ff9b::a9fe:a9fe": "reserved",
+		"2606:4700::1111":    "",
+	}
+	for ip, want := range tests {
+		if reason := g.blocked(netip.MustParseAddr(ip)); reason != want {
+			t.Errorf("%s should be blocked as %q is %q", ip, want, reason)
+		}
+	}
+}
+
+func Test_DestinationUnixSocket(t *testing.T) {
+	path, _ := unixServer(t)
+	for name, s := range map[string]*Session{
+		"policy first": NewSession().SetDestinationPolicy(DestinationPolicy{}).UnixSocket(path),
+		"socket first": NewSession().UnixSocket(path).SetDestinationPolicy(DestinationPolicy{}),
+	} {
+		var body string
+		if _, err := s.Get("http://docker/version").DoTransform(TransformToString, &body); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if body != "GET docker /version " {
+			t.Errorf("%s: response should be GET docker /version is %s", name, body)
+		}
+		if _, err := s.Get("http://docker:8080/").Do(); blockedReason(err) != "port" {
+			t.Errorf("%s: port 8080 should be blocked, got %v", name, err)
+		}
+		if _, err := s.Get("http+unix://" + url.PathEscape(path) + "/").Do(); blockedReason(err) != "scheme" {
+			t.Errorf("%s: unix urls should be blocked, got %v", name, err)
+		}
+	}
+
+	//the http+unix transport checks the scheme when it dials
+	s := NewSession()
+	s.guard, _ = DestinationPolicy{}.compile()
+	if _, err := s.Get("http+unix://" + url.PathEscape(path) + "/").Do(); blockedReason(err) != "scheme" {
+		t.Errorf("dialing the socket should be blocked, got %v", err)
+	}
+}
+
+func Test_DestinationLaterConfig(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	port := serverPort(t, ts)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+
+	for name, s := range map[string]*Session{
+		"policy first": NewSession().SetDestinationPolicy(DestinationPolicy{Ports: []int{port}}).SetTLS(TLSOptions{CAPEM: ca}),
+		"tls first":    NewSession().SetTLS(TLSOptions{CAPEM: ca}).SetDestinationPolicy(DestinationPolicy{Ports: []int{port}}),
+		"proxy later":  NewSession().SetDestinationPolicy(DestinationPolicy{Ports: []int{port}}).SetProxyURL(ts.URL),
+	} {
+		if _, err := s.Get(ts.URL).Do(); blockedReason(err) != "loopback" {
+			t.Errorf("%s: loopback should be blocked, got %v", name, err)
+		}
+	}
+}
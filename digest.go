@@ -0,0 +1,437 @@
+package httpcl
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+//DigestAuth answers HTTP Digest challenges (RFC 7616). The last challenge
+//of every origin is remembered so following requests to the same scheme and
+//host authenticate without another 401, other hosts don't get credentials
+//before they send a challenge.
+type DigestAuth struct {
+	Username string
+	Password string
+
+	mu     sync.Mutex
+	states map[string]*digestState
+}
+
+//the challenge of an origin
+type digestState struct {
+	chal   *digestChallenge
+	nc     uint32
+	cnonce string
+}
+
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       []string
+	stale     bool
+	userhash  bool
+}
+
+//creates a new digest authenticator
+func NewDigestAuth(user, password string) *DigestAuth {
+	return &DigestAuth{Username: user, Password: password}
+}
+
+//sets digest auth credentials for the request
+func (c *Client) SetDigestAuth(user, password string) *Client {
+	return c.runWithHasRequest(func() {
+		c.Use(NewDigestAuth(user, password).Wrap)
+	})
+}
+
+//sets digest auth credentials for all requests of the session,
+//the nonce is reused between the requests
+func (s *Session) SetDigestAuth(user, password string) *Session {
+	return s.Use(NewDigestAuth(user, password).Wrap)
+}
+
+//Wrap is the Middleware of the authenticator
+func (d *DigestAuth) Wrap(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return d.roundTrip(next, req)
+	})
+}
+
+func (d *DigestAuth) roundTrip(next http.RoundTripper, req *http.Request) (*http.Response, error) {
+	//the body is only buffered if it can't be read again with GetBody
+	body, getBody := req.Body, req.GetBody
+	if body != nil && body != http.NoBody && getBody == nil {
+		b, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		getBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(b)), nil
+		}
+		body, _ = getBody()
+	}
+
+	origin := digestOrigin(req)
+	sent, err := d.send(next, req, origin, body, getBody)
+	if err != nil {
+		return nil, err
+	}
+	resp := sent.resp
+	if resp.StatusCode != http.StatusUnauthorized {
+		d.nextNonce(origin, resp)
+		return resp, nil
+	}
+
+	chal := pickDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+	if chal == nil {
+		return resp, nil
+	}
+	//the nonce that was just answered is rejected again, the credentials are
+	//wrong. A new nonce replaces the cached challenge and is answered once,
+	//even if the server doesn't mark the old one as stale.
+	if sent.nonce == chal.nonce {
+		return resp, nil
+	}
+	if body != nil && body != http.NoBody {
+		if body, err = getBody(); err != nil {
+			return resp, nil
+		}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	d.mu.Lock()
+	if d.states == nil {
+		d.states = map[string]*digestState{}
+	}
+	d.states[origin] = &digestState{chal: chal, cnonce: newCnonce()}
+	d.mu.Unlock()
+
+	sent, err = d.send(next, req, origin, body, getBody)
+	if err != nil {
+		return nil, err
+	}
+	d.nextNonce(origin, sent.resp)
+	return sent.resp, nil
+}
+
+//returns the scheme and host the challenges are kept for
+func digestOrigin(req *http.Request) string {
+	return strings.ToLower(req.URL.Scheme + "://" + req.URL.Host)
+}
+
+type digestSent struct {
+	resp  *http.Response
+	nonce string
+}
+
+//sends a copy of req with body, authorized with the challenge of the origin
+//if there is one
+func (d *DigestAuth) send(next http.RoundTripper, req *http.Request, origin string, body io.ReadCloser, getBody func() (io.ReadCloser, error)) (digestSent, error) {
+	r := req.Clone(req.Context())
+	r.Body, r.GetBody = body, getBody
+
+	var sent digestSent
+	d.mu.Lock()
+	st := d.states[origin]
+	var qop string
+	if st != nil {
+		qop = st.chal.pickQop()
+	}
+	d.mu.Unlock()
+	if st == nil {
+		resp, err := next.RoundTrip(r)
+		sent.resp = resp
+		return sent, err
+	}
+
+	//auth-int hashes the body, it is read from a copy
+	var entity []byte
+	if qop == "auth-int" && body != nil && body != http.NoBody {
+		rc, err := getBody()
+		if err != nil {
+			body.Close()
+			return sent, err
+		}
+		entity, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			body.Close()
+			return sent, err
+		}
+	}
+
+	d.mu.Lock()
+	sent.nonce = st.chal.nonce
+	r.Header.Set("Authorization", d.authorization(st, r.Method, r.URL.RequestURI(), entity))
+	d.mu.Unlock()
+
+	resp, err := next.RoundTrip(r)
+	sent.resp = resp
+	return sent, err
+}
+
+//switches to the nextnonce the server announced in Authentication-Info
+func (d *DigestAuth) nextNonce(origin string, resp *http.Response) {
+	info := resp.Header.Get("Authentication-Info")
+	if info == "" {
+		return
+	}
+	params := parseAuthParams(info)
+	if next, ok := params["nextnonce"]; ok && next != "" {
+		d.mu.Lock()
+		if st := d.states[origin]; st != nil {
+			st.chal.nonce = next
+			st.nc = 0
+		}
+		d.mu.Unlock()
+	}
+}
+
+//computes the Authorization header for the challenge of st, d.mu has to be held
+func (d *DigestAuth) authorization(st *digestState, method, uri string, body []byte) string {
+	chal := st.chal
+	algo := strings.ToUpper(chal.algorithm)
+	if algo == "" {
+		algo = "MD5"
+	}
+	h := digestHash(strings.TrimSuffix(algo, "-SESS"))
+
+	st.nc++
+	nc := fmt.Sprintf("%08x", st.nc)
+
+	ha1 := h(d.Username + ":" + chal.realm + ":" + d.Password)
+	if strings.HasSuffix(algo, "-SESS") {
+		ha1 = h(ha1 + ":" + chal.nonce + ":" + st.cnonce)
+	}
+
+	qop := chal.pickQop()
+	var ha2 string
+	if qop == "auth-int" {
+		ha2 = h(method + ":" + uri + ":" + h(string(body)))
+	} else {
+		ha2 = h(method + ":" + uri)
+	}
+
+	var response string
+	if qop == "" {
+		response = h(ha1 + ":" + chal.nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + chal.nonce + ":" + nc + ":" + st.cnonce + ":" + qop + ":" + ha2)
+	}
+
+	username := d.Username
+	if chal.userhash {
+		username = h(d.Username + ":" + chal.realm)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%s, realm=%s, nonce=%s, uri=%s, algorithm=%s, response=%s`,
+		quote(username), quote(chal.realm), quote(chal.nonce), quote(uri), algo, quote(response))
+	if chal.opaque != "" {
+		fmt.Fprintf(&b, ", opaque=%s", quote(chal.opaque))
+	}
+	if qop != "" {
+		fmt.Fprintf(&b, ", qop=%s, nc=%s, cnonce=%s", qop, nc, quote(st.cnonce))
+	}
+	if chal.userhash {
+		b.WriteString(", userhash=true")
+	}
+	return b.String()
+}
+
+//prefers qop auth, auth-int is only used if the server requires it
+func (chal *digestChallenge) pickQop() string {
+	if len(chal.qop) == 0 {
+		return ""
+	}
+	for _, q := range chal.qop {
+		if q == "auth" {
+			return q
+		}
+	}
+	for _, q := range chal.qop {
+		if q == "auth-int" {
+			return q
+		}
+	}
+	return ""
+}
+
+//returns the hex encoded hash function for the digest algorithm
+func digestHash(algo string) func(string) string {
+	var newHash func() hash.Hash
+	switch algo {
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		newHash = md5.New
+	}
+	return func(s string) string {
+		h := newHash()
+		io.WriteString(h, s)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+func digestAlgorithmSupported(algo string) bool {
+	switch strings.ToUpper(algo) {
+	case "", "MD5", "MD5-SESS", "SHA-256", "SHA-256-SESS":
+		return true
+	}
+	return false
+}
+
+//picks the strongest supported digest challenge of the WWW-Authenticate headers
+func pickDigestChallenge(headers []string) *digestChallenge {
+	var best *digestChallenge
+	for _, header := range headers {
+		for _, ch := range splitChallenges(header) {
+			if !strings.EqualFold(ch.scheme, "Digest") {
+				continue
+			}
+			p := ch.params
+			chal := &digestChallenge{
+				realm:     p["realm"],
+				nonce:     p["nonce"],
+				opaque:    p["opaque"],
+				algorithm: p["algorithm"],
+				stale:     strings.EqualFold(p["stale"], "true"),
+				userhash:  strings.EqualFold(p["userhash"], "true"),
+			}
+			for _, q := range strings.Split(p["qop"], ",") {
+				if q = strings.TrimSpace(q); q != "" {
+					chal.qop = append(chal.qop, q)
+				}
+			}
+			if chal.nonce == "" || !digestAlgorithmSupported(chal.algorithm) {
+				continue
+			}
+			if best == nil || (strings.HasPrefix(strings.ToUpper(chal.algorithm), "SHA-256") &&
+				!strings.HasPrefix(strings.ToUpper(best.algorithm), "SHA-256")) {
+				best = chal
+			}
+		}
+	}
+	return best
+}
+
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+//splits a WWW-Authenticate header into its challenges
+func splitChallenges(header string) []authChallenge {
+	var challenges []authChallenge
+	s := header
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return challenges
+		}
+		scheme, rest := readToken(s)
+		if scheme == "" {
+			return challenges
+		}
+		ch := authChallenge{scheme: scheme, params: map[string]string{}}
+		s = rest
+		for {
+			t := strings.TrimLeft(s, " \t,")
+			key, rest := readToken(t)
+			rest = strings.TrimLeft(rest, " \t")
+			if key == "" || !strings.HasPrefix(rest, "=") {
+				//either the end or the scheme of the next challenge
+				s = t
+				break
+			}
+			rest = strings.TrimLeft(rest[1:], " \t")
+			var value string
+			if strings.HasPrefix(rest, `"`) {
+				value, rest = readQuoted(rest)
+			} else {
+				value, rest = readToken(rest)
+			}
+			ch.params[strings.ToLower(key)] = value
+			s = rest
+		}
+		challenges = append(challenges, ch)
+	}
+}
+
+//parses comma separated auth-params like the ones of Authentication-Info
+func parseAuthParams(s string) map[string]string {
+	chs := splitChallenges("x " + s)
+	if len(chs) == 0 {
+		return map[string]string{}
+	}
+	return chs[0].params
+}
+
+func readToken(s string) (token, rest string) {
+	i := 0
+	for i < len(s) && !strings.ContainsRune(" \t,=\"", rune(s[i])) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func readQuoted(s string) (value, rest string) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func newCnonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//reads the request body so it can be sent more than once
+func rewindableBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	var rc io.ReadCloser
+	var err error
+	if req.GetBody != nil {
+		rc, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rc = req.Body
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
@@ -0,0 +1,237 @@
+package httpcl
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type digestServer struct {
+	algorithm  string
+	qop        string
+	nonce      string
+	challenges int
+	requests   int
+}
+
+func (d *digestServer) hash(s string) string {
+	var h hash.Hash
+	if strings.HasPrefix(d.algorithm, "SHA-256") {
+		h = sha256.New()
+	} else {
+		h = md5.New()
+	}
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (d *digestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.requests++
+	challenge := func() {
+		d.challenges++
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="test", nonce="%s", opaque="op", algorithm=%s, qop="%s"`, d.nonce, d.algorithm, d.qop))
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		challenge()
+		return
+	}
+	p := parseAuthParams(strings.TrimPrefix(auth, "Digest "))
+	ha1 := d.hash("user:test:passwd")
+	if strings.HasSuffix(d.algorithm, "-sess") {
+		ha1 = d.hash(ha1 + ":" + p["nonce"] + ":" + p["cnonce"])
+	}
+	ha2 := d.hash(r.Method + ":" + p["uri"])
+	if p["qop"] == "auth-int" {
+		body, _ := ioutil.ReadAll(r.Body)
+		ha2 = d.hash(r.Method + ":" + p["uri"] + ":" + d.hash(string(body)))
+	}
+	expected := d.hash(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":" + p["qop"] + ":" + ha2)
+	if p["response"] != expected || p["opaque"] != "op" || p["nonce"] != d.nonce {
+		challenge()
+		return
+	}
+	w.Write([]byte(p["nc"]))
+}
+
+func Test_DigestAuth(t *testing.T) {
+	for _, algo := range []string{"MD5", "MD5-sess", "SHA-256", "SHA-256-sess"} {
+		srv := &digestServer{algorithm: algo, qop: "auth", nonce: "abc"}
+		ts := httptest.NewServer(srv)
+
+		var str string
+		_, err := Get(ts.URL+"/dir/index.html?a=b").SetDigestAuth("user", "passwd").DoTransform(TransformToString, &str)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if str != "00000001" {
+			t.Errorf("%s: response should be \"00000001\" is \"%s\"", algo, str)
+		}
+		ts.Close()
+	}
+}
+
+func Test_DigestAuthInt(t *testing.T) {
+	srv := &digestServer{algorithm: "MD5", qop: "auth-int", nonce: "abc"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	cl := Post(ts.URL, "test", "value").SetDigestAuth("user", "passwd")
+	cl.Do()
+	if cl.StatusCode != 200 {
+		t.Errorf("statuscode should be 200 is %v", cl.StatusCode)
+	}
+}
+
+func Test_DigestAuthSession(t *testing.T) {
+	srv := &digestServer{algorithm: "SHA-256", qop: "auth,auth-int", nonce: "abc"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	s := NewSession().SetDigestAuth("user", "passwd")
+	for i := 1; i <= 3; i++ {
+		var str string
+		_, err := s.Get(ts.URL).DoTransform(TransformToString, &str)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if str != fmt.Sprintf("%08x", i) {
+			t.Errorf("nonce count should be %08x is %s", i, str)
+		}
+	}
+	if srv.challenges != 1 {
+		t.Errorf("server should have sent 1 challenge, sent %v", srv.challenges)
+	}
+	if srv.requests != 4 {
+		t.Errorf("server should have received 4 requests, received %v", srv.requests)
+	}
+}
+
+func Test_DigestAuthWrongPassword(t *testing.T) {
+	srv := &digestServer{algorithm: "MD5", qop: "auth", nonce: "abc"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	cl := Get(ts.URL).SetDigestAuth("user", "wrong")
+	cl.Do()
+	if cl.StatusCode != 401 {
+		t.Errorf("statuscode should be 401 is %v", cl.StatusCode)
+	}
+	if srv.requests != 2 {
+		t.Errorf("server should have received 2 requests, received %v", srv.requests)
+	}
+}
+
+func Test_SplitChallenges(t *testing.T) {
+	chs := splitChallenges(`Basic realm="simple", Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=SHA-256, nonce="7ypf\"/xlj"`)
+	if len(chs) != 2 {
+		t.Fatalf("challenges length should be 2 is %v", len(chs))
+	}
+	if chs[1].scheme != "Digest" || chs[1].params["nonce"] != `7ypf"/xlj` || chs[1].params["algorithm"] != "SHA-256" {
+		t.Errorf("unexpected challenge %v", chs[1])
+	}
+	chal := pickDigestChallenge([]string{`Digest realm="a", nonce="1", algorithm=MD5`, `Digest realm="a", nonce="2", algorithm=SHA-256`})
+	if chal == nil || chal.nonce != "2" {
+		t.Errorf("SHA-256 challenge should be preferred, got %v", chal)
+	}
+}
+
+func Test_DigestAuthOrigins(t *testing.T) {
+	var other []string
+	ots := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		other = append(other, r.Header.Get("Authorization"))
+	}))
+	defer ots.Close()
+	srv := &digestServer{algorithm: "MD5", qop: "auth", nonce: "abc"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" && r.Header.Get("Authorization") != "" {
+			http.Redirect(w, r, ots.URL+"/target", http.StatusFound)
+			return
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	s := NewSession().SetDigestAuth("user", "passwd")
+	cl := s.Get(ts.URL)
+	cl.Do()
+	if cl.StatusCode != 200 {
+		t.Fatalf("statuscode should be 200 is %v", cl.StatusCode)
+	}
+	s.Get(ots.URL).Do()
+	s.Get(ts.URL + "/redirect").Do()
+	if len(other) != 2 {
+		t.Fatalf("other server should have received 2 requests, received %v", len(other))
+	}
+	for _, auth := range other {
+		if auth != "" {
+			t.Errorf("other server should receive no Authorization, got %s", auth)
+		}
+	}
+	if srv.challenges != 1 {
+		t.Errorf("server should have sent 1 challenge, sent %v", srv.challenges)
+	}
+}
+
+func Test_DigestAuthBody(t *testing.T) {
+	body := ioutil.NopCloser(strings.NewReader("data"))
+	req, _ := http.NewRequest("POST", "http://example.test/", body)
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("data")), nil
+	}
+	var bodies []io.ReadCloser
+	var read []string
+	next := RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		bodies = append(bodies, r.Body)
+		b, _ := ioutil.ReadAll(r.Body)
+		read = append(read, string(b))
+		resp := &http.Response{StatusCode: 200, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("")), Request: r}
+		if len(bodies) == 1 {
+			resp.StatusCode = 401
+			resp.Header.Set("WWW-Authenticate", `Digest realm="test", nonce="abc", qop="auth"`)
+		}
+		return resp, nil
+	})
+	resp, err := NewDigestAuth("user", "passwd").Wrap(next).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("statuscode should be 200 is %v", resp.StatusCode)
+	}
+	if len(bodies) != 2 || bodies[0] != body {
+		t.Errorf("the first request should stream the original body")
+	}
+	if len(read) != 2 || read[0] != "data" || read[1] != "data" {
+		t.Errorf("both requests should send the body, got %v", read)
+	}
+}
+
+func Test_DigestAuthNonceRotation(t *testing.T) {
+	srv := &digestServer{algorithm: "MD5", qop: "auth", nonce: "abc"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	s := NewSession().SetDigestAuth("user", "passwd")
+	for i, nonce := range []string{"abc", "def", "def", "ghi"} {
+		//the server rotates the nonce without stale=true
+		srv.nonce = nonce
+		cl := s.Get(ts.URL)
+		cl.Do()
+		if cl.StatusCode != 200 {
+			t.Errorf("%d: statuscode should be 200 is %v", i, cl.StatusCode)
+		}
+	}
+	if srv.challenges != 3 {
+		t.Errorf("server should have sent 3 challenges, sent %v", srv.challenges)
+	}
+}
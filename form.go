@@ -0,0 +1,386 @@
+package httpcl
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+//Char sends a rune as character. A rune is an int32 and is sent as number
+//otherwise.
+//
+//	httpcl.Post(url, "initial", httpcl.Char('a'))
+type Char rune
+
+func (c Char) String() string {
+	return string(rune(c))
+}
+
+//EncodeForm encodes v into form values. v can be a struct, a map or a pointer
+//to one of them. Struct fields are named by their form tag
+//
+//	Name  string    `form:"name,omitempty"`
+//	At    time.Time `form:"at" layout:"2006-01-02"`
+//	Since time.Time `form:"since,unix"`
+//
+//slices become repeated keys unless the tag option comma or brackets is set, nested maps and structs use the bracket
+//notation a[b][c] understood by PHP and Rails. time.Time defaults to RFC 3339,
+//encoding.TextMarshaler and fmt.Stringer are used if implemented.
+func EncodeForm(v interface{}) (url.Values, error) {
+	values := url.Values{}
+	rv := indirect(reflect.ValueOf(v))
+	if !rv.IsValid() {
+		return values, nil
+	}
+	if rv.Kind() != reflect.Struct && rv.Kind() != reflect.Map {
+		return nil, fmt.Errorf("form encoding needs a struct or map, got %T", v)
+	}
+	err := encodeFormValue(values, "", rv, formOptions{})
+	return values, err
+}
+
+//encodes v like EncodeForm, slices are encoded with the given style
+//unless a field tag overrides it
+func EncodeQuery(v interface{}, style ArrayStyle) (url.Values, error) {
+	values := url.Values{}
+	rv := indirect(reflect.ValueOf(v))
+	if !rv.IsValid() {
+		return values, nil
+	}
+	if rv.Kind() != reflect.Struct && rv.Kind() != reflect.Map {
+		return nil, fmt.Errorf("query encoding needs a struct or map, got %T", v)
+	}
+	err := encodeFormValue(values, "", rv, formOptions{style: style})
+	return values, err
+}
+
+//ArrayStyle selects how slices are encoded
+type ArrayStyle int
+
+const (
+	//a=1&a=2
+	ArrayRepeat ArrayStyle = iota
+	//a=1,2
+	ArrayComma
+	//a[]=1&a[]=2
+	ArrayBrackets
+)
+
+//a ParamError describes a body or query parameter that could not be encoded
+type ParamError struct {
+	Key string
+	Err error
+}
+
+func (e *ParamError) Error() string {
+	if e.Key == "" {
+		return e.Err.Error()
+	}
+	return e.Key + ": " + e.Err.Error()
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+//a BodyError lists every parameter that could not be encoded, ordered by key
+type BodyError struct {
+	Errors []*ParamError
+}
+
+func (e *BodyError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e *BodyError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+//collects parameter errors instead of stopping at the first one
+type paramErrors []*ParamError
+
+func (p *paramErrors) add(err error) {
+	switch e := err.(type) {
+	case nil:
+	case *BodyError:
+		*p = append(*p, e.Errors...)
+	case *ParamError:
+		*p = append(*p, e)
+	default:
+		*p = append(*p, &ParamError{Err: err})
+	}
+}
+
+//returns a *BodyError or nil if there were no errors
+func (p paramErrors) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return &BodyError{Errors: p}
+}
+
+type formOptions struct {
+	omitempty bool
+	unix      bool
+	unixmilli bool
+	layout    string
+	style     ArrayStyle
+}
+
+//parses the form tag of the field, the array style is inherited from the parent
+func parseFormTag(field reflect.StructField, style ArrayStyle) (string, formOptions) {
+	opts := formOptions{style: style}
+	tag := field.Tag.Get("form")
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	for _, p := range parts[1:] {
+		switch p {
+		case "omitempty":
+			opts.omitempty = true
+		case "unix":
+			opts.unix = true
+		case "unixmilli":
+			opts.unixmilli = true
+		case "repeat":
+			opts.style = ArrayRepeat
+		case "comma":
+			opts.style = ArrayComma
+		case "brackets":
+			opts.style = ArrayBrackets
+		}
+	}
+	opts.layout = field.Tag.Get("layout")
+	if name == "" {
+		name = field.Name
+	}
+	return name, opts
+}
+
+//returns key[sub] or sub if there is no key yet
+func formKey(key, sub string) string {
+	if key == "" {
+		return sub
+	}
+	return key + "[" + sub + "]"
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+//encodes v under key into values, the error is a *BodyError
+func encodeFormValue(values url.Values, key string, v reflect.Value, opts formOptions) error {
+	if opts.omitempty && isEmptyValue(v) {
+		return nil
+	}
+	v = indirect(v)
+	if !v.IsValid() {
+		if opts.omitempty {
+			return nil
+		}
+		values.Add(key, "")
+		return nil
+	}
+
+	if s, ok, err := formScalar(v, opts); ok {
+		if err != nil {
+			return &BodyError{Errors: []*ParamError{{Key: key, Err: err}}}
+		}
+		values.Add(key, s)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if opts.style != ArrayRepeat {
+			if scalars, ok, err := formScalars(v, opts); ok {
+				if err != nil {
+					return &BodyError{Errors: []*ParamError{{Key: key, Err: err}}}
+				}
+				if opts.style == ArrayComma {
+					if len(scalars) > 0 {
+						values.Add(key, strings.Join(scalars, ","))
+					}
+				} else {
+					for _, s := range scalars {
+						values.Add(key+"[]", s)
+					}
+				}
+				return nil
+			}
+		}
+		var errs paramErrors
+		for i := 0; i < v.Len(); i++ {
+			elem := indirect(v.Index(i))
+			sub := key
+			if elem.IsValid() && (elem.Kind() == reflect.Map || elem.Kind() == reflect.Struct) {
+				if _, scalar, _ := formScalar(elem, opts); !scalar {
+					sub = formKey(key, strconv.Itoa(i))
+				}
+			}
+			errs.add(encodeFormValue(values, sub, v.Index(i), formOptions{layout: opts.layout, unix: opts.unix, unixmilli: opts.unixmilli, style: opts.style}))
+		}
+		return errs.err()
+	case reflect.Map:
+		keys := v.MapKeys()
+		names := make([]string, len(keys))
+		index := make(map[string]reflect.Value, len(keys))
+		for i, k := range keys {
+			names[i] = fmt.Sprint(k.Interface())
+			index[names[i]] = v.MapIndex(k)
+		}
+		sort.Strings(names)
+		var errs paramErrors
+		for _, name := range names {
+			errs.add(encodeFormValue(values, formKey(key, name), index[name], formOptions{style: opts.style}))
+		}
+		return errs.err()
+	case reflect.Struct:
+		return encodeFormStruct(values, key, v, opts.style)
+	}
+	return &BodyError{Errors: []*ParamError{{Key: key, Err: fmt.Errorf("unsupported type for post %s", v.Type())}}}
+}
+
+func encodeFormStruct(values url.Values, key string, v reflect.Value, style ArrayStyle) error {
+	t := v.Type()
+	var errs paramErrors
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		if field.Tag.Get("form") == "-" {
+			continue
+		}
+		name, opts := parseFormTag(field, style)
+		fv := v.Field(i)
+		if field.Anonymous && field.Tag.Get("form") == "" {
+			//embedded structs are flattened into the parent
+			if inner := indirect(fv); inner.IsValid() && inner.Kind() == reflect.Struct {
+				if _, scalar, _ := formScalar(inner, opts); !scalar {
+					errs.add(encodeFormStruct(values, key, inner, style))
+					continue
+				}
+			}
+			if field.PkgPath != "" {
+				continue
+			}
+		}
+		errs.add(encodeFormValue(values, formKey(key, name), fv, opts))
+	}
+	return errs.err()
+}
+
+//formats values that encode to a single string, ok is false for
+//slices, maps and plain structs
+func formScalar(v reflect.Value, opts formOptions) (s string, ok bool, err error) {
+	if v.Type() == timeType && v.CanInterface() {
+		t := v.Interface().(time.Time)
+		switch {
+		case opts.unix:
+			return strconv.FormatInt(t.Unix(), 10), true, nil
+		case opts.unixmilli:
+			return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10), true, nil
+		case opts.layout != "":
+			return t.Format(opts.layout), true, nil
+		}
+		return t.Format(time.RFC3339), true, nil
+	}
+	if m, ok := asInterface(v, textMarshalerType); ok {
+		b, err := m.(encoding.TextMarshaler).MarshalText()
+		return string(b), true, err
+	}
+	if m, ok := asInterface(v, stringerType); ok {
+		return m.(fmt.Stringer).String(), true, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), true, nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), true, nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), true, nil
+	case reflect.String:
+		return v.String(), true, nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+//formats all elements of a slice, ok is false if one of them is no scalar
+func formScalars(v reflect.Value, opts formOptions) ([]string, bool, error) {
+	scalars := make([]string, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		elem := indirect(v.Index(i))
+		if !elem.IsValid() {
+			scalars = append(scalars, "")
+			continue
+		}
+		s, ok, err := formScalar(elem, opts)
+		if !ok || err != nil {
+			return nil, ok, err
+		}
+		scalars = append(scalars, s)
+	}
+	return scalars, true, nil
+}
+
+//returns v as the interface if v or a pointer to v implements it
+func asInterface(v reflect.Value, iface reflect.Type) (interface{}, bool) {
+	if v.Type().Implements(iface) && v.CanInterface() {
+		return v.Interface(), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(iface) && v.Addr().CanInterface() {
+		return v.Addr().Interface(), true
+	}
+	if reflect.PtrTo(v.Type()).Implements(iface) && v.CanInterface() {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p.Interface(), true
+	}
+	return nil, false
+}
@@ -0,0 +1,171 @@
+package httpcl
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type formLevel int
+
+func (l formLevel) String() string {
+	return [...]string{"low", "high"}[l]
+}
+
+type formAddress struct {
+	Street string `form:"street"`
+	Zip    string `form:"zip,omitempty"`
+}
+
+type formBase struct {
+	ID uint16 `form:"id"`
+}
+
+type formUser struct {
+	formBase
+	Name     string            `form:"name"`
+	Nick     string            `form:"nick,omitempty"`
+	Age      *int              `form:"age,omitempty"`
+	Score    float32           `form:"score"`
+	Ratio    float64           `form:"ratio"`
+	Tags     []string          `form:"tags"`
+	Born     time.Time         `form:"born" layout:"2006-01-02"`
+	Seen     time.Time         `form:"seen,unix"`
+	IP       net.IP            `form:"ip"`
+	Level    formLevel         `form:"level"`
+	Address  formAddress       `form:"address"`
+	Meta     map[string]string `form:"meta"`
+	Secret   string            `form:"-"`
+	internal string
+}
+
+func Test_EncodeFormStruct(t *testing.T) {
+	u := formUser{
+		formBase: formBase{ID: 7},
+		Name:     "alice",
+		Score:    0.1,
+		Ratio:    2.5,
+		Tags:     []string{"a", "b"},
+		Born:     time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC),
+		Seen:     time.Unix(1500000000, 0),
+		IP:       net.ParseIP("10.0.0.1"),
+		Level:    1,
+		Address:  formAddress{Street: "Main"},
+		Meta:     map[string]string{"b": "2", "a": "1"},
+		Secret:   "x",
+		internal: "y",
+	}
+	values, err := EncodeForm(&u)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := "address%5Bstreet%5D=Main&born=1990-05-17&id=7&ip=10.0.0.1&level=high&meta%5Ba%5D=1&meta%5Bb%5D=2&name=alice&ratio=2.5&score=0.1&seen=1500000000&tags=a&tags=b"
+	if values.Encode() != expected {
+		t.Errorf("encoding should be\n%s is\n%s", expected, values.Encode())
+	}
+}
+
+func Test_EncodeFormNestedMap(t *testing.T) {
+	values, err := EncodeForm(map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": map[string]interface{}{"c": int8(-1)},
+		},
+		"items": []map[string]interface{}{{"id": uint32(1)}, {"id": 2.0}},
+		"ptr":   nil,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for key, value := range map[string]string{"a[b][c]": "-1", "items[0][id]": "1", "items[1][id]": "2", "ptr": ""} {
+		if values.Get(key) != value {
+			t.Errorf("%s should be %q is %q", key, value, values.Get(key))
+		}
+	}
+}
+
+func Test_PostStruct(t *testing.T) {
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		by, _ := ioutil.ReadAll(r.Body)
+		body = string(by)
+	}))
+	defer ts.Close()
+
+	_, err := Post(ts.URL, formAddress{Street: "Main", Zip: "123"}).Do()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if body != "street=Main&zip=123" {
+		t.Errorf("body should be \"street=Main&zip=123\" is \"%s\"", body)
+	}
+
+	_, err = Post(ts.URL, "tags", []int{1, 2}, "ratio", float32(1.5)).Do()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if body != "ratio=1.5&tags=1&tags=2" {
+		t.Errorf("body should be \"ratio=1.5&tags=1&tags=2\" is \"%s\"", body)
+	}
+
+	if err := Post(ts.URL, map[string]interface{}{"f": func() {}}).Error; err == nil {
+		t.Error("func values should fail")
+	}
+}
+
+func Test_BodyErrors(t *testing.T) {
+	err := Post("http://example.com", map[string]interface{}{
+		"z":  make(chan int),
+		"a":  func() {},
+		"m":  map[string]interface{}{"inner": complex(1, 2)},
+		"ok": "fine",
+	}).Error
+	be, ok := err.(*BodyError)
+	if !ok {
+		t.Fatalf("error should be *BodyError is %T", err)
+	}
+	keys := []string{}
+	for _, pe := range be.Errors {
+		keys = append(keys, pe.Key)
+	}
+	if strings.Join(keys, ",") != "a,m[inner],z" {
+		t.Errorf("offending keys should be a,m[inner],z are %v", keys)
+	}
+	var pe *ParamError
+	if !errors.As(err, &pe) || pe.Key != "a" {
+		t.Errorf("errors.As should find the first ParamError, got %v", pe)
+	}
+
+	err = Post("http://example.com", "a", 1, 2, "b", "c", make(chan int)).Error
+	if err == nil || err.Error() != "2: key must be a string, got int\nc: unsupported type for post chan int" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func Test_PostMapDeterministic(t *testing.T) {
+	vals := map[string]interface{}{}
+	for _, k := range []string{"e", "b", "d", "a", "c"} {
+		vals[k] = map[string]int{"y": 1, "x": 2}
+	}
+	first := ""
+	for i := 0; i < 20; i++ {
+		by, _ := ioutil.ReadAll(Post("http://example.com", vals).GetRequest().Body)
+		if first == "" {
+			first = string(by)
+		} else if string(by) != first {
+			t.Fatalf("encoding should be stable, got %s and %s", first, by)
+		}
+	}
+}
+
+func Test_PostRune(t *testing.T) {
+	vals := map[string]interface{}{"r": 'a', "c": Char('a'), "f": 2.344, "i": int32(65)}
+	by, _ := ioutil.ReadAll(Post("http://example.com", vals).GetRequest().Body)
+	if string(by) != "c=a&f=2.344&i=65&r=97" {
+		t.Errorf("body should be c=a&f=2.344&i=65&r=97 is %s", by)
+	}
+}
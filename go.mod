@@ -0,0 +1,8 @@
+module github.com/Kemonozume/httpcl
+
+go 1.23
+
+require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/klauspost/compress v1.18.0
+)
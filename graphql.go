@@ -0,0 +1,331 @@
+package httpcl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//GraphQL sends queries to a GraphQL endpoint with POST
+type GraphQL struct {
+	URL string
+	//requests are created from the session if set, so they share its
+	//transport, cookies and auth
+	Session *Session
+	//headers added to every request
+	Header http.Header
+	//sends the sha256 hash of the query first and the query only if the
+	//server doesn't know it yet (automatic persisted queries). Servers
+	//without support are remembered and get the query right away.
+	PersistedQueries bool
+
+	mu          sync.Mutex
+	apqDisabled bool
+}
+
+//a GraphQLRequest is a single operation. Variables may hold *Upload values,
+//also nested in maps and slices, which are sent as multipart file uploads.
+type GraphQLRequest struct {
+	Query         string                 `json:"query,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+//an Upload is a file of a multipart request (GraphQL multipart request spec)
+type Upload struct {
+	Filename    string
+	ContentType string
+	Reader      io.Reader
+}
+
+//a GraphQLError is an entry of the errors array of a response
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Locations  []GraphQLLocation      `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (e GraphQLError) Error() string {
+	if len(e.Path) == 0 {
+		return e.Message
+	}
+	path := make([]string, len(e.Path))
+	for i, p := range e.Path {
+		path[i] = fmt.Sprint(p)
+	}
+	return strings.Join(path, ".") + ": " + e.Message
+}
+
+//GraphQLErrors are returned when the errors array of a response is not
+//empty, data is still decoded so partial results can be used
+type GraphQLErrors []GraphQLError
+
+func (e GraphQLErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "graphql: " + strings.Join(msgs, "; ")
+}
+
+//returns the first error with the given extensions code
+func (e GraphQLErrors) Code(code string) *GraphQLError {
+	for i := range e {
+		if c, _ := e[i].Extensions["code"].(string); c == code {
+			return &e[i]
+		}
+	}
+	return nil
+}
+
+//creates a GraphQL client for the endpoint
+func NewGraphQL(url string) *GraphQL {
+	return &GraphQL{URL: url}
+}
+
+//creates a GraphQL client for the endpoint sending through the session
+func (s *Session) GraphQL(url string) *GraphQL {
+	return &GraphQL{URL: url, Session: s}
+}
+
+//sends the query and decodes the data of the response into data
+func (g *GraphQL) Query(ctx context.Context, query string, variables map[string]interface{}, data interface{}) error {
+	return g.Do(ctx, GraphQLRequest{Query: query, Variables: variables}, data)
+}
+
+//sends the operation and decodes the data of the response into data. An
+//errors array in the response is returned as GraphQLErrors, also on status 200.
+func (g *GraphQL) Do(ctx context.Context, req GraphQLRequest, data interface{}) error {
+	if !g.PersistedQueries || req.Query == "" || g.apqOff() || hasUploads(req) {
+		//files can only be read once, so uploads are sent with the query
+		return g.send(ctx, req, data)
+	}
+
+	sum := sha256.Sum256([]byte(req.Query))
+	ext := map[string]interface{}{}
+	for k, v := range req.Extensions {
+		ext[k] = v
+	}
+	ext["persistedQuery"] = map[string]interface{}{"version": 1, "sha256Hash": hex.EncodeToString(sum[:])}
+	full := req
+	full.Extensions = ext
+
+	//the hash is sent alone first, the query only if the server asks for it
+	hashOnly := full
+	hashOnly.Query = ""
+	err := g.send(ctx, hashOnly, data)
+	errs, ok := err.(GraphQLErrors)
+	switch {
+	case ok && (errs.Code("PERSISTED_QUERY_NOT_SUPPORTED") != nil || hasMessage(errs, "PersistedQueryNotSupported")):
+		g.mu.Lock()
+		g.apqDisabled = true
+		g.mu.Unlock()
+		return g.send(ctx, req, data)
+	case ok && (errs.Code("PERSISTED_QUERY_NOT_FOUND") != nil || hasMessage(errs, "PersistedQueryNotFound")):
+		return g.send(ctx, full, data)
+	}
+	return err
+}
+
+func hasUploads(req GraphQLRequest) bool {
+	var files []*Upload
+	extractUploads(req.Variables, "variables", map[string][]string{}, &files)
+	return len(files) > 0
+}
+
+func hasMessage(errs GraphQLErrors, msg string) bool {
+	for _, e := range errs {
+		if e.Message == msg {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *GraphQL) apqOff() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.apqDisabled
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors GraphQLErrors   `json:"errors"`
+}
+
+func (g *GraphQL) send(ctx context.Context, req GraphQLRequest, data interface{}) error {
+	uploads := map[string][]string{}
+	files := []*Upload{}
+	if req.Variables != nil {
+		req.Variables = extractUploads(req.Variables, "variables", uploads, &files).(map[string]interface{})
+	}
+	var c *Client
+	if len(files) == 0 {
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		c = g.post(bytes.NewReader(body)).SetContentType("application/json")
+	} else {
+		body := multipartOperations(req, uploads, files)
+		if c = g.post(body); c.Error != nil {
+			//stops the goroutine writing the body
+			body.Close()
+		}
+	}
+	if c.Error != nil {
+		return c.Error
+	}
+	c.AddHeader("Accept", "application/graphql-response+json, application/json")
+	for key, values := range g.Header {
+		for _, value := range values {
+			c.AddHeader(key, value)
+		}
+	}
+	if ctx != nil {
+		c.SetRequest(c.GetRequest().WithContext(ctx))
+	}
+
+	resp, err := c.Do()
+	if err != nil {
+		return err
+	}
+	var out graphQLResponse
+	if err := DecodeJSON(resp, &out, JSONOptions{}); err != nil {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("graphql: unexpected status %s", resp.Status)
+		}
+		return err
+	}
+	if data != nil && len(out.Data) > 0 && string(out.Data) != "null" {
+		if err := json.Unmarshal(out.Data, data); err != nil {
+			return err
+		}
+	}
+	if len(out.Errors) > 0 {
+		return out.Errors
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("graphql: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (g *GraphQL) post(body io.Reader) *Client {
+	if g.Session != nil {
+		return g.Session.Post(g.URL, body)
+	}
+	return Post(g.URL, body)
+}
+
+//replaces uploads in v with nil and records their object paths
+func extractUploads(v interface{}, path string, uploads map[string][]string, files *[]*Upload) interface{} {
+	switch t := v.(type) {
+	case *Upload:
+		index := -1
+		for i, f := range *files {
+			if f == t {
+				index = i
+			}
+		}
+		if index < 0 {
+			index = len(*files)
+			*files = append(*files, t)
+		}
+		key := strconv.Itoa(index)
+		uploads[key] = append(uploads[key], path)
+		return nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		//sorted so the files are numbered the same way every time
+		sort.Strings(keys)
+		out := make(map[string]interface{}, len(t))
+		for _, k := range keys {
+			out[k] = extractUploads(t[k], path+"."+k, uploads, files)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, value := range t {
+			out[i] = extractUploads(value, path+"."+strconv.Itoa(i), uploads, files)
+		}
+		return out
+	case []*Upload:
+		out := make([]interface{}, len(t))
+		for i, value := range t {
+			out[i] = extractUploads(value, path+"."+strconv.Itoa(i), uploads, files)
+		}
+		return out
+	}
+	return v
+}
+
+//streams the operations, the map and the files as multipart body
+func multipartOperations(req GraphQLRequest, uploads map[string][]string, files []*Upload) io.ReadCloser {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(func() error {
+			operations, err := json.Marshal(req)
+			if err != nil {
+				return err
+			}
+			if err := mw.WriteField("operations", string(operations)); err != nil {
+				return err
+			}
+			paths, err := json.Marshal(uploads)
+			if err != nil {
+				return err
+			}
+			if err := mw.WriteField("map", string(paths)); err != nil {
+				return err
+			}
+			for i, f := range files {
+				key := strconv.Itoa(i)
+				h := textproto.MIMEHeader{}
+				h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, key, escapeQuotes(f.Filename)))
+				contentType := f.ContentType
+				if contentType == "" {
+					contentType = "application/octet-stream"
+				}
+				h.Set("Content-Type", contentType)
+				part, err := mw.CreatePart(h)
+				if err != nil {
+					return err
+				}
+				if _, err := io.Copy(part, f.Reader); err != nil {
+					return err
+				}
+			}
+			return mw.Close()
+		}())
+	}()
+	return typedBody{pr, mw.FormDataContentType()}
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,171 @@
+package httpcl
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_GraphQLQuery(t *testing.T) {
+	var got map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("content type should be application/json is %s", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/graphql-response+json")
+		w.Write([]byte(`{"data":{"user":{"id":"1","name":"ada"},"friends":null},
+			"errors":[{"message":"not allowed","path":["friends",0],"locations":[{"line":1,"column":20}],"extensions":{"code":"FORBIDDEN"}}]}`))
+	}))
+	defer ts.Close()
+
+	var data struct {
+		User struct {
+			ID   string
+			Name string
+		}
+	}
+	g := NewSession().AddHeader("X-Team", "core").GraphQL(ts.URL)
+	err := g.Do(context.Background(), GraphQLRequest{
+		Query:         "query User($id: ID!) { user(id: $id) { id name } friends { id } }",
+		Variables:     map[string]interface{}{"id": "1"},
+		OperationName: "User",
+	}, &data)
+
+	errs, ok := err.(GraphQLErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("error should be GraphQLErrors is %v", err)
+	}
+	if errs.Code("FORBIDDEN") == nil || errs[0].Locations[0].Column != 20 || errs[0].Error() != "friends.0: not allowed" {
+		t.Errorf("error should carry path, location and code, got %+v", errs[0])
+	}
+	if data.User.Name != "ada" {
+		t.Errorf("partial data should be decoded, got %+v", data)
+	}
+	if got["operationName"] != "User" || got["variables"].(map[string]interface{})["id"] != "1" {
+		t.Errorf("request should carry operation name and variables, got %v", got)
+	}
+}
+
+func Test_GraphQLStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	err := NewGraphQL(ts.URL).Query(context.Background(), "{ a }", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("error should contain the status is %v", err)
+	}
+}
+
+func Test_GraphQLPersistedQueries(t *testing.T) {
+	known := map[string]bool{}
+	var requests []map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, body)
+		hash := body["extensions"].(map[string]interface{})["persistedQuery"].(map[string]interface{})["sha256Hash"].(string)
+		if _, ok := body["query"]; ok {
+			known[hash] = true
+		} else if !known[hash] {
+			w.Write([]byte(`{"errors":[{"message":"PersistedQueryNotFound","extensions":{"code":"PERSISTED_QUERY_NOT_FOUND"}}]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"a":1}}`))
+	}))
+	defer ts.Close()
+
+	g := NewGraphQL(ts.URL)
+	g.PersistedQueries = true
+	var data struct{ A int }
+	for i := 0; i < 2; i++ {
+		if err := g.Query(context.Background(), "{ a }", nil, &data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(requests) != 3 {
+		t.Fatalf("requests should be hash, query and hash, got %v", requests)
+	}
+	_, q0 := requests[0]["query"]
+	_, q1 := requests[1]["query"]
+	_, q2 := requests[2]["query"]
+	if q0 || !q1 || q2 {
+		t.Errorf("only the second request should carry the query, got %v", requests)
+	}
+	if data.A != 1 {
+		t.Errorf("data should be decoded, got %+v", data)
+	}
+}
+
+func Test_GraphQLPersistedQueriesNotSupported(t *testing.T) {
+	count := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if _, ok := body["extensions"]; ok {
+			w.Write([]byte(`{"errors":[{"message":"PersistedQueryNotSupported"}]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"a":1}}`))
+	}))
+	defer ts.Close()
+
+	g := NewGraphQL(ts.URL)
+	g.PersistedQueries = true
+	for i := 0; i < 2; i++ {
+		if err := g.Query(context.Background(), "{ a }", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count != 3 {
+		t.Errorf("the server without support should be remembered, requests %v", count)
+	}
+}
+
+func Test_GraphQLUpload(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		if r.FormValue("operations") != `{"query":"mutation($file: Upload!, $files: [Upload!]!) { upload }","variables":{"file":null,"files":[null,null]}}` {
+			t.Errorf("operations are wrong: %s", r.FormValue("operations"))
+		}
+		if r.FormValue("map") != `{"0":["variables.file","variables.files.1"],"1":["variables.files.0"]}` {
+			t.Errorf("map is wrong: %s", r.FormValue("map"))
+		}
+		for key, want := range map[string]string{"0": "first", "1": "second"} {
+			f, h, err := r.FormFile(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			by, _ := ioutil.ReadAll(f)
+			if string(by) != want {
+				t.Errorf("file %s should be %s is %s", key, want, by)
+			}
+			if key == "0" && (h.Filename != "a.txt" || h.Header.Get("Content-Type") != "text/plain") {
+				t.Errorf("file 0 should be a.txt text/plain, got %s %s", h.Filename, h.Header.Get("Content-Type"))
+			}
+		}
+		w.Write([]byte(`{"data":{"upload":true}}`))
+	}))
+	defer ts.Close()
+
+	first := &Upload{Filename: "a.txt", ContentType: "text/plain", Reader: strings.NewReader("first")}
+	second := &Upload{Filename: "b.bin", Reader: strings.NewReader("second")}
+	var data struct{ Upload bool }
+	err := NewGraphQL(ts.URL).Query(context.Background(), "mutation($file: Upload!, $files: [Upload!]!) { upload }",
+		map[string]interface{}{"file": first, "files": []*Upload{second, first}}, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !data.Upload {
+		t.Error("upload should be true")
+	}
+}
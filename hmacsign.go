@@ -0,0 +1,261 @@
+package httpcl
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//HMACSigner signs requests with a vendor specific HMAC scheme. The string to
+//sign is built from Template, which may contain the placeholders
+//
+//	{timestamp} {nonce} {api_key} {method} {host} {path} {query}
+//	{path_query} {body} {body_sha256} {body_md5} {header:Name}
+//
+//{query} is the encoded query including the timestamp, nonce and api key
+//parameters added by the signer, {path_query} is the path followed by
+//"?" and the query if there is one.
+type HMACSigner struct {
+	Secret   []byte
+	Template string
+	//sha1, sha256 (default), sha384, sha512 or md5
+	Hash string
+	//hex (default), HEX, base64 or base64url
+	Encoding string
+
+	//the signature is put into this header and/or query parameter
+	SignatureHeader string
+	SignatureQuery  string
+	//format of the signature value, may use all placeholders and {signature},
+	//defaults to {signature}
+	SignatureFormat string
+
+	APIKey       string
+	APIKeyHeader string
+	APIKeyQuery  string
+
+	//provides {timestamp}, defaults to UnixMillis
+	Timestamp       func() string
+	TimestampHeader string
+	TimestampQuery  string
+
+	//provides {nonce}, no nonce is generated if nil
+	Nonce       func() string
+	NonceHeader string
+	NonceQuery  string
+}
+
+//returns the current unix time in seconds
+func UnixSeconds() string {
+	return strconv.FormatInt(time.Now().Unix(), 10)
+}
+
+//returns the current unix time in milliseconds
+func UnixMillis() string {
+	return strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+}
+
+//returns the current time formatted as RFC 3339 in UTC
+func RFC3339Timestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+//returns 16 random bytes hex encoded
+func RandomNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//returns a nonce provider counting up from the current unix time in
+//microseconds, so nonces keep increasing across restarts
+func IncreasingNonce() func() string {
+	n := time.Now().UnixNano() / int64(time.Microsecond)
+	return func() string {
+		return strconv.FormatInt(atomic.AddInt64(&n, 1), 10)
+	}
+}
+
+//Wrap is the Middleware of the signer
+func (s *HMACSigner) Wrap(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		r := req.Clone(req.Context())
+		if err := s.Sign(r); err != nil {
+			return nil, err
+		}
+		return next.RoundTrip(r)
+	})
+}
+
+//signs the request in place
+func (s *HMACSigner) Sign(req *http.Request) error {
+	newHash, err := hmacHash(s.Hash)
+	if err != nil {
+		return err
+	}
+	body, err := rewindableBody(req)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	vars := map[string]string{"api_key": s.APIKey}
+	timestamp := s.Timestamp
+	if timestamp == nil {
+		timestamp = UnixMillis
+	}
+	vars["timestamp"] = timestamp()
+	if s.Nonce != nil {
+		vars["nonce"] = s.Nonce()
+	}
+
+	setHeader := func(key, value string) {
+		if key != "" {
+			req.Header.Set(key, value)
+		}
+	}
+	setHeader(s.APIKeyHeader, s.APIKey)
+	setHeader(s.TimestampHeader, vars["timestamp"])
+	setHeader(s.NonceHeader, vars["nonce"])
+
+	query := req.URL.Query()
+	setQuery := func(key, value string) {
+		if key != "" {
+			query.Set(key, value)
+		}
+	}
+	setQuery(s.APIKeyQuery, s.APIKey)
+	setQuery(s.TimestampQuery, vars["timestamp"])
+	setQuery(s.NonceQuery, vars["nonce"])
+	if s.APIKeyQuery != "" || s.TimestampQuery != "" || s.NonceQuery != "" {
+		req.URL.RawQuery = query.Encode()
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	vars["method"] = req.Method
+	vars["host"] = req.URL.Host
+	vars["path"] = path
+	vars["query"] = req.URL.RawQuery
+	vars["path_query"] = path
+	if req.URL.RawQuery != "" {
+		vars["path_query"] += "?" + req.URL.RawQuery
+	}
+	vars["body"] = string(body)
+	vars["body_sha256"] = hashHex(body)
+	sum := md5.Sum(body)
+	vars["body_md5"] = hex.EncodeToString(sum[:])
+
+	payload, err := expandSignTemplate(s.Template, vars, req.Header)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(newHash, s.Secret)
+	io.WriteString(mac, payload)
+	signature, err := encodeSignature(s.Encoding, mac.Sum(nil))
+	if err != nil {
+		return err
+	}
+
+	vars["signature"] = signature
+	format := s.SignatureFormat
+	if format == "" {
+		format = "{signature}"
+	}
+	value, err := expandSignTemplate(format, vars, req.Header)
+	if err != nil {
+		return err
+	}
+	setHeader(s.SignatureHeader, value)
+	if s.SignatureQuery != "" {
+		req.URL.RawQuery = appendQuery(req.URL.RawQuery, s.SignatureQuery, value)
+	}
+	return nil
+}
+
+//appends key=value to an encoded query without reordering it
+func appendQuery(raw, key, value string) string {
+	pair := url.QueryEscape(key) + "=" + url.QueryEscape(value)
+	if raw == "" {
+		return pair
+	}
+	return raw + "&" + pair
+}
+
+func expandSignTemplate(tmpl string, vars map[string]string, header http.Header) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(tmpl, '{')
+		if i < 0 {
+			b.WriteString(tmpl)
+			return b.String(), nil
+		}
+		j := strings.IndexByte(tmpl[i:], '}')
+		if j < 0 {
+			return "", fmt.Errorf("unterminated placeholder in %q", tmpl)
+		}
+		b.WriteString(tmpl[:i])
+		name := tmpl[i+1 : i+j]
+		if strings.HasPrefix(name, "header:") {
+			b.WriteString(header.Get(strings.TrimPrefix(name, "header:")))
+		} else if value, ok := vars[name]; ok {
+			b.WriteString(value)
+		} else {
+			return "", fmt.Errorf("unknown placeholder {%s}", name)
+		}
+		tmpl = tmpl[i+j+1:]
+	}
+}
+
+func hmacHash(name string) (func() hash.Hash, error) {
+	switch strings.ToLower(strings.Replace(name, "-", "", -1)) {
+	case "", "sha256":
+		return sha256.New, nil
+	case "sha1":
+		return sha1.New, nil
+	case "sha384":
+		return sha512.New384, nil
+	case "sha512":
+		return sha512.New, nil
+	case "md5":
+		return md5.New, nil
+	}
+	return nil, fmt.Errorf("unsupported hash %s", name)
+}
+
+func encodeSignature(encoding string, sum []byte) (string, error) {
+	switch encoding {
+	case "", "hex":
+		return hex.EncodeToString(sum), nil
+	case "HEX":
+		return strings.ToUpper(hex.EncodeToString(sum)), nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(sum), nil
+	case "base64url":
+		return base64.RawURLEncoding.EncodeToString(sum), nil
+	}
+	return "", fmt.Errorf("unsupported signature encoding %s", encoding)
+}
@@ -0,0 +1,85 @@
+package httpcl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_HMACSignerHeaders(t *testing.T) {
+	secret := []byte("secret")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(r.Header.Get("X-Timestamp") + r.Method + r.URL.RequestURI() + string(body)))
+		if r.Header.Get("X-Signature") != "key:"+base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(401)
+		}
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(403)
+		}
+	}))
+	defer ts.Close()
+
+	signer := &HMACSigner{
+		Secret:          secret,
+		Template:        "{timestamp}{method}{path_query}{body}",
+		Encoding:        "base64",
+		APIKey:          "key",
+		APIKeyHeader:    "X-Api-Key",
+		TimestampHeader: "X-Timestamp",
+		SignatureHeader: "X-Signature",
+		SignatureFormat: "{api_key}:{signature}",
+	}
+	s := NewSession().Use(signer.Wrap)
+	cl := s.Post(ts.URL+"/orders?limit=1", "side", "buy")
+	cl.Do()
+	if cl.StatusCode != 200 {
+		t.Errorf("statuscode should be 200 is %v", cl.StatusCode)
+	}
+}
+
+func Test_HMACSignerQuery(t *testing.T) {
+	signer := &HMACSigner{
+		Secret:         []byte("secret"),
+		Template:       "{query}",
+		Timestamp:      func() string { return "1499827319559" },
+		TimestampQuery: "timestamp",
+		Nonce:          func() string { return "n1" },
+		NonceHeader:    "X-Nonce",
+		SignatureQuery: "signature",
+	}
+	req := Get("http://example.com/api/v3/order?symbol=LTCBTC").GetRequest()
+	if err := signer.Sign(req); err != nil {
+		t.Fatal(err.Error())
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("symbol=LTCBTC&timestamp=1499827319559"))
+	expected := "symbol=LTCBTC&timestamp=1499827319559&signature=" + hex.EncodeToString(mac.Sum(nil))
+	if req.URL.RawQuery != expected {
+		t.Errorf("query should be %s is %s", expected, req.URL.RawQuery)
+	}
+	if req.Header.Get("X-Nonce") != "n1" {
+		t.Errorf("nonce header should be n1 is %s", req.Header.Get("X-Nonce"))
+	}
+}
+
+func Test_HMACSignerErrors(t *testing.T) {
+	req := Get("http://example.com").GetRequest()
+	if err := (&HMACSigner{Template: "{unknown}"}).Sign(req); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("unknown placeholder should fail, got %v", err)
+	}
+	if err := (&HMACSigner{Hash: "crc32"}).Sign(req); err == nil {
+		t.Error("unknown hash should fail")
+	}
+	nonce := IncreasingNonce()
+	if a, b := nonce(), nonce(); a >= b {
+		t.Errorf("nonce should increase %s %s", a, b)
+	}
+}
@@ -0,0 +1,872 @@
+package httpcl
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//returned by the MessageVerifier if no signature could be verified
+var ErrSignatureInvalid = errors.New("http message signature invalid")
+
+//returned if the Content-Digest header does not match the body
+var ErrContentDigestMismatch = errors.New("content digest does not match body")
+
+//computes the Content-Digest header (RFC 9530) for the request body.
+//supported algorithms are sha-256 and sha-512.
+func ContentDigest(algorithm string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			r := req.Clone(req.Context())
+			if err := setContentDigest(r, algorithm); err != nil {
+				return nil, err
+			}
+			return next.RoundTrip(r)
+		})
+	}
+}
+
+//adds a Content-Digest header computed from the body when the request is sent
+func (c *Client) AddContentDigest(algorithm string) *Client {
+	return c.runWithHasRequest(func() {
+		c.Use(ContentDigest(algorithm))
+	})
+}
+
+func setContentDigest(req *http.Request, algorithm string) error {
+	body, err := rewindableBody(req)
+	if err != nil {
+		return err
+	}
+	digest, err := contentDigest(algorithm, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	req.Header.Set("Content-Digest", digest)
+	return nil
+}
+
+func contentDigest(algorithm string, body []byte) (string, error) {
+	var h hash.Hash
+	switch strings.ToLower(algorithm) {
+	case "sha-256":
+		h = sha256.New()
+	case "sha-512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported content digest algorithm %s", algorithm)
+	}
+	h.Write(body)
+	return strings.ToLower(algorithm) + "=:" + base64.StdEncoding.EncodeToString(h.Sum(nil)) + ":", nil
+}
+
+//checks the Content-Digest header of the response against its body,
+//the body stays readable. Unknown algorithms are ignored.
+func VerifyContentDigest(resp *http.Response) error {
+	header := resp.Header.Get("Content-Digest")
+	if header == "" {
+		return errors.New("no Content-Digest header")
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	return verifyContentDigest(header, body)
+}
+
+func verifyContentDigest(header string, body []byte) error {
+	members, err := parseSFDictionary(header)
+	if err != nil {
+		return err
+	}
+	checked := false
+	for _, m := range members {
+		expected, err := contentDigest(m.key, body)
+		if err != nil {
+			continue
+		}
+		checked = true
+		if expected != m.key+"="+m.raw {
+			return ErrContentDigestMismatch
+		}
+	}
+	if !checked {
+		return errors.New("no supported algorithm in Content-Digest")
+	}
+	return nil
+}
+
+//MessageSigner signs requests with HTTP Message Signatures (RFC 9421)
+type MessageSigner struct {
+	//signature label, defaults to sig1
+	Label string
+	KeyID string
+	//[]byte for hmac-sha256, ed25519.PrivateKey, *ecdsa.PrivateKey (P-256 or P-384)
+	//or *rsa.PrivateKey for rsa-pss-sha512
+	Key interface{}
+	//explicit algorithm, derived from Key if empty. rsa-v1_5-sha256 needs it set.
+	Algorithm string
+	//covered components like @method, @target-uri, content-digest or header names
+	Components []string
+	//adds the alg parameter to the signature parameters
+	IncludeAlg bool
+	//sets the expires parameter relative to created
+	Expires time.Duration
+	Tag     string
+	//the Content-Digest algorithm if content-digest is covered, defaults to sha-256
+	DigestAlgorithm string
+	//returns the created time, defaults to time.Now
+	Now func() time.Time
+}
+
+//Wrap is the Middleware of the signer
+func (s *MessageSigner) Wrap(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		r := req.Clone(req.Context())
+		if err := s.SignRequest(r); err != nil {
+			return nil, err
+		}
+		return next.RoundTrip(r)
+	})
+}
+
+//adds Signature and Signature-Input headers to the request
+func (s *MessageSigner) SignRequest(req *http.Request) error {
+	alg := s.Algorithm
+	if alg == "" {
+		alg = sigAlgorithmForKey(s.Key)
+	}
+	if alg == "" {
+		return fmt.Errorf("unsupported signing key %T", s.Key)
+	}
+
+	for _, comp := range s.Components {
+		if strings.ToLower(comp) == "content-digest" && req.Header.Get("Content-Digest") == "" {
+			digestAlg := s.DigestAlgorithm
+			if digestAlg == "" {
+				digestAlg = "sha-256"
+			}
+			if err := setContentDigest(req, digestAlg); err != nil {
+				return err
+			}
+		}
+	}
+
+	components := make([]sfItem, len(s.Components))
+	for i, comp := range s.Components {
+		item, err := parseComponentID(comp)
+		if err != nil {
+			return err
+		}
+		components[i] = item
+	}
+
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	params := []sfParam{{"created", strconv.FormatInt(now.Unix(), 10)}}
+	if s.Expires > 0 {
+		params = append(params, sfParam{"expires", strconv.FormatInt(now.Add(s.Expires).Unix(), 10)})
+	}
+	if s.KeyID != "" {
+		params = append(params, sfParam{"keyid", sfString(s.KeyID)})
+	}
+	if s.IncludeAlg {
+		params = append(params, sfParam{"alg", sfString(alg)})
+	}
+	if s.Tag != "" {
+		params = append(params, sfParam{"tag", sfString(s.Tag)})
+	}
+	sigParams := serializeInnerList(components, params)
+
+	base, err := signatureBase(components, sigParams, httpMessage{req: req})
+	if err != nil {
+		return err
+	}
+	sig, err := signMessage(alg, s.Key, []byte(base))
+	if err != nil {
+		return err
+	}
+
+	label := s.Label
+	if label == "" {
+		label = "sig1"
+	}
+	req.Header.Set("Signature-Input", appendToken(req.Header.Get("Signature-Input"), label+"="+sigParams))
+	req.Header.Set("Signature", appendToken(req.Header.Get("Signature"), label+"=:"+base64.StdEncoding.EncodeToString(sig)+":"))
+	return nil
+}
+
+//MessageVerifier verifies HTTP Message Signatures (RFC 9421)
+type MessageVerifier struct {
+	//returns the verification key and the algorithm for a keyid, the key is
+	//[]byte, ed25519.PublicKey, *ecdsa.PublicKey or *rsa.PublicKey.
+	//An empty algorithm is derived from the key type, the alg parameter of
+	//the signature is never trusted and has to match.
+	Keys func(keyID string) (key interface{}, algorithm string, err error)
+	//only verify the signature with this label, any signature is accepted if empty
+	Label string
+	//components that have to be covered by the signature
+	Required []string
+	//rejects signatures older than MaxAge if > 0
+	MaxAge time.Duration
+	Now    func() time.Time
+}
+
+//verifies the signature of a response, components with the req
+//parameter are taken from resp.Request. A covered Content-Digest is checked
+//against the body, the body stays readable.
+func (v *MessageVerifier) VerifyResponse(resp *http.Response) error {
+	msg := httpMessage{resp: resp, req: resp.Request}
+	return v.verify(resp.Header, msg, &resp.Body)
+}
+
+//verifies the signature of a request
+func (v *MessageVerifier) VerifyRequest(req *http.Request) error {
+	return v.verify(req.Header, httpMessage{req: req}, &req.Body)
+}
+
+func (v *MessageVerifier) verify(header http.Header, msg httpMessage, body *io.ReadCloser) error {
+	inputs, err := parseSFDictionary(strings.Join(header.Values("Signature-Input"), ","))
+	if err != nil {
+		return err
+	}
+	sigs, err := parseSFDictionary(strings.Join(header.Values("Signature"), ","))
+	if err != nil {
+		return err
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("%w: no Signature-Input", ErrSignatureInvalid)
+	}
+
+	var lastErr error
+	for _, input := range inputs {
+		if v.Label != "" && input.key != v.Label {
+			continue
+		}
+		var sig *sfMember
+		for i := range sigs {
+			if sigs[i].key == input.key {
+				sig = &sigs[i]
+			}
+		}
+		if sig == nil {
+			lastErr = fmt.Errorf("%w: no signature for label %s", ErrSignatureInvalid, input.key)
+			continue
+		}
+		if lastErr = v.verifyOne(input, *sig, msg, body); lastErr == nil {
+			return nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("%w: no signature with label %s", ErrSignatureInvalid, v.Label)
+	}
+	return lastErr
+}
+
+func (v *MessageVerifier) verifyOne(input, sig sfMember, msg httpMessage, body *io.ReadCloser) error {
+	if !input.isList {
+		return fmt.Errorf("%w: Signature-Input %s is no inner list", ErrSignatureInvalid, input.key)
+	}
+	for _, req := range v.Required {
+		found := false
+		for _, item := range input.items {
+			if item.value == strings.ToLower(req) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: component %s not covered", ErrSignatureInvalid, req)
+		}
+	}
+
+	params := map[string]string{}
+	for _, p := range input.params {
+		params[p.key] = p.value
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if exp, ok := params["expires"]; ok {
+		if e, err := strconv.ParseInt(exp, 10, 64); err == nil && now.Unix() > e {
+			return fmt.Errorf("%w: signature expired", ErrSignatureInvalid)
+		}
+	}
+	if v.MaxAge > 0 {
+		created, err := strconv.ParseInt(params["created"], 10, 64)
+		if err != nil || now.Sub(time.Unix(created, 0)) > v.MaxAge {
+			return fmt.Errorf("%w: signature too old", ErrSignatureInvalid)
+		}
+	}
+
+	if v.Keys == nil {
+		return errors.New("MessageVerifier has no Keys")
+	}
+	key, alg, err := v.Keys(unquoteSF(params["keyid"]))
+	if err != nil {
+		return err
+	}
+	if alg == "" {
+		alg = sigAlgorithmForKey(key)
+	}
+	if alg == "" {
+		return fmt.Errorf("no signature algorithm for key %T", key)
+	}
+	if p, ok := params["alg"]; ok && unquoteSF(p) != alg {
+		return fmt.Errorf("%w: algorithm %s doesn't match the key", ErrSignatureInvalid, unquoteSF(p))
+	}
+
+	base, err := signatureBase(input.items, input.raw, msg)
+	if err != nil {
+		return err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.Trim(sig.raw, ":"))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSignatureInvalid, err)
+	}
+	if err := verifyMessage(alg, key, []byte(base), raw); err != nil {
+		return err
+	}
+
+	for _, item := range input.items {
+		if item.value == "content-digest" && item.param("req") == "" && *body != nil {
+			by, err := ioutil.ReadAll(*body)
+			(*body).Close()
+			*body = ioutil.NopCloser(bytes.NewReader(by))
+			if err != nil {
+				return err
+			}
+			if err := verifyContentDigest(msg.header(false).Get("Content-Digest"), by); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//the message the components are taken from
+type httpMessage struct {
+	req  *http.Request
+	resp *http.Response
+}
+
+func (m httpMessage) header(fromReq bool) http.Header {
+	if m.resp != nil && !fromReq {
+		return m.resp.Header
+	}
+	if m.req == nil {
+		return nil
+	}
+	return m.req.Header
+}
+
+//builds the signature base of RFC 9421 section 2.5
+func signatureBase(components []sfItem, sigParams string, msg httpMessage) (string, error) {
+	var b strings.Builder
+	seen := map[string]bool{}
+	for _, comp := range components {
+		id := serializeItem(comp)
+		if seen[id] {
+			return "", fmt.Errorf("component %s covered twice", id)
+		}
+		seen[id] = true
+		value, err := componentValue(comp, msg)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(id + ": " + value + "\n")
+	}
+	b.WriteString(`"@signature-params": ` + sigParams)
+	return b.String(), nil
+}
+
+func componentValue(comp sfItem, msg httpMessage) (string, error) {
+	fromReq := comp.param("req") != ""
+	for _, p := range comp.params {
+		if p.key != "req" && p.key != "name" {
+			return "", fmt.Errorf("unsupported component parameter %s", p.key)
+		}
+	}
+	if !strings.HasPrefix(comp.value, "@") {
+		header := msg.header(fromReq)
+		values := header.Values(comp.value)
+		if len(values) == 0 && comp.value == "content-length" {
+			if r := msg.req; msg.resp == nil && r != nil && r.ContentLength > 0 {
+				return strconv.FormatInt(r.ContentLength, 10), nil
+			}
+		}
+		if len(values) == 0 {
+			return "", fmt.Errorf("component %s not present", comp.value)
+		}
+		//Values returns the slice of the header, it must not be changed
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.TrimSpace(v)
+		}
+		return strings.Join(trimmed, ", "), nil
+	}
+
+	if comp.value == "@status" {
+		if msg.resp == nil || fromReq {
+			return "", errors.New("@status is only available for responses")
+		}
+		return fmt.Sprintf("%03d", msg.resp.StatusCode), nil
+	}
+	if msg.resp != nil && !fromReq {
+		return "", fmt.Errorf("derived component %s needs the req parameter for responses", comp.value)
+	}
+	req := msg.req
+	if req == nil {
+		return "", fmt.Errorf("no request for component %s", comp.value)
+	}
+	u := req.URL
+	switch comp.value {
+	case "@method":
+		return req.Method, nil
+	case "@target-uri":
+		return targetURI(req), nil
+	case "@authority":
+		return authority(req), nil
+	case "@scheme":
+		return strings.ToLower(scheme(req)), nil
+	case "@request-target":
+		return u.RequestURI(), nil
+	case "@path":
+		if p := u.EscapedPath(); p != "" {
+			return p, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + u.RawQuery, nil
+	case "@query-param":
+		//the name is given encoded, the value is encoded with %20 for spaces
+		name := unquoteSF(comp.param("name"))
+		decoded, err := url.QueryUnescape(name)
+		if err != nil {
+			return "", fmt.Errorf("invalid query parameter name %s", name)
+		}
+		values, ok := u.Query()[decoded]
+		if !ok || len(values) == 0 {
+			return "", fmt.Errorf("query parameter %s not present", name)
+		}
+		return strings.ReplaceAll(url.QueryEscape(values[0]), "+", "%20"), nil
+	}
+	return "", fmt.Errorf("unsupported derived component %s", comp.value)
+}
+
+func scheme(req *http.Request) string {
+	if req.URL.Scheme != "" {
+		return req.URL.Scheme
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func authority(req *http.Request) string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	host = strings.ToLower(host)
+	s := strings.ToLower(scheme(req))
+	if (s == "http" && strings.HasSuffix(host, ":80")) || (s == "https" && strings.HasSuffix(host, ":443")) {
+		host = host[:strings.LastIndexByte(host, ':')]
+	}
+	return host
+}
+
+func targetURI(req *http.Request) string {
+	return strings.ToLower(scheme(req)) + "://" + authority(req) + req.URL.RequestURI()
+}
+
+func sigAlgorithmForKey(key interface{}) string {
+	switch k := key.(type) {
+	case []byte:
+		return "hmac-sha256"
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return "ed25519"
+	case *ecdsa.PrivateKey:
+		return ecdsaAlgorithm(&k.PublicKey)
+	case *ecdsa.PublicKey:
+		return ecdsaAlgorithm(k)
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return "rsa-pss-sha512"
+	}
+	return ""
+}
+
+func ecdsaAlgorithm(k *ecdsa.PublicKey) string {
+	if k.Curve.Params().BitSize == 384 {
+		return "ecdsa-p384-sha384"
+	}
+	return "ecdsa-p256-sha256"
+}
+
+func signMessage(alg string, key interface{}, base []byte) ([]byte, error) {
+	switch alg {
+	case "hmac-sha256":
+		secret, ok := key.([]byte)
+		if !ok {
+			break
+		}
+		h := hmac.New(sha256.New, secret)
+		h.Write(base)
+		return h.Sum(nil), nil
+	case "ed25519":
+		k, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			break
+		}
+		return ed25519.Sign(k, base), nil
+	case "ecdsa-p256-sha256", "ecdsa-p384-sha384":
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			break
+		}
+		digest, size := ecdsaDigest(alg, base)
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	case "rsa-pss-sha512":
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			break
+		}
+		digest := sha512.Sum512(base)
+		return rsa.SignPSS(rand.Reader, k, crypto.SHA512, digest[:], &rsa.PSSOptions{SaltLength: 64})
+	case "rsa-v1_5-sha256":
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			break
+		}
+		digest := sha256.Sum256(base)
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %s", alg)
+	}
+	return nil, fmt.Errorf("key %T does not match algorithm %s", key, alg)
+}
+
+func verifyMessage(alg string, key interface{}, base, sig []byte) error {
+	valid := false
+	switch alg {
+	case "hmac-sha256":
+		if secret, ok := key.([]byte); ok {
+			h := hmac.New(sha256.New, secret)
+			h.Write(base)
+			valid = hmac.Equal(h.Sum(nil), sig)
+		}
+	case "ed25519":
+		if k, ok := key.(ed25519.PublicKey); ok {
+			valid = ed25519.Verify(k, base, sig)
+		}
+	case "ecdsa-p256-sha256", "ecdsa-p384-sha384":
+		if k, ok := key.(*ecdsa.PublicKey); ok {
+			digest, size := ecdsaDigest(alg, base)
+			if len(sig) == 2*size {
+				r := new(big.Int).SetBytes(sig[:size])
+				s := new(big.Int).SetBytes(sig[size:])
+				valid = ecdsa.Verify(k, digest, r, s)
+			}
+		}
+	case "rsa-pss-sha512":
+		if k, ok := key.(*rsa.PublicKey); ok {
+			digest := sha512.Sum512(base)
+			valid = rsa.VerifyPSS(k, crypto.SHA512, digest[:], sig, nil) == nil
+		}
+	case "rsa-v1_5-sha256":
+		if k, ok := key.(*rsa.PublicKey); ok {
+			digest := sha256.Sum256(base)
+			valid = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+		}
+	default:
+		return fmt.Errorf("unsupported signature algorithm %s", alg)
+	}
+	if !valid {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+func ecdsaDigest(alg string, base []byte) ([]byte, int) {
+	if alg == "ecdsa-p384-sha384" {
+		d := sha512.Sum384(base)
+		return d[:], 48
+	}
+	d := sha256.Sum256(base)
+	return d[:], 32
+}
+
+//a structured field item, only strings and tokens are needed for component ids
+type sfItem struct {
+	value  string
+	params []sfParam
+}
+
+type sfParam struct {
+	key   string
+	value string
+}
+
+func (i sfItem) param(key string) string {
+	for _, p := range i.params {
+		if p.key == key {
+			return p.value
+		}
+	}
+	return ""
+}
+
+//a member of a structured field dictionary, raw is the serialized value
+type sfMember struct {
+	key    string
+	raw    string
+	isList bool
+	items  []sfItem
+	params []sfParam
+}
+
+//parses a component identifier like "@query-param";name="id" or content-type
+func parseComponentID(comp string) (sfItem, error) {
+	comp = strings.TrimSpace(comp)
+	if !strings.HasPrefix(comp, `"`) {
+		i := strings.IndexByte(comp, ';')
+		if i < 0 {
+			i = len(comp)
+		}
+		comp = sfString(strings.ToLower(comp[:i])) + comp[i:]
+	}
+	p := &sfParser{s: comp}
+	item, err := p.item()
+	if err != nil {
+		return item, err
+	}
+	if p.i != len(p.s) {
+		return item, fmt.Errorf("invalid component identifier %s", comp)
+	}
+	return item, nil
+}
+
+func parseSFDictionary(s string) ([]sfMember, error) {
+	var members []sfMember
+	p := &sfParser{s: s}
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return members, nil
+		}
+		key := p.key()
+		if key == "" {
+			return nil, fmt.Errorf("invalid structured field %q", s)
+		}
+		m := sfMember{key: key}
+		start := p.i
+		if p.peek() == '=' {
+			p.i++
+			start = p.i
+			if p.peek() == '(' {
+				m.isList = true
+				items, err := p.innerList()
+				if err != nil {
+					return nil, err
+				}
+				m.items = items
+			} else if _, err := p.bareItem(); err != nil {
+				return nil, err
+			}
+		}
+		m.params = p.params()
+		m.raw = s[start:p.i]
+		members = append(members, m)
+		p.skipSpace()
+		if p.eof() {
+			return members, nil
+		}
+		if p.peek() != ',' {
+			return nil, fmt.Errorf("invalid structured field %q", s)
+		}
+		p.i++
+	}
+}
+
+type sfParser struct {
+	s string
+	i int
+}
+
+func (p *sfParser) eof() bool { return p.i >= len(p.s) }
+
+func (p *sfParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sfParser) skipSpace() {
+	for !p.eof() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+func (p *sfParser) key() string {
+	start := p.i
+	for !p.eof() {
+		c := p.s[p.i]
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '_' || c == '-' || c == '.' || c == '*') {
+			break
+		}
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+func (p *sfParser) innerList() ([]sfItem, error) {
+	p.i++
+	var items []sfItem
+	for {
+		p.skipSpace()
+		if p.peek() == ')' {
+			p.i++
+			return items, nil
+		}
+		if p.eof() {
+			return nil, errors.New("unterminated inner list")
+		}
+		item, err := p.item()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func (p *sfParser) item() (sfItem, error) {
+	value, err := p.bareItem()
+	if err != nil {
+		return sfItem{}, err
+	}
+	return sfItem{value: unquoteSF(value), params: p.params()}, nil
+}
+
+//returns the serialized bare item
+func (p *sfParser) bareItem() (string, error) {
+	start := p.i
+	switch c := p.peek(); {
+	case c == '"':
+		p.i++
+		for !p.eof() && p.s[p.i] != '"' {
+			if p.s[p.i] == '\\' {
+				p.i++
+			}
+			p.i++
+		}
+		if p.eof() {
+			return "", errors.New("unterminated string")
+		}
+		p.i++
+	case c == ':':
+		end := strings.IndexByte(p.s[p.i+1:], ':')
+		if end < 0 {
+			return "", errors.New("unterminated byte sequence")
+		}
+		p.i += end + 2
+	default:
+		for !p.eof() && !strings.ContainsRune(" \t;,()\"", rune(p.s[p.i])) {
+			p.i++
+		}
+	}
+	if p.i == start {
+		return "", fmt.Errorf("invalid structured field item at %d", start)
+	}
+	return p.s[start:p.i], nil
+}
+
+func (p *sfParser) params() []sfParam {
+	var params []sfParam
+	for p.peek() == ';' {
+		p.i++
+		p.skipSpace()
+		param := sfParam{key: p.key(), value: "?1"}
+		if p.peek() == '=' {
+			p.i++
+			value, err := p.bareItem()
+			if err != nil {
+				return params
+			}
+			param.value = value
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+func sfString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func unquoteSF(s string) string {
+	if len(s) < 2 || s[0] != '"' {
+		return s
+	}
+	return strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(s[1 : len(s)-1])
+}
+
+func serializeItem(item sfItem) string {
+	var b strings.Builder
+	b.WriteString(sfString(item.value))
+	for _, p := range item.params {
+		b.WriteString(";" + p.key)
+		if p.value != "?1" {
+			b.WriteString("=" + p.value)
+		}
+	}
+	return b.String()
+}
+
+func serializeInnerList(items []sfItem, params []sfParam) string {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = serializeItem(item)
+	}
+	s := "(" + strings.Join(ids, " ") + ")"
+	for _, p := range params {
+		s += ";" + p.key + "=" + p.value
+	}
+	return s
+}
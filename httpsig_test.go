@@ -0,0 +1,232 @@
+package httpcl
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const httpsigTestBody = `{"hello": "world"}`
+
+func Test_ContentDigest(t *testing.T) {
+	digest, _ := contentDigest("sha-512", []byte(httpsigTestBody))
+	expected := "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:"
+	if digest != expected {
+		t.Errorf("digest should be \"%s\" is \"%s\"", expected, digest)
+	}
+	digest, _ = contentDigest("sha-256", []byte(httpsigTestBody))
+	expected = "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"
+	if digest != expected {
+		t.Errorf("digest should be \"%s\" is \"%s\"", expected, digest)
+	}
+	if err := verifyContentDigest(expected, []byte("other")); err != ErrContentDigestMismatch {
+		t.Errorf("mismatching digest should fail, got %v", err)
+	}
+}
+
+func Test_ContentDigestRequest(t *testing.T) {
+	var header string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("Content-Digest")
+		by, _ := ioutil.ReadAll(r.Body)
+		if err := verifyContentDigest(header, by); err != nil {
+			w.WriteHeader(400)
+		}
+	}))
+	defer ts.Close()
+
+	cl := Post(ts.URL, "hello", "world").AddContentDigest("sha-256")
+	cl.Do()
+	if cl.StatusCode != 200 {
+		t.Errorf("statuscode should be 200 is %v", cl.StatusCode)
+	}
+	if !strings.HasPrefix(header, "sha-256=:") {
+		t.Errorf("unexpected Content-Digest %s", header)
+	}
+}
+
+func Test_MessageSignatureEd25519(t *testing.T) {
+	seed, _ := hex.DecodeString("9f8362f87a484a954e6e740c5b4c0e84229139a20aa8ab56ff66586f6a7d29c5")
+	key := ed25519.NewKeyFromSeed(seed)
+
+	req := Post("http://example.com/foo?param=Value&Pet=dog", strings.NewReader(httpsigTestBody)).
+		AddHeader("Date", "Tue, 20 Apr 2021 02:07:55 GMT").
+		AddHeader("Content-Type", "application/json").
+		GetRequest()
+	signer := &MessageSigner{
+		KeyID:      "test-key-ed25519",
+		Key:        key,
+		Components: []string{"date", "@method", "@path", "@authority", "content-type", "content-length"},
+		Now:        func() time.Time { return time.Unix(1618884473, 0) },
+	}
+	if err := signer.SignRequest(req); err != nil {
+		t.Fatal(err.Error())
+	}
+	input := `sig1=("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`
+	if req.Header.Get("Signature-Input") != input {
+		t.Errorf("Signature-Input should be %s is %s", input, req.Header.Get("Signature-Input"))
+	}
+	sig := "sig1=:wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==:"
+	if req.Header.Get("Signature") != sig {
+		t.Errorf("Signature should be %s is %s", sig, req.Header.Get("Signature"))
+	}
+
+	v := &MessageVerifier{
+		Keys: func(keyID string) (interface{}, string, error) {
+			return key.Public(), "", nil
+		},
+		Required: []string{"@method"},
+	}
+	if err := v.VerifyRequest(req); err != nil {
+		t.Error(err.Error())
+	}
+	req.Method = "PUT"
+	if err := v.VerifyRequest(req); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("modified request should fail, got %v", err)
+	}
+}
+
+func Test_MessageSignatureKeys(t *testing.T) {
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rs, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("secret")
+	keys := map[string][2]interface{}{
+		"hmac": {secret, secret},
+		"p256": {ec, &ec.PublicKey},
+		"p384": {ec384, &ec384.PublicKey},
+		"rsa":  {rs, &rs.PublicKey},
+		"ed":   {ed, ed.Public()},
+	}
+
+	for id, pair := range keys {
+		req := Post("https://example.com/path?a=b", "hello", "world").GetRequest()
+		signer := &MessageSigner{
+			KeyID:      id,
+			Key:        pair[0],
+			IncludeAlg: true,
+			Components: []string{"@method", "@target-uri", "content-digest", `"@query-param";name="a"`},
+		}
+		if err := signer.SignRequest(req); err != nil {
+			t.Fatalf("%s: %s", id, err.Error())
+		}
+		v := &MessageVerifier{
+			Keys: func(keyID string) (interface{}, string, error) {
+				return keys[keyID][1], "", nil
+			},
+			Required: []string{"content-digest"},
+			MaxAge:   time.Minute,
+		}
+		if err := v.VerifyRequest(req); err != nil {
+			t.Errorf("%s: %s", id, err.Error())
+		}
+		by, _ := ioutil.ReadAll(req.Body)
+		if string(by) != "hello=world" {
+			t.Errorf("%s: body should stay readable, got %s", id, by)
+		}
+	}
+}
+
+func Test_MessageSignatureResponse(t *testing.T) {
+	secret := []byte("shared")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := &http.Response{StatusCode: 200, Header: w.Header(), Request: r}
+		w.Header().Set("Content-Digest", "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:")
+		items := []sfItem{{value: "@status"}, {value: "content-digest"}, {value: "@method", params: []sfParam{{"req", "?1"}}}}
+		params := serializeInnerList(items, []sfParam{{"keyid", `"srv"`}})
+		base, _ := signatureBase(items, params, httpMessage{req: r, resp: resp})
+		sig, _ := signMessage("hmac-sha256", secret, []byte(base))
+		w.Header().Set("Signature-Input", "res="+params)
+		w.Header().Set("Signature", "res=:"+base64.StdEncoding.EncodeToString(sig)+":")
+		w.Write([]byte(httpsigTestBody))
+	}))
+	defer ts.Close()
+
+	v := &MessageVerifier{
+		Keys: func(keyID string) (interface{}, string, error) {
+			if keyID != "srv" {
+				return nil, "", errors.New("unknown key")
+			}
+			return secret, "hmac-sha256", nil
+		},
+		Label: "res",
+	}
+	resp, err := Get(ts.URL).Do()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := v.VerifyResponse(resp); err != nil {
+		t.Error(err.Error())
+	}
+	by, _ := ioutil.ReadAll(resp.Body)
+	if string(by) != httpsigTestBody {
+		t.Errorf("body should stay readable, got %s", by)
+	}
+}
+
+func Test_MessageSignatureAlgorithm(t *testing.T) {
+	rs, _ := rsa.GenerateKey(rand.Reader, 2048)
+	req := Get("https://example.com/").GetRequest()
+	signer := &MessageSigner{KeyID: "rsa", Key: rs, Algorithm: "rsa-v1_5-sha256", IncludeAlg: true, Components: []string{"@method"}}
+	if err := signer.SignRequest(req); err != nil {
+		t.Fatal(err.Error())
+	}
+	//the alg parameter doesn't select the algorithm of the key
+	v := &MessageVerifier{
+		Keys: func(keyID string) (interface{}, string, error) {
+			return &rs.PublicKey, "", nil
+		},
+	}
+	if err := v.VerifyRequest(req); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("algorithm of the signature should be rejected, got %v", err)
+	}
+	v.Keys = func(keyID string) (interface{}, string, error) {
+		return &rs.PublicKey, "rsa-v1_5-sha256", nil
+	}
+	if err := v.VerifyRequest(req); err != nil {
+		t.Error(err.Error())
+	}
+	if err := (&MessageVerifier{}).VerifyRequest(req); err == nil {
+		t.Error("verifier without keys should fail")
+	}
+}
+
+func Test_MessageSignatureComponents(t *testing.T) {
+	//RFC 9421 section 2.2.8
+	req, _ := http.NewRequest("GET", "https://example.com/parameters?var=this%20is%20a%20big%0Avalue&bar=with+plus+whitespace&fa%C3%A7ade%22%3A%20=something", nil)
+	req.Header["X-Spaced"] = []string{"  a ", "b  "}
+	tests := map[string]string{
+		`"@query-param";name="var"`:                  "this%20is%20a%20big%0Avalue",
+		`"@query-param";name="bar"`:                  "with%20plus%20whitespace",
+		`"@query-param";name="fa%C3%A7ade%22%3A%20"`: "something",
+		`"x-spaced"`: "a, b",
+	}
+	for id, want := range tests {
+		comp, err := parseComponentID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := componentValue(comp, httpMessage{req: req})
+		if err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+		if v != want {
+			t.Errorf("%s should be %s is %s", id, want, v)
+		}
+	}
+	if req.Header["X-Spaced"][0] != "  a " {
+		t.Errorf("header should not be changed, got %q", req.Header["X-Spaced"])
+	}
+}
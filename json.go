@@ -0,0 +1,126 @@
+package httpcl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+)
+
+//ErrBodyTooLarge is returned when a response body exceeds JSONOptions.MaxBytes
+var ErrBodyTooLarge = errors.New("response body too large")
+
+//JSONOptions configure how a response body is decoded, the body is decoded
+//while it is read instead of being read into memory first
+type JSONOptions struct {
+	//fails on object keys without a matching struct field
+	DisallowUnknownFields bool
+	//decodes numbers into interface{} as json.Number instead of float64
+	UseNumber bool
+	//bodies larger than MaxBytes fail with ErrBodyTooLarge, 0 means no limit
+	MaxBytes int64
+}
+
+//decodes the json body of the response into v and closes it
+func DecodeJSON(resp *http.Response, v interface{}, opts JSONOptions) error {
+	defer resp.Body.Close()
+	return decodeJSON(resp.Body, v, opts)
+}
+
+func decodeJSON(r io.Reader, v interface{}, opts JSONOptions) error {
+	dec := opts.decoder(r)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	//like json.Unmarshal only whitespace may follow the value
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			return errors.New("invalid data after top-level value")
+		}
+		return err
+	}
+	return nil
+}
+
+//is a transform for DoTransform decoding the body with the options
+func (o JSONOptions) Transform(resp *http.Response, c interface{}) error {
+	return DecodeJSON(resp, c, o)
+}
+
+func (o JSONOptions) decoder(r io.Reader) *json.Decoder {
+	if o.MaxBytes > 0 {
+		r = &maxBytesReader{r: r, n: o.MaxBytes}
+	}
+	dec := json.NewDecoder(r)
+	if o.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if o.UseNumber {
+		dec.UseNumber()
+	}
+	return dec
+}
+
+//JSONArray walks the elements of a top-level json array in the response body
+//one at a time, so arrays of any size are decoded in constant memory
+//
+//	for item, err := range httpcl.JSONArray[Item](resp) {
+//
+//the body is closed when the loop ends, the first error stops the iteration
+func JSONArray[T any](resp *http.Response, opts ...JSONOptions) iter.Seq2[T, error] {
+	var o JSONOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	return func(yield func(T, error) bool) {
+		defer resp.Body.Close()
+		var zero T
+		dec := o.decoder(resp.Body)
+
+		tok, err := dec.Token()
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			yield(zero, fmt.Errorf("expected json array, got %v", tok))
+			return
+		}
+		for dec.More() {
+			var item T
+			if err := dec.Decode(&item); err != nil {
+				yield(zero, err)
+				return
+			}
+			if !yield(item, nil) {
+				return
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			yield(zero, err)
+		}
+	}
+}
+
+//fails with ErrBodyTooLarge once more than n bytes are read
+type maxBytesReader struct {
+	r io.Reader
+	n int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.n < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > m.n+1 {
+		p = p[:m.n+1]
+	}
+	n, err := m.r.Read(p)
+	m.n -= int64(n)
+	if m.n < 0 {
+		return n + int(m.n), ErrBodyTooLarge
+	}
+	return n, err
+}
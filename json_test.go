@@ -0,0 +1,128 @@
+package httpcl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type jsonItem struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func jsonServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+}
+
+func Test_DecodeJSONOptions(t *testing.T) {
+	ts := jsonServer(`{"extra": true, "id": 12345678901234567890, "name": "a"}`)
+	defer ts.Close()
+
+	var item jsonItem
+	if _, err := Get(ts.URL).DoTransform(JSONOptions{DisallowUnknownFields: true}.Transform, &item); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("error should be unknown field is %v", err)
+	}
+
+	var m map[string]interface{}
+	if _, err := Get(ts.URL).DoTransform(JSONOptions{UseNumber: true}.Transform, &m); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := m["id"].(json.Number); !ok || n.String() != "12345678901234567890" {
+		t.Errorf("id should be json.Number 12345678901234567890 is %#v", m["id"])
+	}
+
+	if _, err := Get(ts.URL).DoTransform(JSONOptions{MaxBytes: 10}.Transform, &m); err != ErrBodyTooLarge {
+		t.Errorf("error should be ErrBodyTooLarge is %v", err)
+	}
+	if _, err := Get(ts.URL).DoTransform(JSONOptions{MaxBytes: 100}.Transform, &m); err != nil {
+		t.Errorf("body below the limit should decode, got %v", err)
+	}
+}
+
+func Test_TransformToJsonTrailingData(t *testing.T) {
+	ts := jsonServer(`{"id": 1} {"id": 2}`)
+	defer ts.Close()
+
+	var item jsonItem
+	if _, err := Get(ts.URL).DoTransform(TransformToJson, &item); err == nil {
+		t.Error("trailing value should be an error")
+	}
+}
+
+func Test_JSONArray(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("[")
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"id":%d,"name":"item%d"}`, i, i)
+	}
+	b.WriteString("]")
+	ts := jsonServer(b.String())
+	defer ts.Close()
+
+	resp, err := Get(ts.URL).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for item, err := range JSONArray[jsonItem](resp) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.ID != count || item.Name != fmt.Sprintf("item%d", count) {
+			t.Fatalf("item should be %d is %+v", count, item)
+		}
+		count++
+	}
+	if count != 1000 {
+		t.Errorf("count should be 1000 is %v", count)
+	}
+
+	resp, _ = Get(ts.URL).Do()
+	count = 0
+	for range JSONArray[jsonItem](resp) {
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	if count != 3 {
+		t.Errorf("iteration should stop after break, count is %v", count)
+	}
+}
+
+func Test_JSONArrayErrors(t *testing.T) {
+	ts := jsonServer(`{"id": 1}`)
+	defer ts.Close()
+	resp, _ := Get(ts.URL).Do()
+	for _, err := range JSONArray[jsonItem](resp) {
+		if err == nil || !strings.Contains(err.Error(), "expected json array") {
+			t.Errorf("error should be expected json array is %v", err)
+		}
+	}
+
+	ts2 := jsonServer(`[{"id": 1}, {"id": "x"}]`)
+	defer ts2.Close()
+	resp, _ = Get(ts2.URL).Do()
+	var items []jsonItem
+	var errs []error
+	for item, err := range JSONArray[jsonItem](resp) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		items = append(items, item)
+	}
+	if len(items) != 1 || len(errs) != 1 {
+		t.Errorf("should decode 1 item and 1 error, got %v and %v", items, errs)
+	}
+}
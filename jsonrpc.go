@@ -0,0 +1,288 @@
+package httpcl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+//JSONRPC calls methods of a JSON-RPC 2.0 endpoint over HTTP POST
+type JSONRPC struct {
+	URL string
+	//requests are created from the session if set
+	Session *Session
+	//headers added to every request
+	Header http.Header
+	//generates the request ids, defaults to SequentialIDs
+	IDs IDGenerator
+
+	once sync.Once
+}
+
+//an IDGenerator returns the id of the next request, a string or a number
+type IDGenerator func() interface{}
+
+//returns ids counting up from 1
+func SequentialIDs() IDGenerator {
+	var n int64
+	return func() interface{} {
+		return atomic.AddInt64(&n, 1)
+	}
+}
+
+//returns random 16 byte hex ids
+func RandomIDs() IDGenerator {
+	return func() interface{} {
+		b := make([]byte, 16)
+		rand.Read(b)
+		return hex.EncodeToString(b)
+	}
+}
+
+//returns random version 4 UUIDs
+func UUIDs() IDGenerator {
+	return func() interface{} {
+		b := make([]byte, 16)
+		rand.Read(b)
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	}
+}
+
+//an RPCError is the error object of a JSON-RPC response
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("jsonrpc: %d %s", e.Code, e.Message)
+}
+
+//error codes defined by the specification
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  interface{}     `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+//creates a JSON-RPC client for the endpoint
+func NewJSONRPC(url string) *JSONRPC {
+	return &JSONRPC{URL: url}
+}
+
+//creates a JSON-RPC client for the endpoint sending through the session
+func (s *Session) JSONRPC(url string) *JSONRPC {
+	return &JSONRPC{URL: url, Session: s}
+}
+
+//calls the method and decodes its result into result, an error object
+//of the response is returned as *RPCError
+func (r *JSONRPC) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	b := r.Batch()
+	call := b.Call(method, params, result)
+	if err := b.send(ctx, false); err != nil {
+		return err
+	}
+	return call.Err
+}
+
+//sends a notification, the server sends no response for it
+func (r *JSONRPC) Notify(ctx context.Context, method string, params interface{}) error {
+	b := r.Batch()
+	b.Notify(method, params)
+	return b.send(ctx, false)
+}
+
+//starts a batch of calls sent in a single request
+func (r *JSONRPC) Batch() *RPCBatch {
+	r.once.Do(func() {
+		if r.IDs == nil {
+			r.IDs = SequentialIDs()
+		}
+	})
+	return &RPCBatch{rpc: r}
+}
+
+//an RPCBatch collects calls and notifications
+type RPCBatch struct {
+	rpc   *JSONRPC
+	reqs  []rpcRequest
+	calls []*RPCCall
+}
+
+//an RPCCall is a call of a batch, Err is set after Send
+type RPCCall struct {
+	Method string
+	Result interface{}
+	Err    error
+	id     string
+}
+
+//adds a call, its result is decoded into result
+func (b *RPCBatch) Call(method string, params interface{}, result interface{}) *RPCCall {
+	id, err := json.Marshal(b.rpc.IDs())
+	call := &RPCCall{Method: method, Result: result, id: string(id), Err: err}
+	b.reqs = append(b.reqs, rpcRequest{JSONRPC: "2.0", Method: method, Params: params, ID: id})
+	b.calls = append(b.calls, call)
+	return call
+}
+
+//adds a notification
+func (b *RPCBatch) Notify(method string, params interface{}) {
+	b.reqs = append(b.reqs, rpcRequest{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+//sends the batch, the returned error is about the request as a whole, the
+//errors of single calls are in their Err field
+func (b *RPCBatch) Send(ctx context.Context) error {
+	return b.send(ctx, true)
+}
+
+func (b *RPCBatch) send(ctx context.Context, batch bool) error {
+	if len(b.reqs) == 0 {
+		return errors.New("jsonrpc: empty batch")
+	}
+	for _, call := range b.calls {
+		if call.Err != nil {
+			return call.Err
+		}
+	}
+	var payload interface{} = b.reqs
+	if !batch {
+		payload = b.reqs[0]
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	r := b.rpc
+	var c *Client
+	if r.Session != nil {
+		c = r.Session.Post(r.URL, bytes.NewReader(body))
+	} else {
+		c = Post(r.URL, bytes.NewReader(body))
+	}
+	c.SetContentType("application/json").AddHeader("Accept", "application/json")
+	for key, values := range r.Header {
+		for _, value := range values {
+			c.AddHeader(key, value)
+		}
+	}
+	if c.Error == nil && ctx != nil {
+		c.SetRequest(c.GetRequest().WithContext(ctx))
+	}
+	resp, err := c.Do()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	//the body is decoded while it is read, a batch answers with an array
+	br := bufio.NewReader(resp.Body)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		if len(b.calls) > 0 || resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("jsonrpc: empty response with status %s", resp.Status)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var responses []rpcResponse
+	if first == '[' {
+		err = decodeJSON(br, &responses, JSONOptions{})
+	} else {
+		var single rpcResponse
+		err = decodeJSON(br, &single, JSONOptions{})
+		responses = []rpcResponse{single}
+	}
+	if err != nil {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("jsonrpc: unexpected status %s", resp.Status)
+		}
+		return err
+	}
+	return b.correlate(responses)
+}
+
+//returns the first byte after whitespace without consuming it
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c, br.UnreadByte()
+	}
+}
+
+//hands the responses to the calls by id
+func (b *RPCBatch) correlate(responses []rpcResponse) error {
+	byID := map[string]rpcResponse{}
+	for _, resp := range responses {
+		id := compactID(resp.ID)
+		if id == "" || id == "null" {
+			//the server couldn't read the request, the error applies to all calls
+			if resp.Error != nil {
+				for _, call := range b.calls {
+					call.Err = resp.Error
+				}
+				return resp.Error
+			}
+			continue
+		}
+		byID[id] = resp
+	}
+	for _, call := range b.calls {
+		resp, ok := byID[call.id]
+		switch {
+		case !ok:
+			call.Err = fmt.Errorf("jsonrpc: no response for call %s with id %s", call.Method, call.id)
+		case resp.Error != nil:
+			call.Err = resp.Error
+		case call.Result != nil:
+			call.Err = json.Unmarshal(resp.Result, call.Result)
+		}
+	}
+	return nil
+}
+
+func compactID(id json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, id); err != nil {
+		return string(id)
+	}
+	return buf.String()
+}
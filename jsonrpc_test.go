@@ -0,0 +1,165 @@
+package httpcl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// answers add with the sum of the params, fail with an error and nothing for notifications
+func rpcServer(notified *[]string) *httptest.Server {
+	handle := func(req map[string]interface{}) interface{} {
+		id, hasID := req["id"]
+		if !hasID {
+			*notified = append(*notified, req["method"].(string))
+			return nil
+		}
+		switch req["method"] {
+		case "add":
+			sum := 0.0
+			for _, p := range req["params"].([]interface{}) {
+				sum += p.(float64)
+			}
+			return map[string]interface{}{"jsonrpc": "2.0", "id": id, "result": sum}
+		case "fail":
+			return map[string]interface{}{"jsonrpc": "2.0", "id": id, "error": map[string]interface{}{"code": -32000, "message": "failed", "data": map[string]interface{}{"reason": "test"}}}
+		}
+		return map[string]interface{}{"jsonrpc": "2.0", "id": id, "error": map[string]interface{}{"code": RPCMethodNotFound, "message": "Method not found"}}
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var raw json.RawMessage
+		json.NewDecoder(r.Body).Decode(&raw)
+		if raw[0] == '[' {
+			var reqs []map[string]interface{}
+			json.Unmarshal(raw, &reqs)
+			var out []interface{}
+			//answered in reverse order to test the correlation by id
+			for i := len(reqs) - 1; i >= 0; i-- {
+				if resp := handle(reqs[i]); resp != nil {
+					out = append(out, resp)
+				}
+			}
+			if len(out) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			json.NewEncoder(w).Encode(out)
+			return
+		}
+		var req map[string]interface{}
+		json.Unmarshal(raw, &req)
+		if resp := handle(req); resp != nil {
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func Test_JSONRPCCall(t *testing.T) {
+	var notified []string
+	ts := rpcServer(&notified)
+	defer ts.Close()
+
+	rpc := NewJSONRPC(ts.URL)
+	var sum int
+	if err := rpc.Call(context.Background(), "add", []int{1, 2, 3}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 6 {
+		t.Errorf("sum should be 6 is %v", sum)
+	}
+
+	err := rpc.Call(context.Background(), "fail", nil, nil)
+	rpcErr, ok := err.(*RPCError)
+	if !ok || rpcErr.Code != -32000 || string(rpcErr.Data) != `{"reason":"test"}` {
+		t.Errorf("error should be RPCError -32000 with data is %v", err)
+	}
+
+	if err := rpc.Notify(context.Background(), "log", map[string]string{"msg": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(notified) != 1 || notified[0] != "log" {
+		t.Errorf("notification should be received, got %v", notified)
+	}
+}
+
+func Test_JSONRPCBatch(t *testing.T) {
+	var notified []string
+	ts := rpcServer(&notified)
+	defer ts.Close()
+
+	rpc := NewSession().JSONRPC(ts.URL)
+	rpc.IDs = UUIDs()
+	b := rpc.Batch()
+	var a, c int
+	first := b.Call("add", []int{1, 2}, &a)
+	missing := b.Call("nope", nil, nil)
+	b.Notify("log", nil)
+	second := b.Call("add", []int{10, 20}, &c)
+	if err := b.Send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if first.Err != nil || second.Err != nil || a != 3 || c != 30 {
+		t.Errorf("results should be 3 and 30, got %v %v %v %v", a, c, first.Err, second.Err)
+	}
+	if rpcErr, ok := missing.Err.(*RPCError); !ok || rpcErr.Code != RPCMethodNotFound {
+		t.Errorf("error should be method not found is %v", missing.Err)
+	}
+	if len(notified) != 1 {
+		t.Errorf("notification should be received, got %v", notified)
+	}
+
+	only := rpc.Batch()
+	only.Notify("a", nil)
+	only.Notify("b", nil)
+	if err := only.Send(context.Background()); err != nil {
+		t.Errorf("batch of notifications should succeed, got %v", err)
+	}
+}
+
+func Test_JSONRPCIDs(t *testing.T) {
+	seq := SequentialIDs()
+	if seq() != int64(1) || seq() != int64(2) {
+		t.Error("sequential ids should count from 1")
+	}
+	if id := RandomIDs()().(string); len(id) != 32 {
+		t.Errorf("random id should have 32 hex chars is %s", id)
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if id := UUIDs()().(string); !uuid.MatchString(id) {
+		t.Errorf("uuid should be version 4 is %s", id)
+	}
+}
+
+func Test_JSONRPCLargeResponse(t *testing.T) {
+	if testing.Short() {
+		t.Skip("large response")
+	}
+	const size = 65 << 20
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		id, _ := json.Marshal(req["id"])
+		fmt.Fprintf(w, "\n {\"jsonrpc\":\"2.0\",\"id\":%s,\"result\":\"", id)
+		chunk := bytes.Repeat([]byte("a"), 1<<20)
+		for i := 0; i < size>>20; i++ {
+			w.Write(chunk)
+		}
+		w.Write([]byte(`"}`))
+	}))
+	defer ts.Close()
+
+	var result string
+	if err := NewJSONRPC(ts.URL).Call(context.Background(), "big", nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != size {
+		t.Errorf("result length should be %v is %v", size, len(result))
+	}
+}
@@ -0,0 +1,31 @@
+package httpcl
+
+import "net/http"
+
+//a Middleware wraps the transport a request is sent through, it can
+//modify the request before sending or retry it after a response
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+//adapts a function to the http.RoundTripper interface
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+//wraps base with the given middleware, the first middleware is the outermost
+func chain(base http.RoundTripper, mw []Middleware) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	for i := len(mw) - 1; i >= 0; i-- {
+		base = mw[i](base)
+	}
+	return base
+}
+
+//adds middleware to the request
+func (c *Client) Use(mw ...Middleware) *Client {
+	c.middleware = append(c.middleware, mw...)
+	return c
+}
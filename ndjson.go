@@ -0,0 +1,190 @@
+package httpcl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+)
+
+//ErrLineTooLong is returned for lines longer than JSONOptions.MaxBytes
+var ErrLineTooLong = errors.New("line too long")
+
+const ndjsonContentType = "application/x-ndjson"
+
+//a LineError is a line of a stream that could not be decoded, the
+//iteration continues with the next line
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+//Response wraps a http.Response with helpers for streamed bodies
+type Response struct {
+	*http.Response
+}
+
+//starts the request and returns the response for streaming
+func (c *Client) Stream() (*Response, error) {
+	resp, err := c.Do()
+	if err != nil {
+		return nil, err
+	}
+	return &Response{resp}, nil
+}
+
+//returns the lines of the body without line endings as they arrive,
+//the body is closed when the loop ends
+func (r *Response) Lines() iter.Seq2[[]byte, error] {
+	return Lines(r.Response)
+}
+
+//returns the lines of the body without line endings as they arrive,
+//the body is closed when the loop ends
+func Lines(resp *http.Response) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		scanLines(resp, 0, func(n int, line []byte, err error) bool {
+			return yield(line, err)
+		})
+	}
+}
+
+//NDJSON decodes each line of a newline delimited json body (JSON Lines) as it
+//arrives. Blank lines are skipped, lines that can't be decoded are reported as
+//*LineError and the iteration goes on. MaxBytes of the options limits the
+//length of a line. Reading stops with the error of the request context
+//when it is canceled.
+func NDJSON[T any](resp *http.Response, opts ...JSONOptions) iter.Seq2[T, error] {
+	var o JSONOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	max := o.MaxBytes
+	o.MaxBytes = 0
+	return func(yield func(T, error) bool) {
+		var zero T
+		scanLines(resp, max, func(n int, line []byte, err error) bool {
+			if err != nil {
+				return yield(zero, err)
+			}
+			if len(bytes.TrimSpace(line)) == 0 {
+				return true
+			}
+			var item T
+			if err := decodeJSON(bytes.NewReader(line), &item, o); err != nil {
+				return yield(zero, &LineError{Line: n, Err: err})
+			}
+			return yield(item, nil)
+		})
+	}
+}
+
+//calls fn for every line of the body with its number starting at 1 until fn
+//returns false. Errors that end the body are passed with line number 0.
+func scanLines(resp *http.Response, max int64, fn func(n int, line []byte, err error) bool) {
+	defer resp.Body.Close()
+	ctx := context.Background()
+	if resp.Request != nil {
+		ctx = resp.Request.Context()
+	}
+	//closing the body unblocks a pending read when the context is canceled
+	stop := context.AfterFunc(ctx, func() { resp.Body.Close() })
+	defer stop()
+
+	br := bufio.NewReaderSize(resp.Body, 64<<10)
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			fn(0, nil, err)
+			return
+		}
+		line, err := readLine(br, max)
+		if err == ErrLineTooLong {
+			if !fn(n, nil, &LineError{Line: n, Err: err}) {
+				return
+			}
+			continue
+		}
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			if !fn(n, line, nil) {
+				return
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			fn(0, nil, err)
+			return
+		}
+	}
+}
+
+//reads a line of any length, lines longer than max are skipped and
+//reported as ErrLineTooLong if max is set
+func readLine(br *bufio.Reader, max int64) ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			content := bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			if max > 0 && int64(len(content)) > max {
+				tooLong, line = true, nil
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if tooLong {
+			return nil, ErrLineTooLong
+		}
+		return line, err
+	}
+}
+
+//encodes the values of seq as newline delimited json body while it is sent,
+//seq is iterated once the body is read
+//
+//	httpcl.Post(url, httpcl.NDJSONBody(items))
+func NDJSONBody[T any](seq iter.Seq[T]) io.ReadCloser {
+	return typedBody{newLazyPipe(func(pw *io.PipeWriter) {
+		enc := json.NewEncoder(pw)
+		for v := range seq {
+			if err := enc.Encode(v); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}), ndjsonContentType}
+}
+
+//encodes the values received from ch as newline delimited json body while it
+//is sent, the body ends when ch is closed
+func NDJSONChannel[T any](ch <-chan T) io.ReadCloser {
+	return NDJSONBody(func(yield func(T) bool) {
+		for v := range ch {
+			if !yield(v) {
+				return
+			}
+		}
+	})
+}
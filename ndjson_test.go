@@ -0,0 +1,165 @@
+package httpcl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Lines(t *testing.T) {
+	long := strings.Repeat("x", 200<<10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "first\r\n\n"+long+"\nlast")
+	}))
+	defer ts.Close()
+
+	resp, err := Get(ts.URL).Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for line, err := range resp.Lines() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(line))
+	}
+	if len(lines) != 4 || lines[0] != "first" || lines[1] != "" || lines[2] != long || lines[3] != "last" {
+		t.Errorf("lines should be first, empty, long and last, got %v lines", len(lines))
+	}
+}
+
+func Test_NDJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":1,"name":"a"}`+"\n\n"+`{"id":"x"}`+"\n"+`{"id":3,"name":"`+strings.Repeat("c", 100)+`"}`+"\n"+`{"id":4,"name":"d"}`+"\n")
+	}))
+	defer ts.Close()
+
+	resp, err := Get(ts.URL).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	var lineErrs []*LineError
+	for item, err := range NDJSON[jsonItem](resp, JSONOptions{MaxBytes: 64}) {
+		var lerr *LineError
+		if errors.As(err, &lerr) {
+			lineErrs = append(lineErrs, lerr)
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, item.ID)
+	}
+	if fmt.Sprint(ids) != "[1 4]" {
+		t.Errorf("ids should be [1 4] is %v", ids)
+	}
+	if len(lineErrs) != 2 || lineErrs[0].Line != 3 || lineErrs[1].Line != 4 || lineErrs[1].Err != ErrLineTooLong {
+		t.Errorf("errors should be on line 3 and 4, got %v", lineErrs)
+	}
+}
+
+func Test_NDJSONContextCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; ; i++ {
+			if _, err := fmt.Fprintf(w, "{\"id\":%d}\n", i); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	resp, err := (&Client{}).SetRequest(req).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	var last error
+	for _, err := range NDJSON[jsonItem](resp) {
+		if err != nil {
+			last = err
+			break
+		}
+		count++
+		if count == 3 {
+			cancel()
+		}
+	}
+	if last != context.Canceled {
+		t.Errorf("error should be context.Canceled is %v", last)
+	}
+}
+
+func Test_NDJSONBody(t *testing.T) {
+	var contentType, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		by, _ := ioutil.ReadAll(r.Body)
+		body = string(by)
+	}))
+	defer ts.Close()
+
+	ch := make(chan jsonItem)
+	go func() {
+		for i := 1; i <= 3; i++ {
+			ch <- jsonItem{ID: i, Name: "n"}
+		}
+		close(ch)
+	}()
+	if _, err := Post(ts.URL, NDJSONChannel(ch)).Do(); err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"id\":1,\"name\":\"n\"}\n{\"id\":2,\"name\":\"n\"}\n{\"id\":3,\"name\":\"n\"}\n"
+	if body != want {
+		t.Errorf("body should be %q is %q", want, body)
+	}
+	if contentType != ndjsonContentType {
+		t.Errorf("content type should be %s is %s", ndjsonContentType, contentType)
+	}
+
+	seq := func(yield func(int) bool) {
+		for i := 0; i < 2; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+	if _, err := Post(ts.URL, NDJSONBody(seq)).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if body != "0\n1\n" {
+		t.Errorf("body should be 0 and 1 is %q", body)
+	}
+}
+
+func Test_NDJSONBodyUnsent(t *testing.T) {
+	started := false
+	body := NDJSONBody(func(yield func(int) bool) {
+		started = true
+		yield(1)
+	})
+	if cl := Post("://invalid", body); cl.Error == nil {
+		t.Error("invalid url should fail")
+	}
+	if err := body.Close(); err != nil {
+		t.Error(err.Error())
+	}
+	if started {
+		t.Error("the values should only be encoded when the body is read")
+	}
+}
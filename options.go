@@ -0,0 +1,205 @@
+package httpcl
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//creates a http client using OPTIONS, url is expanded as uri template if vars are given
+func Options(url string, vars ...Vars) *Client {
+	c := newClient("OPTIONS", url, vars)
+	c.redirect = true
+	return c
+}
+
+//creates a http client using OPTIONS bound to the session
+func (s *Session) Options(url string, vars ...Vars) *Client {
+	return s.bind(Options(url, vars...))
+}
+
+//OptionsInfo holds the Allow and CORS headers of a response
+type OptionsInfo struct {
+	//the methods of the Allow header
+	Allow []string
+	//nil if the response has no Access-Control-Allow-Origin header
+	CORS *CORSPolicy
+}
+
+//reports if the method is in the Allow header
+func (o *OptionsInfo) Allows(method string) bool {
+	return contains(o.Allow, method, false)
+}
+
+//a CORSPolicy is the set of Access-Control headers of a response
+type CORSPolicy struct {
+	AllowOrigin      string
+	AllowCredentials bool
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	//zero if the header is missing, browsers then cache for 5 seconds
+	MaxAge time.Duration
+}
+
+//parses the Allow and CORS headers of the response
+func ParseOptions(resp *http.Response) *OptionsInfo {
+	h := resp.Header
+	info := &OptionsInfo{Allow: headerList(h, "Allow")}
+	if _, ok := h[textproto.CanonicalMIMEHeaderKey("Access-Control-Allow-Origin")]; ok {
+		info.CORS = &CORSPolicy{
+			AllowOrigin:      strings.Join(h.Values("Access-Control-Allow-Origin"), ","),
+			AllowCredentials: strings.TrimSpace(h.Get("Access-Control-Allow-Credentials")) == "true",
+			AllowMethods:     headerList(h, "Access-Control-Allow-Methods"),
+			AllowHeaders:     headerList(h, "Access-Control-Allow-Headers"),
+			ExposeHeaders:    headerList(h, "Access-Control-Expose-Headers"),
+		}
+		if age, err := strconv.Atoi(strings.TrimSpace(h.Get("Access-Control-Max-Age"))); err == nil && age > 0 {
+			info.CORS.MaxAge = time.Duration(age) * time.Second
+		}
+	}
+	return info
+}
+
+//sends the OPTIONS request and parses the Allow and CORS headers
+func (c *Client) Options() (*OptionsInfo, error) {
+	if c.Error == nil && c.request != nil && c.request.Method != "OPTIONS" {
+		c.Error = fmt.Errorf("options needs an OPTIONS request, got %s", c.request.Method)
+	}
+	resp, err := c.Do()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return ParseOptions(resp), nil
+}
+
+//returns the comma separated values of all fields with the name
+func headerList(h http.Header, name string) []string {
+	var list []string
+	for _, value := range h.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+func contains(list []string, s string, fold bool) bool {
+	for _, item := range list {
+		if item == s || fold && strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//a CORSRequest describes a cross origin request made by a browser
+type CORSRequest struct {
+	Origin string
+	Method string
+	//the names of the headers set by the script
+	Headers []string
+	//cookies or http auth are sent (credentials mode include)
+	Credentials bool
+}
+
+//a CORSError tells why a browser would block a request
+type CORSError struct {
+	Reason string
+}
+
+func (e *CORSError) Error() string {
+	return "cors: " + e.Reason
+}
+
+//methods and headers that never need permission
+var (
+	safelistedMethods = map[string]bool{"GET": true, "HEAD": true, "POST": true}
+	safelistedHeaders = map[string]bool{"accept": true, "accept-language": true, "content-language": true}
+)
+
+//Check tells if a browser would allow the request with the policy of a
+//preflight response, a denial is returned as *CORSError. Content-Type is
+//checked like any other header, a simple form post should not list it.
+func (p *CORSPolicy) Check(r CORSRequest) error {
+	if p == nil {
+		return &CORSError{"no Access-Control-Allow-Origin header"}
+	}
+	switch {
+	case strings.Contains(p.AllowOrigin, ","):
+		return &CORSError{fmt.Sprintf("multiple origins allowed %q", p.AllowOrigin)}
+	case p.AllowOrigin == "*" && r.Credentials:
+		return &CORSError{"wildcard origin is not allowed with credentials"}
+	case p.AllowOrigin != "*" && p.AllowOrigin != r.Origin:
+		return &CORSError{fmt.Sprintf("origin %s is not allowed, only %s", r.Origin, p.AllowOrigin)}
+	case r.Credentials && !p.AllowCredentials:
+		return &CORSError{"credentials are not allowed"}
+	}
+
+	method := normalizeMethod(r.Method)
+	wildcard := !r.Credentials
+	if !safelistedMethods[method] && !contains(p.AllowMethods, method, false) && !(wildcard && contains(p.AllowMethods, "*", false)) {
+		return &CORSError{fmt.Sprintf("method %s is not allowed", r.Method)}
+	}
+	for _, name := range r.Headers {
+		lower := strings.ToLower(name)
+		if safelistedHeaders[lower] || contains(p.AllowHeaders, name, true) {
+			continue
+		}
+		//the wildcard never covers Authorization
+		if wildcard && lower != "authorization" && contains(p.AllowHeaders, "*", false) {
+			continue
+		}
+		return &CORSError{fmt.Sprintf("header %s is not allowed", name)}
+	}
+	return nil
+}
+
+//browsers uppercase these methods, all others are sent as written
+func normalizeMethod(method string) string {
+	switch upper := strings.ToUpper(method); upper {
+	case "DELETE", "GET", "HEAD", "OPTIONS", "POST", "PUT":
+		return upper
+	}
+	return method
+}
+
+//Preflight sends the OPTIONS request a browser sends before the cross
+//origin request r and checks the response like the browser would. The
+//policy is returned also when the request would be blocked. Browsers send
+//preflights without credentials, so the client should have no auth set.
+//
+//	policy, err := httpcl.Options(url).Preflight(httpcl.CORSRequest{Origin: "https://app.example.com", Method: "PUT"})
+func (c *Client) Preflight(r CORSRequest) (*CORSPolicy, error) {
+	c.runWithHasRequest(func() {
+		c.request.Header.Set("Origin", r.Origin)
+		c.request.Header.Set("Access-Control-Request-Method", normalizeMethod(r.Method))
+		var names []string
+		for _, name := range r.Headers {
+			if lower := strings.ToLower(name); !safelistedHeaders[lower] && !contains(names, lower, false) {
+				names = append(names, lower)
+			}
+		}
+		if len(names) > 0 {
+			sort.Strings(names)
+			c.request.Header.Set("Access-Control-Request-Headers", strings.Join(names, ","))
+		}
+	})
+	//redirects of preflights are blocked by browsers
+	c.FollowRedirect(false)
+	info, err := c.Options()
+	if err != nil {
+		return nil, err
+	}
+	if c.StatusCode < 200 || c.StatusCode > 299 {
+		return info.CORS, &CORSError{fmt.Sprintf("preflight failed with status %d", c.StatusCode)}
+	}
+	return info.CORS, info.CORS.Check(r)
+}
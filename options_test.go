@@ -0,0 +1,121 @@
+package httpcl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func corsServer(requests *[]*http.Request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r)
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/api", http.StatusTemporaryRedirect)
+			return
+		}
+		h := w.Header()
+		h.Set("Allow", "GET, HEAD")
+		h.Add("Allow", "PUT,OPTIONS")
+		switch r.URL.Path {
+		case "/api":
+			if r.Header.Get("Origin") == "https://app.example.com" {
+				h.Set("Access-Control-Allow-Origin", "https://app.example.com")
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			h.Set("Access-Control-Allow-Methods", "PUT, PATCH")
+			h.Set("Access-Control-Allow-Headers", "X-Token, Content-Type")
+			h.Set("Access-Control-Expose-Headers", "ETag")
+			h.Set("Access-Control-Max-Age", "600")
+		case "/public":
+			h.Set("Access-Control-Allow-Origin", "*")
+			h.Set("Access-Control-Allow-Methods", "*")
+			h.Set("Access-Control-Allow-Headers", "*")
+		case "/error":
+			h.Set("Access-Control-Allow-Origin", "*")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func Test_Options(t *testing.T) {
+	var requests []*http.Request
+	ts := corsServer(&requests)
+	defer ts.Close()
+
+	info, err := NewSession().Options(ts.URL + "/plain").Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests[0].Method != "OPTIONS" {
+		t.Errorf("method should be OPTIONS is %s", requests[0].Method)
+	}
+	if strings.Join(info.Allow, ",") != "GET,HEAD,PUT,OPTIONS" || !info.Allows("PUT") || info.Allows("DELETE") {
+		t.Errorf("allow should be GET,HEAD,PUT,OPTIONS is %v", info.Allow)
+	}
+	if info.CORS != nil {
+		t.Error("cors should be nil without Access-Control-Allow-Origin")
+	}
+
+	info, _ = Options(ts.URL+"/api").AddHeader("Origin", "https://app.example.com").Options()
+	cors := info.CORS
+	if cors == nil || cors.AllowOrigin != "https://app.example.com" || !cors.AllowCredentials || cors.MaxAge != 10*time.Minute ||
+		strings.Join(cors.AllowMethods, ",") != "PUT,PATCH" || strings.Join(cors.ExposeHeaders, ",") != "ETag" {
+		t.Errorf("cors policy is %+v", cors)
+	}
+
+	if _, err := Get(ts.URL).Options(); err == nil {
+		t.Error("options should fail for a GET request")
+	}
+}
+
+func Test_Preflight(t *testing.T) {
+	var requests []*http.Request
+	ts := corsServer(&requests)
+	defer ts.Close()
+
+	origin := "https://app.example.com"
+	policy, err := Options(ts.URL + "/api").Preflight(CORSRequest{Origin: origin, Method: "put", Headers: []string{"X-Token", "Accept", "content-type"}, Credentials: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy == nil || policy.AllowOrigin != origin {
+		t.Errorf("policy should be returned is %+v", policy)
+	}
+	r := requests[len(requests)-1]
+	if r.Header.Get("Origin") != origin || r.Header.Get("Access-Control-Request-Method") != "PUT" || r.Header.Get("Access-Control-Request-Headers") != "content-type,x-token" {
+		t.Errorf("preflight headers are %v", r.Header)
+	}
+
+	tests := []struct {
+		path string
+		req  CORSRequest
+		err  string
+	}{
+		{"/api", CORSRequest{Origin: "https://evil.example.com", Method: "PUT"}, "no Access-Control-Allow-Origin"},
+		{"/api", CORSRequest{Origin: origin, Method: "DELETE"}, "method DELETE"},
+		{"/api", CORSRequest{Origin: origin, Method: "patch"}, "method patch"},
+		{"/api", CORSRequest{Origin: origin, Method: "PATCH", Headers: []string{"X-Other"}}, "header X-Other"},
+		{"/api", CORSRequest{Origin: origin, Method: "GET"}, ""},
+		{"/public", CORSRequest{Origin: origin, Method: "DELETE", Headers: []string{"X-Any"}}, ""},
+		{"/public", CORSRequest{Origin: origin, Method: "GET", Headers: []string{"Authorization"}}, "header Authorization"},
+		{"/public", CORSRequest{Origin: origin, Method: "GET", Credentials: true}, "wildcard origin"},
+		{"/error", CORSRequest{Origin: origin, Method: "GET"}, "status 403"},
+		{"/moved", CORSRequest{Origin: origin, Method: "GET"}, "status 307"},
+	}
+	for _, test := range tests {
+		_, err := Options(ts.URL + test.path).Preflight(test.req)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s %+v should be allowed, got %v", test.path, test.req, err)
+			}
+			continue
+		}
+		if _, ok := err.(*CORSError); !ok || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s %+v should fail with %s, got %v", test.path, test.req, test.err, err)
+		}
+	}
+}
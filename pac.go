@@ -0,0 +1,940 @@
+package httpcl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//PAC evaluates a proxy auto-config file. Supported is the subset of
+//JavaScript PAC files are usually written in: function and var
+//declarations, if/else, return, assignments, string concatenation, the
+//operators ! == != === !== < > <= >= && || and the string methods toLowerCase,
+//toUpperCase, substring, indexOf and length. The helper functions are
+//isPlainHostName, dnsDomainIs, localHostOrDomainIs, isResolvable, isInNet,
+//dnsResolve, myIpAddress, dnsDomainLevels and shExpMatch.
+type PAC struct {
+	//returned by myIpAddress, detected from the default route if nil
+	MyIP net.IP
+	//resolves host names, defaults to net.LookupIP
+	LookupIP func(host string) ([]net.IP, error)
+
+	funcs   map[string]*pacFunc
+	globals []pacNode
+}
+
+//parses the source of a PAC file, it has to define FindProxyForURL(url, host)
+func ParsePAC(src string) (*PAC, error) {
+	toks, err := pacTokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &pacParser{toks: toks}
+	pac := &PAC{funcs: map[string]*pacFunc{}}
+	for !p.at(pacEOF, "") {
+		if p.at(pacIdent, "function") {
+			f, err := p.function()
+			if err != nil {
+				return nil, err
+			}
+			pac.funcs[f.name] = f
+			continue
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		pac.globals = append(pac.globals, stmt)
+	}
+	if f := pac.funcs["FindProxyForURL"]; f == nil || len(f.params) != 2 {
+		return nil, errors.New("pac: FindProxyForURL(url, host) is not defined")
+	}
+	return pac, nil
+}
+
+//FindProxy calls FindProxyForURL and returns its result like
+//"PROXY proxy:3128; DIRECT". The path and query of https urls are not
+//passed to the script.
+func (p *PAC) FindProxy(u *url.URL) (string, error) {
+	target := *u
+	target.User = nil
+	if target.Scheme == "https" {
+		target.Path, target.RawPath, target.RawQuery = "/", "", ""
+	}
+	target.Fragment = ""
+	in := &pacInterp{pac: p, vars: map[string]interface{}{}}
+	for _, stmt := range p.globals {
+		if _, _, err := in.exec(stmt, in.vars); err != nil {
+			return "", err
+		}
+	}
+	v, err := in.call(p.funcs["FindProxyForURL"], []interface{}{target.String(), u.Hostname()})
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("pac: FindProxyForURL returned %v", pacString(v))
+	}
+	return s, nil
+}
+
+//returns the first proxy FindProxy returns for the url, nil for DIRECT.
+//Fallback entries are ignored since the transport can't try them.
+func (p *PAC) ProxyURL(u *url.URL) (*url.URL, error) {
+	result, err := p.FindProxy(u)
+	if err != nil {
+		return nil, err
+	}
+	first := strings.TrimSpace(strings.Split(result, ";")[0])
+	fields := strings.Fields(first)
+	if len(fields) == 0 || strings.EqualFold(fields[0], "DIRECT") {
+		return nil, nil
+	}
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("pac: invalid result %q", result)
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "PROXY", "HTTP":
+		return parseProxyURL("http://" + fields[1])
+	case "HTTPS":
+		return parseProxyURL("https://" + fields[1])
+	case "SOCKS", "SOCKS5":
+		return parseProxyURL("socks5://" + fields[1])
+	}
+	return nil, fmt.Errorf("pac: unsupported proxy type %s", fields[0])
+}
+
+//the tokenizer
+
+type pacTokenKind int
+
+const (
+	pacEOF pacTokenKind = iota
+	pacIdent
+	pacStr
+	pacNumber
+	pacPunct
+)
+
+type pacToken struct {
+	kind pacTokenKind
+	text string
+	line int
+}
+
+var pacPuncts = []string{"===", "!==", "==", "!=", "<=", ">=", "&&", "||", "{", "}", "(", ")", ";", ",", ".", "!", "=", "<", ">", "+"}
+
+func pacTokenize(src string) ([]pacToken, error) {
+	var toks []pacToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("pac: line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\n' {
+					return nil, fmt.Errorf("pac: line %d: unterminated string", line)
+				}
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(src[j])
+					}
+					continue
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("pac: line %d: unterminated string", line)
+			}
+			toks = append(toks, pacToken{pacStr, sb.String(), line})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, pacToken{pacNumber, src[i:j], line})
+			i = j
+		case c == '_' || c == '$' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '$' || unicode.IsLetter(rune(src[j])) || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, pacToken{pacIdent, src[i:j], line})
+			i = j
+		default:
+			matched := false
+			for _, punct := range pacPuncts {
+				if strings.HasPrefix(src[i:], punct) {
+					toks = append(toks, pacToken{pacPunct, punct, line})
+					i += len(punct)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("pac: line %d: unexpected character %q", line, c)
+			}
+		}
+	}
+	return append(toks, pacToken{pacEOF, "", line}), nil
+}
+
+//the parser
+
+type pacNodeKind int
+
+const (
+	pacBlock pacNodeKind = iota
+	pacIf
+	pacReturn
+	pacVar
+	pacAssign
+	pacExprStmt
+	pacLiteral
+	pacName
+	pacCall
+	pacMethod
+	pacProperty
+	pacNot
+	pacBinary
+)
+
+//a pacNode is a statement or an expression
+type pacNode struct {
+	kind  pacNodeKind
+	op    string
+	name  string
+	value interface{}
+	args  []pacNode
+	line  int
+}
+
+type pacFunc struct {
+	name   string
+	params []string
+	body   pacNode
+}
+
+type pacParser struct {
+	toks []pacToken
+	pos  int
+}
+
+func (p *pacParser) peek() pacToken {
+	return p.toks[p.pos]
+}
+
+func (p *pacParser) next() pacToken {
+	t := p.toks[p.pos]
+	if t.kind != pacEOF {
+		p.pos++
+	}
+	return t
+}
+
+//reports if the next token is of kind and has the text, any text if empty
+func (p *pacParser) at(kind pacTokenKind, text string) bool {
+	t := p.peek()
+	return t.kind == kind && (text == "" || t.text == text)
+}
+
+func (p *pacParser) accept(kind pacTokenKind, text string) bool {
+	if p.at(kind, text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *pacParser) expect(kind pacTokenKind, text string) (pacToken, error) {
+	if !p.at(kind, text) {
+		t := p.peek()
+		want := text
+		if want == "" {
+			want = "identifier"
+		}
+		return t, fmt.Errorf("pac: line %d: expected %s, got %q", t.line, want, t.text)
+	}
+	return p.next(), nil
+}
+
+func (p *pacParser) function() (*pacFunc, error) {
+	p.next()
+	name, err := p.expect(pacIdent, "")
+	if err != nil {
+		return nil, err
+	}
+	f := &pacFunc{name: name.text}
+	if _, err := p.expect(pacPunct, "("); err != nil {
+		return nil, err
+	}
+	for !p.accept(pacPunct, ")") {
+		param, err := p.expect(pacIdent, "")
+		if err != nil {
+			return nil, err
+		}
+		f.params = append(f.params, param.text)
+		if !p.at(pacPunct, ")") {
+			if _, err := p.expect(pacPunct, ","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !p.at(pacPunct, "{") {
+		_, err := p.expect(pacPunct, "{")
+		return nil, err
+	}
+	f.body, err = p.statement()
+	return f, err
+}
+
+func (p *pacParser) statement() (pacNode, error) {
+	t := p.peek()
+	switch {
+	case p.accept(pacPunct, "{"):
+		block := pacNode{kind: pacBlock, line: t.line}
+		for !p.accept(pacPunct, "}") {
+			if p.at(pacEOF, "") {
+				return block, fmt.Errorf("pac: line %d: unterminated block", t.line)
+			}
+			stmt, err := p.statement()
+			if err != nil {
+				return block, err
+			}
+			block.args = append(block.args, stmt)
+		}
+		return block, nil
+	case p.accept(pacPunct, ";"):
+		return pacNode{kind: pacBlock, line: t.line}, nil
+	case p.accept(pacIdent, "if"):
+		if _, err := p.expect(pacPunct, "("); err != nil {
+			return pacNode{}, err
+		}
+		cond, err := p.expr()
+		if err != nil {
+			return pacNode{}, err
+		}
+		if _, err := p.expect(pacPunct, ")"); err != nil {
+			return pacNode{}, err
+		}
+		then, err := p.statement()
+		if err != nil {
+			return pacNode{}, err
+		}
+		node := pacNode{kind: pacIf, args: []pacNode{cond, then}, line: t.line}
+		if p.accept(pacIdent, "else") {
+			otherwise, err := p.statement()
+			if err != nil {
+				return pacNode{}, err
+			}
+			node.args = append(node.args, otherwise)
+		}
+		return node, nil
+	case p.accept(pacIdent, "return"):
+		node := pacNode{kind: pacReturn, line: t.line}
+		if !p.at(pacPunct, ";") && !p.at(pacPunct, "}") {
+			value, err := p.expr()
+			if err != nil {
+				return pacNode{}, err
+			}
+			node.args = []pacNode{value}
+		}
+		p.accept(pacPunct, ";")
+		return node, nil
+	case p.at(pacIdent, "var") || p.at(pacIdent, "let") || p.at(pacIdent, "const"):
+		p.next()
+		block := pacNode{kind: pacBlock, line: t.line}
+		for {
+			name, err := p.expect(pacIdent, "")
+			if err != nil {
+				return pacNode{}, err
+			}
+			value := pacNode{kind: pacLiteral, line: name.line}
+			if p.accept(pacPunct, "=") {
+				if value, err = p.expr(); err != nil {
+					return pacNode{}, err
+				}
+			}
+			block.args = append(block.args, pacNode{kind: pacVar, name: name.text, args: []pacNode{value}, line: name.line})
+			if !p.accept(pacPunct, ",") {
+				break
+			}
+		}
+		p.accept(pacPunct, ";")
+		return block, nil
+	}
+	value, err := p.expr()
+	if err != nil {
+		return pacNode{}, err
+	}
+	if value.kind == pacName && p.accept(pacPunct, "=") {
+		assigned, err := p.expr()
+		if err != nil {
+			return pacNode{}, err
+		}
+		value = pacNode{kind: pacAssign, name: value.name, args: []pacNode{assigned}, line: t.line}
+	} else {
+		value = pacNode{kind: pacExprStmt, args: []pacNode{value}, line: t.line}
+	}
+	p.accept(pacPunct, ";")
+	return value, nil
+}
+
+//binary operators by precedence, lowest first
+var pacLevels = [][]string{{"||"}, {"&&"}, {"==", "!=", "===", "!=="}, {"<", ">", "<=", ">="}, {"+"}}
+
+func (p *pacParser) expr() (pacNode, error) {
+	return p.binary(0)
+}
+
+func (p *pacParser) binary(level int) (pacNode, error) {
+	if level == len(pacLevels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return left, err
+	}
+	for {
+		t := p.peek()
+		matched := false
+		for _, op := range pacLevels[level] {
+			if t.kind == pacPunct && t.text == op {
+				matched = true
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return left, err
+		}
+		left = pacNode{kind: pacBinary, op: t.text, args: []pacNode{left, right}, line: t.line}
+	}
+}
+
+func (p *pacParser) unary() (pacNode, error) {
+	if t := p.peek(); p.accept(pacPunct, "!") {
+		operand, err := p.unary()
+		return pacNode{kind: pacNot, args: []pacNode{operand}, line: t.line}, err
+	}
+	return p.postfix()
+}
+
+func (p *pacParser) postfix() (pacNode, error) {
+	node, err := p.primary()
+	if err != nil {
+		return node, err
+	}
+	for p.accept(pacPunct, ".") {
+		name, err := p.expect(pacIdent, "")
+		if err != nil {
+			return node, err
+		}
+		if p.at(pacPunct, "(") {
+			args, err := p.arguments()
+			if err != nil {
+				return node, err
+			}
+			node = pacNode{kind: pacMethod, name: name.text, args: append([]pacNode{node}, args...), line: name.line}
+		} else {
+			node = pacNode{kind: pacProperty, name: name.text, args: []pacNode{node}, line: name.line}
+		}
+	}
+	return node, nil
+}
+
+func (p *pacParser) arguments() ([]pacNode, error) {
+	p.next()
+	var args []pacNode
+	for !p.accept(pacPunct, ")") {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.at(pacPunct, ")") {
+			if _, err := p.expect(pacPunct, ","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return args, nil
+}
+
+func (p *pacParser) primary() (pacNode, error) {
+	t := p.next()
+	switch t.kind {
+	case pacStr:
+		return pacNode{kind: pacLiteral, value: t.text, line: t.line}, nil
+	case pacNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return pacNode{}, fmt.Errorf("pac: line %d: invalid number %s", t.line, t.text)
+		}
+		return pacNode{kind: pacLiteral, value: n, line: t.line}, nil
+	case pacIdent:
+		switch t.text {
+		case "true", "false":
+			return pacNode{kind: pacLiteral, value: t.text == "true", line: t.line}, nil
+		case "null", "undefined":
+			return pacNode{kind: pacLiteral, line: t.line}, nil
+		}
+		if p.at(pacPunct, "(") {
+			args, err := p.arguments()
+			return pacNode{kind: pacCall, name: t.text, args: args, line: t.line}, err
+		}
+		return pacNode{kind: pacName, name: t.text, line: t.line}, nil
+	case pacPunct:
+		if t.text == "(" {
+			node, err := p.expr()
+			if err != nil {
+				return node, err
+			}
+			_, err = p.expect(pacPunct, ")")
+			return node, err
+		}
+	}
+	return pacNode{}, fmt.Errorf("pac: line %d: unexpected %q", t.line, t.text)
+}
+
+//the interpreter, values are string, float64, bool and nil
+
+type pacInterp struct {
+	pac   *PAC
+	vars  map[string]interface{}
+	depth int
+}
+
+//runs a statement, returned reports if a return statement was executed
+func (in *pacInterp) exec(n pacNode, scope map[string]interface{}) (value interface{}, returned bool, err error) {
+	switch n.kind {
+	case pacBlock:
+		for _, stmt := range n.args {
+			if value, returned, err = in.exec(stmt, scope); err != nil || returned {
+				return
+			}
+		}
+		return nil, false, nil
+	case pacIf:
+		cond, err := in.eval(n.args[0], scope)
+		if err != nil {
+			return nil, false, err
+		}
+		if pacTruthy(cond) {
+			return in.exec(n.args[1], scope)
+		} else if len(n.args) > 2 {
+			return in.exec(n.args[2], scope)
+		}
+		return nil, false, nil
+	case pacReturn:
+		if len(n.args) == 0 {
+			return nil, true, nil
+		}
+		value, err = in.eval(n.args[0], scope)
+		return value, true, err
+	case pacVar:
+		value, err = in.eval(n.args[0], scope)
+		scope[n.name] = value
+		return nil, false, err
+	case pacAssign:
+		value, err = in.eval(n.args[0], scope)
+		if _, local := scope[n.name]; local {
+			scope[n.name] = value
+		} else {
+			in.vars[n.name] = value
+		}
+		return nil, false, err
+	case pacExprStmt:
+		_, err = in.eval(n.args[0], scope)
+		return nil, false, err
+	}
+	return nil, false, fmt.Errorf("pac: line %d: invalid statement", n.line)
+}
+
+func (in *pacInterp) eval(n pacNode, scope map[string]interface{}) (interface{}, error) {
+	switch n.kind {
+	case pacLiteral:
+		return n.value, nil
+	case pacName:
+		if v, ok := scope[n.name]; ok {
+			return v, nil
+		}
+		if v, ok := in.vars[n.name]; ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("pac: line %d: %s is not defined", n.line, n.name)
+	case pacNot:
+		v, err := in.eval(n.args[0], scope)
+		return !pacTruthy(v), err
+	case pacBinary:
+		left, err := in.eval(n.args[0], scope)
+		if err != nil {
+			return nil, err
+		}
+		//&& and || short circuit and return an operand like JavaScript
+		switch n.op {
+		case "&&":
+			if !pacTruthy(left) {
+				return left, nil
+			}
+			return in.eval(n.args[1], scope)
+		case "||":
+			if pacTruthy(left) {
+				return left, nil
+			}
+			return in.eval(n.args[1], scope)
+		}
+		right, err := in.eval(n.args[1], scope)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "+":
+			l, lnum := left.(float64)
+			r, rnum := right.(float64)
+			if lnum && rnum {
+				return l + r, nil
+			}
+			return pacString(left) + pacString(right), nil
+		case "==", "===":
+			return pacEqual(left, right, n.op == "==="), nil
+		case "<", ">", "<=", ">=":
+			return pacCompare(n.op, left, right), nil
+		default:
+			return !pacEqual(left, right, n.op == "!=="), nil
+		}
+	case pacCall:
+		args, err := in.evalArgs(n.args, scope)
+		if err != nil {
+			return nil, err
+		}
+		if f := in.pac.funcs[n.name]; f != nil {
+			return in.call(f, args)
+		}
+		builtin, ok := pacBuiltins[n.name]
+		if !ok {
+			return nil, fmt.Errorf("pac: line %d: unsupported function %s", n.line, n.name)
+		}
+		return builtin(in.pac, args)
+	case pacProperty:
+		v, err := in.eval(n.args[0], scope)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := v.(string); ok && n.name == "length" {
+			return float64(len(s)), nil
+		}
+		return nil, fmt.Errorf("pac: line %d: unsupported property %s", n.line, n.name)
+	case pacMethod:
+		args, err := in.evalArgs(n.args, scope)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("pac: line %d: %s called on %v", n.line, n.name, pacString(args[0]))
+		}
+		return pacStringMethod(s, n.name, args[1:], n.line)
+	}
+	return nil, fmt.Errorf("pac: line %d: invalid expression", n.line)
+}
+
+func (in *pacInterp) evalArgs(nodes []pacNode, scope map[string]interface{}) ([]interface{}, error) {
+	args := make([]interface{}, len(nodes))
+	for i, node := range nodes {
+		v, err := in.eval(node, scope)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+func (in *pacInterp) call(f *pacFunc, args []interface{}) (interface{}, error) {
+	if in.depth > 100 {
+		return nil, errors.New("pac: too much recursion")
+	}
+	in.depth++
+	defer func() { in.depth-- }()
+	scope := map[string]interface{}{}
+	for i, param := range f.params {
+		if i < len(args) {
+			scope[param] = args[i]
+		} else {
+			scope[param] = nil
+		}
+	}
+	v, _, err := in.exec(f.body, scope)
+	return v, err
+}
+
+func pacTruthy(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case float64:
+		return t != 0 && !math.IsNaN(t)
+	}
+	return false
+}
+
+func pacString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	return "null"
+}
+
+func pacEqual(a, b interface{}, strict bool) bool {
+	if !strict {
+		//loose equality converts a string compared with a number
+		if s, ok := a.(string); ok {
+			if n, ok := b.(float64); ok {
+				f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+				return err == nil && f == n
+			}
+		}
+		if n, ok := a.(float64); ok {
+			if _, ok := b.(string); ok {
+				return pacEqual(b, n, false)
+			}
+		}
+	}
+	return a == b
+}
+
+//compares strings by their bytes and everything else as numbers
+func pacCompare(op string, a, b interface{}) bool {
+	as, astr := a.(string)
+	bs, bstr := b.(string)
+	c := 0
+	if astr && bstr {
+		c = strings.Compare(as, bs)
+	} else {
+		x, y := pacToNumber(a), pacToNumber(b)
+		if math.IsNaN(x) || math.IsNaN(y) {
+			return false
+		}
+		switch {
+		case x < y:
+			c = -1
+		case x > y:
+			c = 1
+		}
+	}
+	switch op {
+	case "<":
+		return c < 0
+	case ">":
+		return c > 0
+	case "<=":
+		return c <= 0
+	}
+	return c >= 0
+}
+
+func pacToNumber(v interface{}) float64 {
+	switch t := v.(type) {
+	case float64:
+		return t
+	case bool:
+		if t {
+			return 1
+		}
+		return 0
+	case string:
+		if strings.TrimSpace(t) == "" {
+			return 0
+		}
+		if n, err := strconv.ParseFloat(strings.TrimSpace(t), 64); err == nil {
+			return n
+		}
+		return math.NaN()
+	}
+	return 0
+}
+
+func pacStringMethod(s, name string, args []interface{}, line int) (interface{}, error) {
+	index := func(i int) int {
+		if i >= len(args) {
+			return -1
+		}
+		n, _ := args[i].(float64)
+		return int(math.Max(0, math.Min(n, float64(len(s)))))
+	}
+	switch name {
+	case "toLowerCase":
+		return strings.ToLower(s), nil
+	case "toUpperCase":
+		return strings.ToUpper(s), nil
+	case "indexOf":
+		if len(args) == 0 {
+			return float64(-1), nil
+		}
+		return float64(strings.Index(s, pacString(args[0]))), nil
+	case "substring":
+		start, end := index(0), index(1)
+		if start < 0 {
+			start = 0
+		}
+		if end < 0 {
+			end = len(s)
+		}
+		if start > end {
+			start, end = end, start
+		}
+		return s[start:end], nil
+	}
+	return nil, fmt.Errorf("pac: line %d: unsupported method %s", line, name)
+}
+
+//the PAC helper functions
+
+var pacBuiltins map[string]func(p *PAC, args []interface{}) (interface{}, error)
+
+func init() {
+	pacBuiltins = map[string]func(p *PAC, args []interface{}) (interface{}, error){
+		"isPlainHostName": func(p *PAC, args []interface{}) (interface{}, error) {
+			return !strings.Contains(pacArg(args, 0), "."), nil
+		},
+		"dnsDomainIs": func(p *PAC, args []interface{}) (interface{}, error) {
+			return strings.HasSuffix(strings.ToLower(pacArg(args, 0)), strings.ToLower(pacArg(args, 1))), nil
+		},
+		"localHostOrDomainIs": func(p *PAC, args []interface{}) (interface{}, error) {
+			host, hostdom := strings.ToLower(pacArg(args, 0)), strings.ToLower(pacArg(args, 1))
+			if strings.Contains(host, ".") {
+				return host == hostdom, nil
+			}
+			return host == hostdom || strings.HasPrefix(hostdom, host+"."), nil
+		},
+		"isResolvable": func(p *PAC, args []interface{}) (interface{}, error) {
+			return p.resolve(pacArg(args, 0)) != nil, nil
+		},
+		"dnsResolve": func(p *PAC, args []interface{}) (interface{}, error) {
+			if ip := p.resolve(pacArg(args, 0)); ip != nil {
+				return ip.String(), nil
+			}
+			return nil, nil
+		},
+		"isInNet": func(p *PAC, args []interface{}) (interface{}, error) {
+			ip := p.resolve(pacArg(args, 0))
+			pattern, mask := net.ParseIP(pacArg(args, 1)).To4(), net.ParseIP(pacArg(args, 2)).To4()
+			if ip == nil || pattern == nil || mask == nil {
+				return false, nil
+			}
+			return ip.To4().Mask(net.IPMask(mask)).Equal(pattern.Mask(net.IPMask(mask))), nil
+		},
+		"myIpAddress": func(p *PAC, args []interface{}) (interface{}, error) {
+			return p.myIP().String(), nil
+		},
+		"dnsDomainLevels": func(p *PAC, args []interface{}) (interface{}, error) {
+			return float64(strings.Count(pacArg(args, 0), ".")), nil
+		},
+		"shExpMatch": func(p *PAC, args []interface{}) (interface{}, error) {
+			return shExpMatch(pacArg(args, 0), pacArg(args, 1)), nil
+		},
+	}
+}
+
+func pacArg(args []interface{}, i int) string {
+	if i >= len(args) {
+		return ""
+	}
+	return pacString(args[i])
+}
+
+//returns the first IPv4 address of host, nil if it can't be resolved
+func (p *PAC) resolve(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	lookup := net.LookupIP
+	if p.LookupIP != nil {
+		lookup = p.LookupIP
+	}
+	ips, err := lookup(host)
+	if err != nil {
+		return nil
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4
+		}
+	}
+	return nil
+}
+
+func (p *PAC) myIP() net.IP {
+	if p.MyIP != nil {
+		return p.MyIP
+	}
+	//no packet is sent, connecting an udp socket only picks the route
+	if conn, err := net.Dial("udp4", "192.0.2.1:9"); err == nil {
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).IP
+	}
+	return net.IPv4(127, 0, 0, 1)
+}
+
+//matches s against a shell expression with * and ?, unlike path.Match the
+//* also matches slashes
+func shExpMatch(s, pattern string) bool {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	return err == nil && re.MatchString(s)
+}
@@ -0,0 +1,178 @@
+package httpcl
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const testPAC = `
+/* company proxy config */
+var proxy = "PROXY proxy.example.com:3128";
+
+function isInternal(host) {
+	return dnsDomainIs(host, ".corp.example.com") || isInNet(dnsResolve(host), "10.0.0.0", "255.0.0.0");
+}
+
+function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+	if (isPlainHostName(host) || isInternal(host))
+		return "DIRECT";
+	if (shExpMatch(url, "*/downloads/*") && url.substring(0, 5) == "http:")
+		return "SOCKS socks.example.com:1080";
+	if (localHostOrDomainIs(host, "www.example.org") || host.indexOf("secure") === 0) {
+		return "HTTPS secure.example.com:443; DIRECT";
+	}
+	if (dnsDomainLevels(host) > 3) {
+		return "DIRECT";
+	}
+	if (!isResolvable(host)) {
+		return proxy + "; DIRECT";
+	}
+	if (isInNet(myIpAddress(), "192.168.0.0", "255.255.0.0"))
+		return proxy;
+	return 'DIRECT';
+}
+`
+
+func testLookup(host string) ([]net.IP, error) {
+	switch host {
+	case "intranet.example.com":
+		return []net.IP{net.ParseIP("10.1.2.3")}, nil
+	case "www.example.com":
+		return []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("93.184.216.34")}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func Test_PAC(t *testing.T) {
+	pac, err := ParsePAC(testPAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pac.LookupIP = testLookup
+	pac.MyIP = net.ParseIP("192.168.1.10")
+
+	tests := []struct {
+		url, result, proxy string
+	}{
+		{"http://intranet/", "DIRECT", ""},
+		{"http://wiki.corp.example.com/", "DIRECT", ""},
+		{"http://intranet.example.com/", "DIRECT", ""},
+		{"http://www.example.com/downloads/a.zip", "SOCKS socks.example.com:1080", "socks5://socks.example.com:1080"},
+		{"https://www.example.com/downloads/a.zip", "PROXY proxy.example.com:3128", "http://proxy.example.com:3128"},
+		{"http://WWW/", "DIRECT", ""},
+		{"http://www.example.org/", "HTTPS secure.example.com:443; DIRECT", "https://secure.example.com:443"},
+		{"http://secure.example.net/", "HTTPS secure.example.com:443; DIRECT", "https://secure.example.com:443"},
+		{"http://unknown.example.net/", "PROXY proxy.example.com:3128; DIRECT", "http://proxy.example.com:3128"},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		result, err := pac.FindProxy(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != test.result {
+			t.Errorf("result for %s should be %q is %q", test.url, test.result, result)
+		}
+		proxy, err := pac.ProxyURL(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if proxy != nil {
+			got = proxy.String()
+		}
+		if got != test.proxy {
+			t.Errorf("proxy for %s should be %q is %q", test.url, test.proxy, got)
+		}
+	}
+}
+
+func Test_PACURL(t *testing.T) {
+	pac, err := ParsePAC(`function FindProxyForURL(url, host) { return "PROXY " + host + ":1; " + url; }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("https://user:pw@example.com:8443/path?q=1#frag")
+	result, _ := pac.FindProxy(u)
+	if result != "PROXY example.com:1; https://example.com:8443/" {
+		t.Errorf("https url should be stripped, got %s", result)
+	}
+	u, _ = url.Parse("http://user:pw@example.com/path?q=1")
+	result, _ = pac.FindProxy(u)
+	if result != "PROXY example.com:1; http://example.com/path?q=1" {
+		t.Errorf("credentials should be stripped, got %s", result)
+	}
+}
+
+func Test_PACErrors(t *testing.T) {
+	sources := []string{
+		`function FindProxy(url, host) { return "DIRECT"; }`,
+		`function FindProxyForURL(url, host) { return "DIRECT"`,
+		`function FindProxyForURL(url, host) { return 'DIRECT; }`,
+		`function FindProxyForURL(url, host) { return url ? "a" : "b"; }`,
+	}
+	for _, src := range sources {
+		if _, err := ParsePAC(src); err == nil {
+			t.Errorf("%s should fail", src)
+		}
+	}
+
+	runtime := []string{
+		`function FindProxyForURL(url, host) { return alert(host); }`,
+		`function FindProxyForURL(url, host) { return missing; }`,
+		`function FindProxyForURL(url, host) { return FindProxyForURL(url, host); }`,
+		`function FindProxyForURL(url, host) { return true; }`,
+		`function FindProxyForURL(url, host) { return "FTP proxy:21"; }`,
+	}
+	u, _ := url.Parse("http://example.com/")
+	for _, src := range runtime {
+		pac, err := ParsePAC(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pac.ProxyURL(u); err == nil {
+			t.Errorf("%s should fail", src)
+		}
+	}
+}
+
+func Test_PACSession(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer proxy.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("direct " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	pac, err := ParsePAC(`
+function FindProxyForURL(url, host) {
+	if (host == "127.0.0.1") {
+		return "DIRECT";
+	}
+	return "PROXY ` + proxy.Listener.Addr().String() + `";
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSession().SetProxy(ProxyConfig{PAC: pac, HTTPProxy: "ignored:1"})
+	var body string
+	if _, err := s.Get("http://example.invalid/a").DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "proxied http://example.invalid/a" {
+		t.Errorf("response should be proxied http://example.invalid/a is %s", body)
+	}
+	if _, err := s.Get(backend.URL+"/b").DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "direct /b" {
+		t.Errorf("response should be direct /b is %s", body)
+	}
+}
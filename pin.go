@@ -0,0 +1,151 @@
+package httpcl
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+//PinOptions pin the public keys hosts are allowed to present. A pin is the
+//base64 encoded SHA-256 hash of a SubjectPublicKeyInfo like the pin-sha256
+//values of HPKP, the "sha256/" prefix is optional. A connection is accepted
+//if a key of the verified chain matches one of the pins of the host, so
+//backup pins for the next key are listed along with the current ones.
+type PinOptions struct {
+	//pins by host, *.example.com pins all subdomains of example.com.
+	//Hosts without pins aren't checked.
+	Pins map[string][]string
+	//mismatches are only reported and the connection is used
+	ReportOnly bool
+	//called for every mismatch, in report only mode it defaults to logging
+	//with the log package
+	Report func(err *PinError)
+}
+
+//PinError is returned if no key of the chain matches a pin of the host
+type PinError struct {
+	Host string
+	//the pins of the host
+	Pins []string
+	//the certificates presented by the server, leaf first
+	Chain []*x509.Certificate
+}
+
+func (e *PinError) Error() string {
+	presented := make([]string, len(e.Chain))
+	for i, cert := range e.Chain {
+		presented[i] = SPKIHash(cert)
+	}
+	return fmt.Sprintf("tls: no pinned public key for %s, presented %s", e.Host, strings.Join(presented, ", "))
+}
+
+//returns the pin of the certificate's public key
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+//pins the public keys of the hosts for all connections of the session,
+//they are checked after the chain is verified. An invalid pin is returned
+//as error of every request.
+func (s *Session) SetPins(o PinOptions) *Session {
+	pins, err := o.compile()
+	if err != nil {
+		return s.Use(errorMiddleware(err))
+	}
+	installed := s.pins != nil || (s.tlsState != nil && s.tlsState.manual)
+	s.pins = pins
+	if !installed {
+		cfg := &tls.Config{}
+		if s.transport.TLSClientConfig != nil {
+			cfg = s.transport.TLSClientConfig.Clone()
+		}
+		s.installTLS(cfg)
+	}
+	return s
+}
+
+type pinSet struct {
+	hosts      map[string][]string
+	reportOnly bool
+	report     func(err *PinError)
+}
+
+func (o PinOptions) compile() (*pinSet, error) {
+	p := &pinSet{hosts: map[string][]string{}, reportOnly: o.ReportOnly, report: o.Report}
+	if p.report == nil && p.reportOnly {
+		p.report = func(err *PinError) {
+			log.Printf("httpcl: %v", err)
+		}
+	}
+	for host, pins := range o.Pins {
+		host = strings.ToLower(host)
+		if len(pins) == 0 {
+			return nil, fmt.Errorf("tls: no pins for %s", host)
+		}
+		for _, pin := range pins {
+			pin = strings.TrimPrefix(pin, "sha256/")
+			if b, err := base64.StdEncoding.DecodeString(pin); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("tls: invalid pin %q for %s", pin, host)
+			}
+			p.hosts[host] = append(p.hosts[host], pin)
+		}
+	}
+	return p, nil
+}
+
+//returns the pins of the host, the most specific wildcard applies if the
+//host has none
+func (p *pinSet) lookup(host string) []string {
+	host = strings.ToLower(host)
+	if pins, ok := p.hosts[host]; ok {
+		return pins
+	}
+	for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+		host = host[i+1:]
+		if pins, ok := p.hosts["*."+host]; ok {
+			return pins
+		}
+	}
+	return nil
+}
+
+//checks the verified chains against the pins of the host. Without verified
+//chains only the leaf is checked, since the other certificates presented
+//are not authenticated. The TLS dialer of the session passes the dialed
+//host as server name, also for ip addresses and through a proxy.
+func (p *pinSet) check(cs tls.ConnectionState, chains [][]*x509.Certificate) error {
+	if cs.ServerName == "" {
+		return errors.New("tls: no server name to check the pins")
+	}
+	pins := p.lookup(cs.ServerName)
+	if pins == nil {
+		return nil
+	}
+	if len(chains) == 0 && len(cs.PeerCertificates) > 0 {
+		chains = [][]*x509.Certificate{cs.PeerCertificates[:1]}
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			hash := SPKIHash(cert)
+			for _, pin := range pins {
+				if hash == pin {
+					return nil
+				}
+			}
+		}
+	}
+	err := &PinError{Host: cs.ServerName, Pins: pins, Chain: cs.PeerCertificates}
+	if p.report != nil {
+		p.report(err)
+	}
+	if p.reportOnly {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package httpcl
+
+import (
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+//a TLS server presenting the chain
+func chainServer(t *testing.T, chain ...*testCert) *httptest.Server {
+	cert := tls.Certificate{PrivateKey: chain[0].key}
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.der)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func Test_Pins(t *testing.T) {
+	root := newTestCert(t, "root", nil, true)
+	intermediate := newTestCert(t, "intermediate", root, true)
+	leaf := newTestCert(t, "leaf", intermediate, false, "127.0.0.1", "api.internal")
+	other := newTestCert(t, "other", nil, true)
+	server := chainServer(t, leaf, intermediate)
+
+	tests := []struct {
+		name string
+		pins []string
+		ok   bool
+	}{
+		{"leaf", []string{SPKIHash(leaf.cert)}, true},
+		{"intermediate", []string{"sha256/" + SPKIHash(intermediate.cert)}, true},
+		{"root", []string{SPKIHash(root.cert)}, true},
+		{"backup", []string{SPKIHash(other.cert), SPKIHash(leaf.cert)}, true},
+		{"other", []string{SPKIHash(other.cert)}, false},
+	}
+	for _, test := range tests {
+		s := NewSession().
+			SetTLS(TLSOptions{CAPEM: root.certPEM()}).
+			SetPins(PinOptions{Pins: map[string][]string{"127.0.0.1": test.pins}})
+		resp, err := s.Get(server.URL).Do()
+		if test.ok {
+			if err != nil {
+				t.Errorf("%s pin should be accepted, got %v", test.name, err)
+				continue
+			}
+			resp.Body.Close()
+			continue
+		}
+		var perr *PinError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%s pin should fail with PinError, got %v", test.name, err)
+		}
+		if perr.Host != "127.0.0.1" || len(perr.Chain) != 2 || perr.Chain[1].Subject.CommonName != "intermediate" {
+			t.Errorf("pin error should contain host and presented chain, got %v %v", perr.Host, perr.Chain)
+		}
+	}
+}
+
+func Test_PinsReportOnly(t *testing.T) {
+	root := newTestCert(t, "root", nil, true)
+	leaf := newTestCert(t, "leaf", root, false, "api.internal")
+	other := newTestCert(t, "other", nil, true)
+	server := chainServer(t, leaf)
+
+	var reported []*PinError
+	s := NewSession().
+		SetPins(PinOptions{
+			Pins:       map[string][]string{"*.internal": {SPKIHash(other.cert)}},
+			ReportOnly: true,
+			Report: func(err *PinError) {
+				reported = append(reported, err)
+			},
+		}).
+		SetTLS(TLSOptions{
+			CAPEM:       root.certPEM(),
+			ServerNames: map[string]string{"127.0.0.1": "api.internal"},
+		})
+	resp, err := s.Get(server.URL).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(reported) != 1 || reported[0].Host != "api.internal" {
+		t.Errorf("mismatch of api.internal should be reported, got %v", reported)
+	}
+}
+
+func Test_PinsUnverifiedChain(t *testing.T) {
+	root := newTestCert(t, "root", nil, true)
+	leaf := newTestCert(t, "leaf", root, false, "127.0.0.1")
+	pinned := newTestCert(t, "pinned", nil, true)
+	//the pinned certificate is presented but isn't part of the verified chain
+	server := chainServer(t, leaf, pinned)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writeRotated(t, caFile, root.certPEM())
+	for _, o := range []TLSOptions{{CAPEM: root.certPEM()}, {CAFiles: []string{caFile}}} {
+		s := NewSession().
+			SetTLS(o).
+			SetPins(PinOptions{Pins: map[string][]string{"127.0.0.1": {SPKIHash(pinned.cert)}}})
+		var perr *PinError
+		if _, err := s.Get(server.URL).Do(); !errors.As(err, &perr) {
+			t.Errorf("pin outside the verified chain should fail, got %v", err)
+		}
+
+		s = NewSession().
+			SetTLS(o).
+			SetPins(PinOptions{Pins: map[string][]string{"127.0.0.1": {SPKIHash(root.cert)}}})
+		resp, err := s.Get(server.URL).Do()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if _, err := NewSession().SetPins(PinOptions{Pins: map[string][]string{"a": {"short"}}}).Get(server.URL).Do(); err == nil {
+		t.Error("invalid pin should fail")
+	}
+}
+
+func Test_PinsProxy(t *testing.T) {
+	root := newTestCert(t, "root", nil, true)
+	leaf := newTestCert(t, "leaf", root, false, "127.0.0.1")
+	other := newTestCert(t, "other", nil, true)
+	server := chainServer(t, leaf)
+	proxy := httptest.NewServer(proxyHandler(t))
+	defer proxy.Close()
+	proxyURL := "http://user:passwd@" + proxy.Listener.Addr().String()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writeRotated(t, caFile, root.certPEM())
+	for _, o := range []TLSOptions{{CAPEM: root.certPEM()}, {CAFiles: []string{caFile}}} {
+		//the ip address isn't sent as server name, the pins and the CA files
+		//are checked for the host of the url
+		tests := []struct {
+			name string
+			pins map[string][]string
+			ok   bool
+		}{
+			{"unrelated host", map[string][]string{"example.com": {SPKIHash(other.cert)}}, true},
+			{"pinned", map[string][]string{"127.0.0.1": {SPKIHash(root.cert)}}, true},
+			{"mismatch", map[string][]string{"127.0.0.1": {SPKIHash(other.cert)}}, false},
+		}
+		for _, test := range tests {
+			s := NewSession().
+				SetTLS(o).
+				SetPins(PinOptions{Pins: test.pins}).
+				SetProxyURL(proxyURL)
+			resp, err := s.Get(server.URL).Do()
+			if test.ok {
+				if err != nil {
+					t.Errorf("%s should be accepted through the proxy, got %v", test.name, err)
+					continue
+				}
+				resp.Body.Close()
+				continue
+			}
+			var perr *PinError
+			if !errors.As(err, &perr) || perr.Host != "127.0.0.1" {
+				t.Errorf("%s should fail with PinError for 127.0.0.1, got %v", test.name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package httpcl
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+//a forward proxy answering requests itself and tunnelling CONNECT to the
+//requested host
+func proxyHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:passwd"))
+		if r.Header.Get("Proxy-Authorization") != want {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method != http.MethodConnect {
+			w.Write([]byte("proxied " + r.URL.String()))
+			return
+		}
+		backend, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			backend.Close()
+			return
+		}
+		go func() {
+			io.Copy(backend, buf)
+			backend.Close()
+		}()
+		io.Copy(conn, backend)
+		conn.Close()
+	})
+}
+
+func Test_HTTPProxy(t *testing.T) {
+	proxy := httptest.NewServer(proxyHandler(t))
+	defer proxy.Close()
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tunnelled " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	u, _ := url.Parse(proxy.URL)
+	u.User = url.UserPassword("user", "passwd")
+	s := NewSession().SetProxyURL(u.String())
+	s.Transport().TLSClientConfig = backend.Client().Transport.(*http.Transport).TLSClientConfig
+
+	var body string
+	if _, err := s.Get("http://example.invalid/a?b=1").DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "proxied http://example.invalid/a?b=1" {
+		t.Errorf("response should be proxied http://example.invalid/a?b=1 is %s", body)
+	}
+	if _, err := s.Get(backend.URL+"/secure").DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "tunnelled /secure" {
+		t.Errorf("response should be tunnelled /secure is %s", body)
+	}
+
+	resp, err := NewSession().SetProxyURL(proxy.URL).Get("http://example.invalid/").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("status code should be 407 is %v", resp.StatusCode)
+	}
+}
+
+func Test_HTTPSProxy(t *testing.T) {
+	proxy := httptest.NewTLSServer(proxyHandler(t))
+	defer proxy.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(proxy.Certificate())
+
+	s := NewSession().SetProxy(ProxyConfig{
+		HTTPProxy: "https://user:passwd@" + proxy.Listener.Addr().String(),
+	})
+	s.Transport().TLSClientConfig = &tls.Config{RootCAs: pool}
+	var body string
+	if _, err := s.Get("http://example.invalid/tls").DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "proxied http://example.invalid/tls" {
+		t.Errorf("response should be proxied http://example.invalid/tls is %s", body)
+	}
+}
+
+//a SOCKS5 proxy with username/password auth that only knows the address of
+//backend.invalid at port, so the request only works if the proxy resolves
+//the host
+func socks5Server(t *testing.T, backend string, port uint16) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, backend, port)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func serveSOCKS5(conn net.Conn, backend string, backendPort uint16) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil
+		}
+		return b
+	}
+	//greeting, only username/password is accepted
+	head := read(2)
+	if head == nil || head[0] != 5 || read(int(head[1])) == nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+	//RFC 1929 auth
+	ver := read(2)
+	if ver == nil {
+		return
+	}
+	user := string(read(int(ver[1])))
+	plen := read(1)
+	if plen == nil {
+		return
+	}
+	passwd := string(read(int(plen[0])))
+	if user != "user" || passwd != "passwd" {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+	//CONNECT with a domain name
+	req := read(4)
+	if req == nil || req[1] != 1 || req[3] != 3 {
+		conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	host := string(read(int(read(1)[0])))
+	port := binary.BigEndian.Uint16(read(2))
+	if host != "backend.invalid" || port != backendPort {
+		conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	out, err := net.Dial("tcp", backend)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer out.Close()
+	conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	go io.Copy(out, r)
+	io.Copy(conn, out)
+}
+
+func Test_SOCKS5Proxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + r.URL.Path))
+	}))
+	defer backend.Close()
+	addr := socks5Server(t, backend.Listener.Addr().String(), 80)
+
+	var body string
+	s := NewSession().SetProxyURL("socks5://user:passwd@" + addr)
+	if _, err := s.Get("http://backend.invalid/socks").DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "backend.invalid/socks" {
+		t.Errorf("response should be backend.invalid/socks is %s", body)
+	}
+
+	s = NewSession().SetProxyURL("socks5h://user:wrong@" + addr)
+	if _, err := s.Get("http://backend.invalid/socks").Do(); err == nil {
+		t.Error("wrong socks credentials should fail")
+	}
+}
+
+func Test_ProxyConfig(t *testing.T) {
+	cfg := ProxyConfig{
+		HTTPProxy:  "proxy:3128",
+		HTTPSProxy: "https://secure-proxy:443",
+		NoProxy:    "localhost, .internal.example.com, 10.0.0.0/8, intranet:8080",
+		Rules: []ProxyRule{
+			{Hosts: "socks.example.com", Proxy: "socks5://socks:1080"},
+			{Hosts: "direct.example.com", Proxy: "DIRECT"},
+		},
+	}
+	proxy := cfg.proxyFunc()
+	tests := []struct {
+		url, proxy string
+	}{
+		{"http://example.com/", "http://proxy:3128"},
+		{"https://example.com/", "https://secure-proxy:443"},
+		{"http://localhost:9000/", ""},
+		{"http://a.internal.example.com/", ""},
+		{"http://internal.example.com/", "http://proxy:3128"},
+		{"http://10.1.2.3/", ""},
+		{"http://11.1.2.3/", "http://proxy:3128"},
+		{"http://intranet:8080/", ""},
+		{"http://intranet/", "http://proxy:3128"},
+		{"http://api.socks.example.com/", "socks5://socks:1080"},
+		{"https://direct.example.com/", ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		u, err := proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != test.proxy {
+			t.Errorf("proxy of %s should be %q is %q", test.url, test.proxy, got)
+		}
+	}
+
+	s := NewSession().SetProxy(ProxyConfig{HTTPProxy: "ftp://proxy"})
+	if _, err := s.Get("http://example.com/").Do(); err == nil {
+		t.Error("unsupported proxy scheme should fail")
+	}
+}
+
+func Test_HostMatcher(t *testing.T) {
+	tests := []struct {
+		list, url string
+		match     bool
+	}{
+		{"*", "http://anything/", true},
+		{"example.com", "http://example.com/", true},
+		{"example.com", "http://www.EXAMPLE.com/", true},
+		{"example.com", "http://badexample.com/", false},
+		{".example.com", "http://example.com/", false},
+		{"*.example.com", "http://www.example.com/", true},
+		{"example.com:443", "https://example.com/", true},
+		{"example.com:443", "http://example.com/", false},
+		{"192.168.0.0/16", "http://192.168.4.5:8080/", true},
+		{"192.168.0.0/16", "http://192.169.0.1/", false},
+		{"::1", "http://[::1]:8080/", true},
+		{"[::1]:8080", "http://[::1]:8080/", true},
+		{"fd00::/8", "http://[fd12::1]/", true},
+		{"127.0.0.1 localhost", "http://localhost/", true},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		if m := parseHostMatcher(test.list).match(u); m != test.match {
+			t.Errorf("%q matching %s should be %v is %v", test.list, test.url, test.match, m)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package httpcl
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+//sets how slices passed to AddQuery, SetQuery and QueryStruct are encoded,
+//call it before adding the parameters
+func (c *Client) QueryArrayStyle(style ArrayStyle) *Client {
+	c.arrayStyle = style
+	return c
+}
+
+//adds a query parameter, value can be of any type the form encoder supports
+func (c *Client) AddQuery(key string, value interface{}) *Client {
+	return c.runWithHasRequest(func() {
+		c.mergeQuery(key, value, false)
+	})
+}
+
+//sets a query parameter, replacing the parameters with the same key
+//that are already part of the url
+func (c *Client) SetQuery(key string, value interface{}) *Client {
+	return c.runWithHasRequest(func() {
+		c.mergeQuery(key, value, true)
+	})
+}
+
+//adds the fields of a struct or the entries of a map as query parameters,
+//encoded with the same form tags as post bodies
+func (c *Client) QueryStruct(v interface{}) *Client {
+	return c.runWithHasRequest(func() {
+		values, err := EncodeQuery(v, c.arrayStyle)
+		if err != nil {
+			c.Error = err
+			return
+		}
+		c.request.URL.RawQuery = mergeRawQuery(c.request.URL.RawQuery, values, nil)
+	})
+}
+
+func (c *Client) mergeQuery(key string, value interface{}, replace bool) {
+	values := url.Values{}
+	if err := encodeFormValue(values, key, reflect.ValueOf(value), formOptions{style: c.arrayStyle}); err != nil {
+		c.Error = err
+		return
+	}
+	var drop map[string]bool
+	if replace {
+		//the brackets style adds [] to the key, drop those as well
+		drop = map[string]bool{key: true, key + "[]": true}
+	}
+	c.request.URL.RawQuery = mergeRawQuery(c.request.URL.RawQuery, values, drop)
+}
+
+//appends the values to an encoded query, the existing parameters keep their
+//order and encoding. Existing parameters with a key in drop are removed.
+func mergeRawQuery(raw string, values url.Values, drop map[string]bool) string {
+	var parts []string
+	if raw != "" {
+		for _, part := range strings.Split(raw, "&") {
+			if len(drop) > 0 && part != "" {
+				key := part
+				if i := strings.IndexByte(key, '='); i >= 0 {
+					key = key[:i]
+				}
+				if k, err := url.QueryUnescape(key); err == nil {
+					key = k
+				}
+				if drop[key] {
+					continue
+				}
+			}
+			parts = append(parts, part)
+		}
+	}
+	if encoded := values.Encode(); encoded != "" {
+		parts = append(parts, encoded)
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,61 @@
+package httpcl
+
+import (
+	"testing"
+)
+
+type queryFilter struct {
+	State  string   `form:"state,omitempty"`
+	Labels []string `form:"labels"`
+	IDs    []int    `form:"ids,comma"`
+	Page   int      `form:"page,omitempty"`
+}
+
+func Test_AddQuery(t *testing.T) {
+	cl := Get("http://example.com/search?q=a%20b&lang=en").
+		AddQuery("q", "c&d").
+		AddQuery("n", []int{1, 2}).
+		SetQuery("lang", "de")
+	if cl.Error != nil {
+		t.Fatal(cl.Error.Error())
+	}
+	expected := "q=a%20b&q=c%26d&n=1&n=2&lang=de"
+	if cl.GetRequest().URL.RawQuery != expected {
+		t.Errorf("query should be %s is %s", expected, cl.GetRequest().URL.RawQuery)
+	}
+}
+
+func Test_QueryStruct(t *testing.T) {
+	f := queryFilter{Labels: []string{"bug", "ui"}, IDs: []int{3, 4}}
+
+	for style, expected := range map[ArrayStyle]string{
+		ArrayRepeat:   "ids=3%2C4&labels=bug&labels=ui",
+		ArrayComma:    "ids=3%2C4&labels=bug%2Cui",
+		ArrayBrackets: "ids=3%2C4&labels%5B%5D=bug&labels%5B%5D=ui",
+	} {
+		cl := Get("http://example.com/issues").QueryArrayStyle(style).QueryStruct(f)
+		if cl.Error != nil {
+			t.Fatal(cl.Error.Error())
+		}
+		if cl.GetRequest().URL.RawQuery != expected {
+			t.Errorf("query should be %s is %s", expected, cl.GetRequest().URL.RawQuery)
+		}
+	}
+}
+
+func Test_SetQueryBrackets(t *testing.T) {
+	cl := Get("http://example.com/?a[]=1&a[]=2&b=3").
+		QueryArrayStyle(ArrayBrackets).
+		SetQuery("a", []string{"x"})
+	expected := "b=3&a%5B%5D=x"
+	if cl.GetRequest().URL.RawQuery != expected {
+		t.Errorf("query should be %s is %s", expected, cl.GetRequest().URL.RawQuery)
+	}
+
+	if err := Get("http://example.com/").QueryStruct("nope").Error; err == nil {
+		t.Error("query struct with a string should fail")
+	}
+	if err := (&Client{}).AddQuery("a", 1).Error; err == nil {
+		t.Error("add query without request should fail")
+	}
+}
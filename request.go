@@ -0,0 +1,48 @@
+package httpcl
+
+import (
+	"fmt"
+	"strings"
+)
+
+//methods without a body whose redirects are followed like the ones of Get
+var safeMethods = map[string]bool{"GET": true, "HEAD": true, "OPTIONS": true, "DELETE": true}
+
+//creates a http client using any method with the given params, leading Vars
+//params expand url as uri template. The method must be a token (RFC 9110),
+//it is sent as given since methods are case sensitive.
+//
+//	httpcl.NewRequest("PROPFIND", url, body)
+func NewRequest(method, url string, params ...interface{}) *Client {
+	if err := validMethod(method); err != nil {
+		return &Client{Error: err}
+	}
+	c := getRequestWithBody(method, url, params)
+	c.redirect = safeMethods[method]
+	return c
+}
+
+//creates a http client using any method bound to the session
+func (s *Session) NewRequest(method, url string, params ...interface{}) *Client {
+	return s.bind(NewRequest(method, url, params...))
+}
+
+//returns an error if method is not a valid token
+func validMethod(method string) error {
+	if method == "" {
+		return fmt.Errorf("invalid method %q: empty", method)
+	}
+	if i := strings.IndexFunc(method, func(r rune) bool { return !isTokenChar(r) }); i >= 0 {
+		return fmt.Errorf("invalid method %q: character %q is not allowed", method, method[i])
+	}
+	return nil
+}
+
+//reports if r is a tchar of RFC 9110
+func isTokenChar(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
@@ -0,0 +1,55 @@
+package httpcl
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_NewRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + r.URL.Path + " " + string(body)))
+	}))
+	defer ts.Close()
+
+	var s string
+	if _, err := NewRequest("PURGE", ts.URL+"/items/{id}", Vars{"id": 7}, strings.NewReader("x")).DoTransform(TransformToString, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "PURGE /items/7 x" {
+		t.Errorf("response should be PURGE /items/7 x is %s", s)
+	}
+	if _, err := NewSession().NewRequest("search", ts.URL+"/q", "a", "b").DoTransform(TransformToString, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "search /q a=b" {
+		t.Errorf("method should be sent as given, got %s", s)
+	}
+
+	c := NewRequest("GET", ts.URL+"/old")
+	c.DoTransform(TransformToString, &s)
+	if s != "GET /new " {
+		t.Errorf("get should follow redirects, got %s", s)
+	}
+	c = NewRequest("POST", ts.URL+"/old")
+	c.Do()
+	if c.StatusCode != http.StatusFound {
+		t.Errorf("post should not follow redirects, got %v", c.StatusCode)
+	}
+
+	for _, method := range []string{"", "GET /", "B(AD)", "MÖVE"} {
+		if err := NewRequest(method, ts.URL).Error; err == nil || !strings.Contains(err.Error(), "invalid method") {
+			t.Errorf("method %q should be invalid, got %v", method, err)
+		}
+	}
+	if err := (ClientBuilder{Method: "NO METHOD", Url: ts.URL}).Build().Error; err == nil {
+		t.Error("builder should validate the method")
+	}
+}
@@ -0,0 +1,154 @@
+package httpcl
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//a Session shares a transport, cookies, default headers and middleware
+//between the requests created from it
+type Session struct {
+	Jar        http.CookieJar
+	Timeout    time.Duration
+	header     http.Header
+	middleware []Middleware
+	transport  *http.Transport
+	//the TLS options, pins and server names by host
+	tlsState    *tlsState
+	pins        *pinSet
+	serverNames map[string]string
+	//the socket of UnixSocket, the dialer of the transport it replaced and
+	//whether SetProxy was called
+	socket   string
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	proxySet bool
+	//the proxy func of the transport once the TLS dialer tunnels https
+	//requests itself
+	proxy  func(*http.Request) (*url.URL, error)
+	tunnel bool
+	//the destination policy, checked by every dialer of the session
+	guard *destinationGuard
+	//counts the changes of the transport, the http+unix transport is copied
+	//again after one
+	changes int
+}
+
+//creates a new session with its own connection pool
+func NewSession() *Session {
+	s := &Session{
+		header:    http.Header{},
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
+	}
+	s.transport.RegisterProtocol(unixScheme, &sessionUnixTransport{s: s})
+	return s
+}
+
+//records a change of the transport config
+func (s *Session) transportChanged() {
+	s.changes++
+}
+
+//installs the dialer of the session, which dials the unix socket of
+//UnixSocket or checks the destination policy before it dials with the
+//dialer the transport had before
+func (s *Session) useDialer() {
+	if s.dial != nil {
+		return
+	}
+	s.dial = s.baseDial()
+	s.transport.DialContext = s.dialContext
+}
+
+//returns the dialer of the transport without the session dialer
+func (s *Session) baseDial() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if s.dial != nil {
+		return s.dial
+	}
+	if s.transport.DialContext != nil {
+		return s.transport.DialContext
+	}
+	var d net.Dialer
+	return d.DialContext
+}
+
+func (s *Session) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if s.socket != "" {
+		return s.dial(ctx, "unix", s.socket)
+	}
+	if s.guard != nil {
+		return s.guard.dial(ctx, s.dial, network, addr)
+	}
+	return s.dial(ctx, network, addr)
+}
+
+//dials the socket of a http+unix url, which the destination policy has to
+//allow as scheme
+func (s *Session) dialSocket(ctx context.Context, network, path string) (net.Conn, error) {
+	if s.guard != nil && !contains(s.guard.schemes, unixScheme, true) {
+		return nil, &ErrDestinationBlocked{URL: unixScheme + "://" + url.PathEscape(path), Host: path, Reason: "scheme"}
+	}
+	return s.baseDial()(ctx, network, path)
+}
+
+//returns the transport shared by all requests of the session
+func (s *Session) Transport() *http.Transport {
+	return s.transport
+}
+
+//adds a header that is sent with every request of the session
+func (s *Session) AddHeader(key, value string) *Session {
+	s.header.Add(key, value)
+	return s
+}
+
+//adds middleware that is applied to every request of the session
+func (s *Session) Use(mw ...Middleware) *Session {
+	s.middleware = append(s.middleware, mw...)
+	return s
+}
+
+//binds a client to the session
+func (s *Session) bind(c *Client) *Client {
+	c.session = s
+	if c.request != nil {
+		for key, values := range s.header {
+			for _, value := range values {
+				c.request.Header.Add(key, value)
+			}
+		}
+	}
+	return c
+}
+
+//creates a http client using GET bound to the session
+func (s *Session) Get(url string, vars ...Vars) *Client {
+	return s.bind(Get(url, vars...))
+}
+
+//creates a http client using HEAD bound to the session
+func (s *Session) Head(url string, vars ...Vars) *Client {
+	return s.bind(Head(url, vars...))
+}
+
+//creates a http client using DELETE bound to the session
+func (s *Session) Delete(url string, vars ...Vars) *Client {
+	return s.bind(Delete(url, vars...))
+}
+
+//creates a http client using PATCH bound to the session
+func (s *Session) Patch(purl string, params ...interface{}) *Client {
+	return s.bind(Patch(purl, params...))
+}
+
+//creates a http client using POST bound to the session
+func (s *Session) Post(purl string, params ...interface{}) *Client {
+	return s.bind(Post(purl, params...))
+}
+
+//creates a http client using PUT bound to the session
+func (s *Session) Put(purl string, params ...interface{}) *Client {
+	return s.bind(Put(purl, params...))
+}
@@ -0,0 +1,507 @@
+package httpcl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm        = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat       = "20060102T150405Z"
+	sigV4DateFormat       = "20060102"
+	UnsignedPayload       = "UNSIGNED-PAYLOAD"
+	StreamingPayload      = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	emptyPayloadHash      = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	sigV4ChunkSignatureLn = 64
+)
+
+//headers that are never signed because proxies or the transport may change them
+var sigV4IgnoredHeaders = map[string]bool{
+	"authorization":     true,
+	"user-agent":        true,
+	"x-amzn-trace-id":   true,
+	"expect":            true,
+	"connection":        true,
+	"transfer-encoding": true,
+}
+
+//AWSCredentials are the keys used for signature version 4
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+//an AWSCredentialsProvider is asked for credentials every time a request is signed
+type AWSCredentialsProvider interface {
+	Retrieve() (AWSCredentials, error)
+}
+
+//returns itself, static credentials are a provider too
+func (c AWSCredentials) Retrieve() (AWSCredentials, error) {
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return c, errors.New("aws credentials incomplete")
+	}
+	return c, nil
+}
+
+//reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+type AWSEnvProvider struct{}
+
+func (AWSEnvProvider) Retrieve() (AWSCredentials, error) {
+	c := AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if c.AccessKeyID == "" {
+		c.AccessKeyID = os.Getenv("AWS_ACCESS_KEY")
+	}
+	if c.SecretAccessKey == "" {
+		c.SecretAccessKey = os.Getenv("AWS_SECRET_KEY")
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return c, errors.New("aws credentials not found in environment")
+	}
+	return c, nil
+}
+
+//reads a profile of a shared credentials file. An empty Filename means
+//AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials, an empty Profile means
+//AWS_PROFILE or "default".
+type AWSFileProvider struct {
+	Filename string
+	Profile  string
+}
+
+func (p AWSFileProvider) Retrieve() (AWSCredentials, error) {
+	filename := p.Filename
+	if filename == "" {
+		filename = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if filename == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return AWSCredentials{}, err
+		}
+		filename = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := p.Profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	defer f.Close()
+
+	var c AWSCredentials
+	found := false
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(strings.TrimPrefix(line[1:len(line)-1], "profile "))
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		found = true
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		switch strings.ToLower(strings.TrimSpace(line[:i])) {
+		case "aws_access_key_id":
+			c.AccessKeyID = value
+		case "aws_secret_access_key":
+			c.SecretAccessKey = value
+		case "aws_session_token":
+			c.SessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return c, err
+	}
+	if !found {
+		return c, fmt.Errorf("aws profile %s not found in %s", profile, filename)
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return c, fmt.Errorf("aws profile %s in %s is incomplete", profile, filename)
+	}
+	return c, nil
+}
+
+//tries each provider in order and returns the first credentials found
+type AWSCredentialsChain []AWSCredentialsProvider
+
+func (chain AWSCredentialsChain) Retrieve() (AWSCredentials, error) {
+	var errs []string
+	for _, p := range chain {
+		c, err := p.Retrieve()
+		if err == nil {
+			return c, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return AWSCredentials{}, fmt.Errorf("no aws credentials: %s", strings.Join(errs, "; "))
+}
+
+//SigV4Signer signs requests with AWS signature version 4
+type SigV4Signer struct {
+	Credentials AWSCredentialsProvider
+	Region      string
+	Service     string
+
+	//sends UNSIGNED-PAYLOAD instead of hashing the body
+	UnsignedPayload bool
+	//if > 0 the body is sent aws-chunked with a signature per chunk of that size,
+	//the request needs a known content length
+	StreamingChunkSize int
+	//S3 does not double escape the path, other services do. Defaults to true for s3.
+	DisableDoubleEscape bool
+
+	//returns the signing time, defaults to time.Now
+	Now func() time.Time
+}
+
+//creates a signer using the environment and the shared credentials file
+func NewSigV4Signer(region, service string) *SigV4Signer {
+	return &SigV4Signer{
+		Credentials: AWSCredentialsChain{AWSEnvProvider{}, AWSFileProvider{}},
+		Region:      region,
+		Service:     service,
+	}
+}
+
+//Wrap is the Middleware of the signer
+func (s *SigV4Signer) Wrap(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		r := req.Clone(req.Context())
+		if err := s.Sign(r); err != nil {
+			return nil, err
+		}
+		return next.RoundTrip(r)
+	})
+}
+
+//signs the request in place by setting the Authorization header
+func (s *SigV4Signer) Sign(req *http.Request) error {
+	creds, err := s.Credentials.Retrieve()
+	if err != nil {
+		return err
+	}
+	now := s.now()
+
+	var payloadHash string
+	var chunked *sigV4ChunkReader
+	switch {
+	case s.UnsignedPayload:
+		payloadHash = UnsignedPayload
+	case s.StreamingChunkSize > 0 && req.Body != nil && req.Body != http.NoBody:
+		if req.ContentLength < 0 {
+			return errors.New("streaming signature needs the content length of the body")
+		}
+		payloadHash = StreamingPayload
+		chunked = &sigV4ChunkReader{body: req.Body, size: s.StreamingChunkSize}
+		req.Header.Set("Content-Encoding", appendToken(req.Header.Get("Content-Encoding"), "aws-chunked"))
+		req.Header.Set("X-Amz-Decoded-Content-Length", strconv.FormatInt(req.ContentLength, 10))
+		req.ContentLength = sigV4ChunkedLength(req.ContentLength, int64(s.StreamingChunkSize))
+		req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	default:
+		body, err := rewindableBody(req)
+		if err != nil {
+			return err
+		}
+		payloadHash = hashHex(body)
+		if body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			req.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			}
+		}
+	}
+
+	req.Header.Set("X-Amz-Date", now.Format(sigV4TimeFormat))
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	if s.Service == "s3" || payloadHash == UnsignedPayload || payloadHash == StreamingPayload {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	signedHeaders, canonicalHeaders := s.canonicalHeaders(req)
+	canonical := strings.Join([]string{
+		req.Method,
+		s.canonicalURI(req.URL),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := s.scope(now)
+	key := s.signingKey(creds.SecretAccessKey, now)
+	signature := hex.EncodeToString(hmacSHA256(key, s.stringToSign(now, scope, canonical)))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+
+	if chunked != nil {
+		chunked.key = key
+		chunked.scope = scope
+		chunked.time = now.Format(sigV4TimeFormat)
+		chunked.prev = signature
+		req.Body = chunked
+		req.GetBody = nil
+	}
+	return nil
+}
+
+//returns a presigned url for the request that is valid for the given duration
+func (s *SigV4Signer) Presign(req *http.Request, expires time.Duration) (*url.URL, error) {
+	creds, err := s.Credentials.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	scope := s.scope(now)
+
+	u := *req.URL
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", creds.AccessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", now.Format(sigV4TimeFormat))
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	if creds.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	r := req.Clone(req.Context())
+	r.URL = &u
+	signedHeaders, canonicalHeaders := s.canonicalHeaders(r)
+	query.Set("X-Amz-SignedHeaders", signedHeaders)
+
+	canonical := strings.Join([]string{
+		r.Method,
+		s.canonicalURI(&u),
+		canonicalQuery(query),
+		canonicalHeaders,
+		signedHeaders,
+		UnsignedPayload,
+	}, "\n")
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(creds.SecretAccessKey, now), s.stringToSign(now, scope, canonical)))
+	query.Set("X-Amz-Signature", signature)
+	u.RawQuery = canonicalQuery(query)
+	return &u, nil
+}
+
+func (s *SigV4Signer) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (s *SigV4Signer) scope(t time.Time) string {
+	return strings.Join([]string{t.Format(sigV4DateFormat), s.Region, s.Service, "aws4_request"}, "/")
+}
+
+func (s *SigV4Signer) stringToSign(t time.Time, scope, canonical string) string {
+	return strings.Join([]string{sigV4Algorithm, t.Format(sigV4TimeFormat), scope, hashHex([]byte(canonical))}, "\n")
+}
+
+func (s *SigV4Signer) signingKey(secret string, t time.Time) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), t.Format(sigV4DateFormat))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func (s *SigV4Signer) canonicalURI(u *url.URL) string {
+	path := u.Path
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		seg = awsEscape(seg)
+		if !s.DisableDoubleEscape && s.Service != "s3" {
+			seg = awsEscape(seg)
+		}
+		segments[i] = seg
+	}
+	return strings.Join(segments, "/")
+}
+
+//returns the signed header list and the canonical header block
+func (s *SigV4Signer) canonicalHeaders(req *http.Request) (string, string) {
+	headers := map[string][]string{}
+	for key, values := range req.Header {
+		key = strings.ToLower(key)
+		if sigV4IgnoredHeaders[key] {
+			continue
+		}
+		headers[key] = append(headers[key], values...)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers["host"] = []string{host}
+
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		values := make([]string, len(headers[key]))
+		for i, v := range headers[key] {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		b.WriteString(key + ":" + strings.Join(values, ",") + "\n")
+	}
+	return strings.Join(keys, ";"), b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(key)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+//percent encodes everything except the unreserved characters of RFC 3986
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func appendToken(list, token string) string {
+	if list == "" {
+		return token
+	}
+	return list + "," + token
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+//returns the length of a body of n bytes encoded as signed aws-chunked
+func sigV4ChunkedLength(n, size int64) int64 {
+	chunk := func(l int64) int64 {
+		return int64(len(strconv.FormatInt(l, 16))) + int64(len(";chunk-signature=")) + sigV4ChunkSignatureLn + 2 + l + 2
+	}
+	total := (n / size) * chunk(size)
+	if rest := n % size; rest > 0 {
+		total += chunk(rest)
+	}
+	return total + chunk(0)
+}
+
+//encodes the body as aws-chunked while it is read, each chunk signature
+//chains the signature of the previous chunk
+type sigV4ChunkReader struct {
+	body  io.ReadCloser
+	size  int
+	key   []byte
+	scope string
+	time  string
+	prev  string
+	buf   bytes.Buffer
+	done  bool
+}
+
+func (r *sigV4ChunkReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		chunk := make([]byte, r.size)
+		n, err := io.ReadFull(r.body, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		r.writeChunk(chunk[:n])
+		if n == 0 {
+			r.done = true
+		} else if err != nil {
+			r.writeChunk(nil)
+			r.done = true
+		}
+	}
+	return r.buf.Read(p)
+}
+
+func (r *sigV4ChunkReader) writeChunk(data []byte) {
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256-PAYLOAD", r.time, r.scope, r.prev, emptyPayloadHash, hashHex(data),
+	}, "\n")
+	r.prev = hex.EncodeToString(hmacSHA256(r.key, toSign))
+	fmt.Fprintf(&r.buf, "%x;chunk-signature=%s\r\n", len(data), r.prev)
+	r.buf.Write(data)
+	r.buf.WriteString("\r\n")
+}
+
+func (r *sigV4ChunkReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,327 @@
+package httpcl
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//SOAPVersion selects the envelope namespace, content type and fault format
+type SOAPVersion int
+
+const (
+	SOAP11 SOAPVersion = iota
+	SOAP12
+)
+
+const (
+	soap11Namespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	soap12Namespace = "http://www.w3.org/2003/05/soap-envelope"
+	wsseNamespace   = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"
+	wsuNamespace    = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+	wssePrefix      = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-"
+)
+
+func (v SOAPVersion) namespace() string {
+	if v == SOAP12 {
+		return soap12Namespace
+	}
+	return soap11Namespace
+}
+
+//SOAP calls operations of a SOAP endpoint
+type SOAP struct {
+	URL     string
+	Version SOAPVersion
+	//requests are created from the session if set
+	Session *Session
+	//http headers added to every request
+	Header http.Header
+	//adds a WS-Security header with a UsernameToken if set
+	Security *UsernameToken
+	//additional elements of the soap header, marshalled with encoding/xml
+	Headers []interface{}
+}
+
+//UsernameToken is the WS-Security UsernameToken profile, the password is
+//sent as digest of a nonce, the creation time and the password if Digest
+//is set and as text otherwise
+type UsernameToken struct {
+	Username string
+	Password string
+	Digest   bool
+	//returns the creation time, defaults to time.Now
+	Now func() time.Time
+}
+
+//a SOAPFault is the fault of a SOAP response, for SOAP 1.1 Actor holds the
+//faultactor and for SOAP 1.2 the role
+type SOAPFault struct {
+	Code    string
+	Subcode string
+	Reason  string
+	Actor   string
+	//the inner xml of the detail element
+	Detail string
+}
+
+func (f *SOAPFault) Error() string {
+	code := f.Code
+	if f.Subcode != "" {
+		code += "/" + f.Subcode
+	}
+	return fmt.Sprintf("soap fault %s: %s", code, f.Reason)
+}
+
+//creates a SOAP client for the endpoint
+func NewSOAP(url string, version SOAPVersion) *SOAP {
+	return &SOAP{URL: url, Version: version}
+}
+
+//creates a SOAP client for the endpoint sending through the session
+func (s *Session) SOAP(url string, version SOAPVersion) *SOAP {
+	return &SOAP{URL: url, Version: version, Session: s}
+}
+
+//Envelope wraps body, marshalled with encoding/xml, in a soap envelope
+//with the security token and the additional headers
+func (s *SOAP) Envelope(body interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	fmt.Fprintf(&buf, `<soap:Envelope xmlns:soap="%s">`, s.Version.namespace())
+	if s.Security != nil || len(s.Headers) > 0 {
+		buf.WriteString("<soap:Header>")
+		if s.Security != nil {
+			if err := s.Security.write(&buf); err != nil {
+				return nil, err
+			}
+		}
+		for _, h := range s.Headers {
+			b, err := xml.Marshal(h)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(b)
+		}
+		buf.WriteString("</soap:Header>")
+	}
+	buf.WriteString("<soap:Body>")
+	if body != nil {
+		b, err := xml.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	buf.WriteString("</soap:Body></soap:Envelope>")
+	return buf.Bytes(), nil
+}
+
+//writes the wsse:Security header
+func (t *UsernameToken) write(w *bytes.Buffer) error {
+	now := time.Now
+	if t.Now != nil {
+		now = t.Now
+	}
+	fmt.Fprintf(w, `<wsse:Security xmlns:wsse="%s" xmlns:wsu="%s" soap:mustUnderstand="1">`, wsseNamespace, wsuNamespace)
+	w.WriteString("<wsse:UsernameToken><wsse:Username>")
+	xml.EscapeText(w, []byte(t.Username))
+	w.WriteString("</wsse:Username>")
+	if !t.Digest {
+		fmt.Fprintf(w, `<wsse:Password Type="%susername-token-profile-1.0#PasswordText">`, wssePrefix)
+		xml.EscapeText(w, []byte(t.Password))
+		w.WriteString("</wsse:Password>")
+	} else {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		created := now().UTC().Format("2006-01-02T15:04:05.000Z")
+		h := sha1.New()
+		h.Write(nonce)
+		h.Write([]byte(created))
+		h.Write([]byte(t.Password))
+		fmt.Fprintf(w, `<wsse:Password Type="%susername-token-profile-1.0#PasswordDigest">%s</wsse:Password>`, wssePrefix, base64.StdEncoding.EncodeToString(h.Sum(nil)))
+		fmt.Fprintf(w, `<wsse:Nonce EncodingType="%ssoap-message-security-1.0#Base64Binary">%s</wsse:Nonce>`, wssePrefix, base64.StdEncoding.EncodeToString(nonce))
+		fmt.Fprintf(w, `<wsu:Created>%s</wsu:Created>`, created)
+	}
+	w.WriteString("</wsse:UsernameToken></wsse:Security>")
+	return nil
+}
+
+//Call sends body in an envelope with the soap action and decodes the body
+//of the response into out. A fault is returned as *SOAPFault.
+func (s *SOAP) Call(ctx context.Context, action string, body interface{}, out interface{}) error {
+	envelope, err := s.Envelope(body)
+	if err != nil {
+		return err
+	}
+	var c *Client
+	if s.Session != nil {
+		c = s.Session.Post(s.URL, bytes.NewReader(envelope))
+	} else {
+		c = Post(s.URL, bytes.NewReader(envelope))
+	}
+	if s.Version == SOAP12 {
+		contentType := "application/soap+xml; charset=utf-8"
+		if action != "" {
+			contentType += fmt.Sprintf(`; action="%s"`, action)
+		}
+		c.SetContentType(contentType)
+	} else {
+		c.SetContentType("text/xml; charset=utf-8").AddHeader("SOAPAction", `"`+action+`"`)
+	}
+	for key, values := range s.Header {
+		for _, value := range values {
+			c.AddHeader(key, value)
+		}
+	}
+	if c.Error == nil && ctx != nil {
+		c.SetRequest(c.GetRequest().WithContext(ctx))
+	}
+
+	resp, err := c.Do()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = s.decodeResponse(xml.NewDecoder(resp.Body), out)
+	if err == errNoEnvelope && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("soap: unexpected status %s", resp.Status)
+	}
+	return err
+}
+
+var errNoEnvelope = errors.New("soap: response is no soap envelope")
+
+type soap11Fault struct {
+	Code   string `xml:"faultcode"`
+	String string `xml:"faultstring"`
+	Actor  string `xml:"faultactor"`
+	Detail struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"detail"`
+}
+
+type soap12Fault struct {
+	Code struct {
+		Value   string `xml:"Value"`
+		Subcode struct {
+			Value string `xml:"Value"`
+		} `xml:"Subcode"`
+	} `xml:"Code"`
+	Reason struct {
+		Text []string `xml:"Text"`
+	} `xml:"Reason"`
+	Role   string `xml:"Role"`
+	Detail struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"Detail"`
+}
+
+//decodes the first element of the body into out or returns its fault. The
+//same decoder walks the whole envelope, so prefixes declared on the
+//envelope stay known inside the body.
+func (s *SOAP) decodeResponse(dec *xml.Decoder, out interface{}) error {
+	env, err := nextStart(dec)
+	if err != nil || env.Name.Local != "Envelope" {
+		return errNoEnvelope
+	}
+	if env.Name.Space != s.Version.namespace() {
+		return fmt.Errorf("soap: unexpected envelope namespace %q", env.Name.Space)
+	}
+
+	for {
+		start, err := nextStart(dec)
+		if err == errEndElement {
+			return errors.New("soap: envelope without body")
+		}
+		if err != nil {
+			return err
+		}
+		if start.Name.Local == "Body" {
+			break
+		}
+		if err := dec.Skip(); err != nil {
+			return err
+		}
+	}
+
+	start, err := nextStart(dec)
+	if err == errEndElement {
+		//an empty body
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if start.Name.Local == "Fault" {
+		return s.decodeFault(dec, start)
+	}
+	if out == nil {
+		return nil
+	}
+	return dec.DecodeElement(out, &start)
+}
+
+var errEndElement = errors.New("soap: unexpected end element")
+
+//returns the next start element on the current level, or errEndElement if
+//the current element ends first
+func nextStart(dec *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return t, nil
+		case xml.EndElement:
+			return xml.StartElement{}, errEndElement
+		}
+	}
+}
+
+func (s *SOAP) decodeFault(dec *xml.Decoder, start xml.StartElement) error {
+	if s.Version == SOAP12 {
+		var f soap12Fault
+		if err := dec.DecodeElement(&f, &start); err != nil {
+			return err
+		}
+		return &SOAPFault{
+			Code:    localName(f.Code.Value),
+			Subcode: localName(f.Code.Subcode.Value),
+			Reason:  strings.Join(f.Reason.Text, "; "),
+			Actor:   f.Role,
+			Detail:  strings.TrimSpace(f.Detail.Inner),
+		}
+	}
+	var f soap11Fault
+	if err := dec.DecodeElement(&f, &start); err != nil {
+		return err
+	}
+	return &SOAPFault{
+		Code:   localName(f.Code),
+		Reason: f.String,
+		Actor:  f.Actor,
+		Detail: strings.TrimSpace(f.Detail.Inner),
+	}
+}
+
+//strips the namespace prefix of a qualified name like soap:Server
+func localName(qname string) string {
+	qname = strings.TrimSpace(qname)
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		return qname[i+1:]
+	}
+	return qname
+}
@@ -0,0 +1,221 @@
+package httpcl
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+type addRequest struct {
+	XMLName xml.Name `xml:"http://example.com/calc Add"`
+	A       int      `xml:"a"`
+	B       int      `xml:"b"`
+}
+
+type addResponse struct {
+	Result int `xml:"result"`
+}
+
+//answers Add with the sum and everything else with a fault of the version
+func soapServer(version SOAPVersion, requests *[]*http.Request, bodies *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		*requests = append(*requests, r)
+		*bodies = append(*bodies, string(body))
+		var env struct {
+			Body struct {
+				Add *addRequest `xml:"http://example.com/calc Add"`
+			} `xml:"Body"`
+		}
+		xml.Unmarshal(body, &env)
+		ns := version.namespace()
+		if env.Body.Add != nil {
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="%s"><s:Body><c:AddResponse xmlns:c="http://example.com/calc"><c:result>%d</c:result></c:AddResponse></s:Body></s:Envelope>`, ns, env.Body.Add.A+env.Body.Add.B)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		if version == SOAP12 {
+			fmt.Fprintf(w, `<s:Envelope xmlns:s="%s"><s:Body><s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value>c:Unknown</s:Value></s:Subcode></s:Code><s:Reason><s:Text xml:lang="en">unknown operation</s:Text></s:Reason><s:Detail><c:info xmlns:c="http://example.com/calc">x</c:info></s:Detail></s:Fault></s:Body></s:Envelope>`, ns)
+		} else {
+			fmt.Fprintf(w, `<s:Envelope xmlns:s="%s"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>unknown operation</faultstring><faultactor>calc</faultactor><detail><info>x</info></detail></s:Fault></s:Body></s:Envelope>`, ns)
+		}
+	}))
+}
+
+func Test_SOAP11(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	ts := soapServer(SOAP11, &requests, &bodies)
+	defer ts.Close()
+
+	s := NewSOAP(ts.URL, SOAP11)
+	s.Header = http.Header{"X-Test": {"1"}}
+	var out addResponse
+	if err := s.Call(nil, "http://example.com/calc/Add", addRequest{A: 2, B: 3}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Result != 5 {
+		t.Errorf("result should be 5 is %v", out.Result)
+	}
+	r := requests[0]
+	if ct := r.Header.Get("Content-Type"); ct != "text/xml; charset=utf-8" {
+		t.Errorf("content type should be text/xml is %s", ct)
+	}
+	if action := r.Header.Get("SOAPAction"); action != `"http://example.com/calc/Add"` {
+		t.Errorf("soap action should be the quoted action is %s", action)
+	}
+	if r.Header.Get("X-Test") != "1" {
+		t.Error("header should be sent")
+	}
+	if !strings.Contains(bodies[0], `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">`) || strings.Contains(bodies[0], "soap:Header") {
+		t.Errorf("envelope should be soap 1.1 without header is %s", bodies[0])
+	}
+
+	err := s.Call(nil, "Sub", struct {
+		XMLName xml.Name `xml:"Sub"`
+	}{}, &out)
+	fault, ok := err.(*SOAPFault)
+	if !ok {
+		t.Fatalf("error should be a fault is %v", err)
+	}
+	if fault.Code != "Client" || fault.Reason != "unknown operation" || fault.Actor != "calc" || fault.Detail != "<info>x</info>" {
+		t.Errorf("fault should be decoded is %+v", fault)
+	}
+}
+
+func Test_SOAP12(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	ts := soapServer(SOAP12, &requests, &bodies)
+	defer ts.Close()
+
+	s := NewSession().SOAP(ts.URL, SOAP12)
+	var out addResponse
+	if err := s.Call(nil, "urn:Add", &addRequest{A: 20, B: 22}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Result != 42 {
+		t.Errorf("result should be 42 is %v", out.Result)
+	}
+	if ct := requests[0].Header.Get("Content-Type"); ct != `application/soap+xml; charset=utf-8; action="urn:Add"` {
+		t.Errorf("content type should carry the action is %s", ct)
+	}
+	if requests[0].Header.Get("SOAPAction") != "" {
+		t.Error("soap 1.2 should not send a SOAPAction header")
+	}
+
+	err := s.Call(nil, "urn:Sub", struct {
+		XMLName xml.Name `xml:"Sub"`
+	}{}, nil)
+	fault, ok := err.(*SOAPFault)
+	if !ok {
+		t.Fatalf("error should be a fault is %v", err)
+	}
+	if fault.Code != "Sender" || fault.Subcode != "Unknown" || fault.Reason != "unknown operation" {
+		t.Errorf("fault should be decoded is %+v", fault)
+	}
+	if fault.Error() != "soap fault Sender/Unknown: unknown operation" {
+		t.Errorf("error message is %s", fault.Error())
+	}
+
+	//a 1.1 envelope is not accepted by a 1.2 client
+	s.Version = SOAP11
+	if err := s.Call(nil, "urn:Add", &addRequest{}, &out); err == nil || !strings.Contains(err.Error(), "namespace") {
+		t.Errorf("error should be about the namespace is %v", err)
+	}
+}
+
+func Test_SOAPEnvelopePrefix(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:m="urn:example">
+  <soap:Header><m:Trans soap:mustUnderstand="1">234</m:Trans></soap:Header>
+  <soap:Body>
+    <m:GetPriceResponse><m:Price>1.90</m:Price></m:GetPriceResponse>
+  </soap:Body>
+</soap:Envelope>`))
+	}))
+	defer ts.Close()
+
+	var out struct {
+		XMLName xml.Name `xml:"urn:example GetPriceResponse"`
+		Price   string   `xml:"urn:example Price"`
+	}
+	if err := NewSOAP(ts.URL, SOAP11).Call(nil, "urn:GetPrice", struct {
+		XMLName xml.Name `xml:"urn:example GetPrice"`
+	}{}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Price != "1.90" {
+		t.Errorf("price should be 1.90 is %v", out.Price)
+	}
+}
+
+func Test_SOAPLargeResponse(t *testing.T) {
+	if testing.Short() {
+		t.Skip("large response")
+	}
+	const size = 65 << 20
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<s:Envelope xmlns:s="%s"><s:Body><c:AddResponse xmlns:c="http://example.com/calc"><c:text>`, SOAP11.namespace())
+		chunk := bytes.Repeat([]byte("a"), 1<<20)
+		for i := 0; i < size>>20; i++ {
+			w.Write(chunk)
+		}
+		w.Write([]byte(`</c:text></c:AddResponse></s:Body></s:Envelope>`))
+	}))
+	defer ts.Close()
+
+	var out struct {
+		Text string `xml:"text"`
+	}
+	if err := NewSOAP(ts.URL, SOAP11).Call(nil, "urn:Add", &addRequest{}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Text) != size {
+		t.Errorf("text length should be %v is %v", size, len(out.Text))
+	}
+}
+
+func Test_SOAPSecurity(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := NewSOAP("http://localhost", SOAP11)
+	s.Security = &UsernameToken{Username: "user", Password: "p<w>", Now: func() time.Time { return created }}
+	env, err := s.Envelope(addRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(env), `#PasswordText">p&lt;w&gt;</wsse:Password>`) {
+		t.Errorf("envelope should contain the escaped password is %s", env)
+	}
+
+	s.Security.Digest = true
+	env, _ = s.Envelope(addRequest{})
+	m := regexp.MustCompile(`#PasswordDigest">([^<]+)</wsse:Password><wsse:Nonce [^>]+>([^<]+)</wsse:Nonce><wsu:Created>([^<]+)</wsu:Created>`).FindStringSubmatch(string(env))
+	if m == nil {
+		t.Fatalf("envelope should contain digest, nonce and created is %s", env)
+	}
+	if m[3] != "2024-01-02T03:04:05.000Z" {
+		t.Errorf("created should be the time of Now is %s", m[3])
+	}
+	nonce, _ := base64.StdEncoding.DecodeString(m[2])
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(m[3]))
+	h.Write([]byte("p<w>"))
+	if digest := base64.StdEncoding.EncodeToString(h.Sum(nil)); digest != m[1] {
+		t.Errorf("digest should be %s is %s", digest, m[1])
+	}
+}
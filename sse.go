@@ -0,0 +1,290 @@
+package httpcl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"iter"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//an Event is a message of a text/event-stream
+type Event struct {
+	//the last event id seen on the stream
+	ID string
+	//the event field, message if the server didn't send one
+	Type string
+	Data string
+}
+
+//an EventSource reads Server-Sent Events from the request of a client and
+//reconnects when the stream ends, sending the last event id again
+type EventSource struct {
+	//the delay before reconnecting until the server sends a retry field,
+	//defaults to 3 seconds
+	Retry time.Duration
+	//consecutive failed connection attempts before giving up, 0 retries forever
+	MaxRetries int
+	//sent as Last-Event-ID header of the first connection to resume a stream
+	LastEventID string
+
+	c   *Client
+	mu  sync.Mutex
+	err error
+	//the state of the stream, initialized from the fields above when the
+	//first connection is opened
+	started bool
+	lastID  string
+	retry   time.Duration
+}
+
+//creates an event source for the request of the client
+func NewEventSource(c *Client) *EventSource {
+	return &EventSource{Retry: 3 * time.Second, c: c}
+}
+
+//creates an event source for the request
+func (c *Client) EventSource() *EventSource {
+	return NewEventSource(c)
+}
+
+//Events connects to the stream and returns its events. Connections that fail
+//or end are opened again after the retry delay, a status other than 200, a
+//content type other than text/event-stream or too many failed attempts end
+//the iteration with an error. Canceling ctx ends it without one.
+func (es *EventSource) Events(ctx context.Context) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		if err := es.c.hasRequest(); err != nil {
+			yield(Event{}, err)
+			return
+		}
+		if es.c.Error != nil {
+			yield(Event{}, es.c.Error)
+			return
+		}
+		es.mu.Lock()
+		if !es.started {
+			es.started = true
+			es.lastID, es.retry = es.LastEventID, es.Retry
+		}
+		es.mu.Unlock()
+
+		failures := 0
+		for {
+			resp, err := es.connect(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				if resp.StatusCode == http.StatusNoContent {
+					//the server asks not to reconnect
+					resp.Body.Close()
+					return
+				}
+				if err := checkEventStream(resp); err != nil {
+					resp.Body.Close()
+					yield(Event{}, err)
+					return
+				}
+				failures = 0
+				if !es.read(resp, yield) {
+					return
+				}
+				if ctx.Err() != nil {
+					return
+				}
+			} else {
+				failures++
+				if es.MaxRetries > 0 && failures > es.MaxRetries {
+					yield(Event{}, err)
+					return
+				}
+			}
+
+			timer := time.NewTimer(es.ReconnectDelay())
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+//delivers the events over a channel that is closed when the stream ends,
+//Err returns the error that ended it
+func (es *EventSource) Subscribe(ctx context.Context) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		for ev, err := range es.Events(ctx) {
+			if err != nil {
+				es.mu.Lock()
+				es.err = err
+				es.mu.Unlock()
+				return
+			}
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+//returns the id of the last event received, it is sent as Last-Event-ID
+//header when reconnecting
+func (es *EventSource) LastID() string {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if !es.started {
+		return es.LastEventID
+	}
+	return es.lastID
+}
+
+//returns the delay before reconnecting, the server can change it with a
+//retry field
+func (es *EventSource) ReconnectDelay() time.Duration {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if !es.started {
+		return es.Retry
+	}
+	return es.retry
+}
+
+//returns the error that ended the channel of Subscribe
+func (es *EventSource) Err() error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.err
+}
+
+//sends a copy of the request of the client with the stream headers
+func (es *EventSource) connect(ctx context.Context) (*http.Response, error) {
+	req := es.c.request.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if id := es.LastID(); id != "" {
+		req.Header.Set("Last-Event-ID", id)
+	}
+	attempt := *es.c
+	attempt.request = req
+	return attempt.Do()
+}
+
+func checkEventStream(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("event stream: unexpected status %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/event-stream" {
+		return fmt.Errorf("event stream: unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	return nil
+}
+
+//parses the stream as described by the WHATWG html standard, false means
+//the caller stopped the iteration
+func (es *EventSource) read(resp *http.Response, yield func(Event, error) bool) bool {
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 4096), 16<<20)
+	scanner.Split(scanEventLines)
+
+	var data strings.Builder
+	eventType := ""
+	lastID := es.LastID()
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\uFEFF")
+			first = false
+		}
+		if line == "" {
+			if data.Len() == 0 {
+				eventType = ""
+				continue
+			}
+			ev := Event{ID: lastID, Type: eventType, Data: strings.TrimSuffix(data.String(), "\n")}
+			if ev.Type == "" {
+				ev.Type = "message"
+			}
+			data.Reset()
+			eventType = ""
+			if !yield(ev, nil) {
+				return false
+			}
+			continue
+		}
+		if line[0] == ':' {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "id":
+			if strings.IndexByte(value, 0) < 0 {
+				lastID = value
+				es.mu.Lock()
+				es.lastID = value
+				es.mu.Unlock()
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				es.mu.Lock()
+				es.retry = time.Duration(ms) * time.Millisecond
+				es.mu.Unlock()
+			}
+		}
+	}
+	//an event without the final blank line is discarded
+	return true
+}
+
+//splits lines ending in CRLF, LF or CR
+func scanEventLines(data []byte, atEOF bool) (int, []byte, error) {
+	i := bytes.IndexAny(data, "\r\n")
+	if i < 0 {
+		if atEOF {
+			//pending data at the end of the stream is discarded
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+	if data[i] == '\r' {
+		if i+1 == len(data) && !atEOF {
+			//a LF may follow in the next read
+			return 0, nil, nil
+		}
+		if i+1 < len(data) && data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+	}
+	return i + 1, data[:i], nil
+}
@@ -0,0 +1,137 @@
+package httpcl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_EventSourceParse(t *testing.T) {
+	var lastIDs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastIDs = append(lastIDs, r.Header.Get("Last-Event-ID"))
+		if r.Header.Get("Accept") != "text/event-stream" {
+			t.Errorf("accept should be text/event-stream is %s", r.Header.Get("Accept"))
+		}
+		switch len(lastIDs) {
+		case 1:
+			w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+			fmt.Fprint(w, "\uFEFF: comment\nretry: 10\n\n"+
+				"data: first\ndata:  second\n\n"+
+				"event: update\r\nid: 7\r\ndata\r\n\r\n"+
+				"data: cr\rid: 8\r\r"+
+				"id: 9\n\n"+
+				"data: unfinished\n")
+		case 2:
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: again\n\n")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	es := Get(ts.URL).EventSource()
+	var events []Event
+	for ev, err := range es.Events(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+
+	want := []Event{
+		{ID: "", Type: "message", Data: "first\n second"},
+		{ID: "7", Type: "update", Data: ""},
+		{ID: "8", Type: "message", Data: "cr"},
+		{ID: "9", Type: "message", Data: "again"},
+	}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events should be %v is %v", want, events)
+	}
+	if fmt.Sprint(lastIDs) != "[ 9 9]" {
+		t.Errorf("Last-Event-ID headers should be [ 9 9] is %v", lastIDs)
+	}
+	if es.ReconnectDelay() != 10*time.Millisecond {
+		t.Errorf("retry should be 10ms is %v", es.ReconnectDelay())
+	}
+	if es.LastID() != "9" {
+		t.Errorf("last id should be 9 is %s", es.LastID())
+	}
+}
+
+func Test_EventSourceCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; ; i++ {
+			fmt.Fprintf(w, "id: %d\ndata: tick\n\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	es := Get(ts.URL).EventSource()
+	ch := es.Subscribe(ctx)
+	count := 0
+	for ev := range ch {
+		count++
+		//read while the reader goroutine updates it
+		if id := es.LastID(); id < ev.ID {
+			t.Errorf("last id should be at least %s is %s", ev.ID, id)
+		}
+		if count == 3 {
+			cancel()
+		}
+	}
+	if count < 3 {
+		t.Errorf("should receive 3 events, got %v", count)
+	}
+	if es.Err() != nil {
+		t.Errorf("canceling should not be an error, got %v", es.Err())
+	}
+}
+
+func Test_EventSourceErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	for _, err := range Get(ts.URL).EventSource().Events(context.Background()) {
+		if err == nil || !strings.Contains(err.Error(), "unexpected content type") {
+			t.Errorf("error should be unexpected content type is %v", err)
+		}
+	}
+
+	var attempts int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		panic(http.ErrAbortHandler)
+	}))
+	defer failing.Close()
+
+	es := Get(failing.URL).EventSource()
+	es.Retry = time.Millisecond
+	es.MaxRetries = 2
+	for _, err := range es.Events(context.Background()) {
+		if err == nil {
+			t.Error("should fail after the retries")
+		}
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("attempts should be 3 is %v", n)
+	}
+}
@@ -0,0 +1,365 @@
+package httpcl
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+//TLSOptions configure the TLS connections of a session. Certificates read
+//from files are read again when the files change, so rotated certificates
+//are used for new connections without recreating the session.
+type TLSOptions struct {
+	//files with PEM encoded CA certificates
+	CAFiles []string
+	//PEM encoded CA certificates
+	CAPEM []byte
+	//trust the system roots in addition to the CAs above, without any CA
+	//only the system roots are trusted
+	SystemRoots bool
+
+	//files with the PEM encoded client certificate and key, KeyFile can be
+	//empty if both are in CertFile
+	CertFile string
+	KeyFile  string
+	//PEM encoded client certificate and key
+	CertPEM []byte
+	KeyPEM  []byte
+
+	//the minimum TLS version like tls.VersionTLS13, defaults to TLS 1.2
+	MinVersion uint16
+	//the allowed TLS 1.2 cipher suites, insecure suites are rejected.
+	//The TLS 1.3 suites can't be configured.
+	CipherSuites []uint16
+
+	//the server name sent and verified for a host, like
+	//"10.0.0.5": "api.internal", also through a proxy
+	ServerNames map[string]string
+}
+
+//sets the TLS options of the session, they replace the TLS config of the
+//transport. An invalid config is returned as error of every request.
+func (s *Session) SetTLS(o TLSOptions) *Session {
+	st := &tlsState{opts: o}
+	cfg, err := st.config()
+	if err != nil {
+		return s.Use(errorMiddleware(err))
+	}
+	s.tlsState = st
+	s.serverNames = o.ServerNames
+	s.installTLS(cfg)
+	return s
+}
+
+//installs cfg as TLS config of the transport, its VerifyConnection checks
+//the roots of reloaded CA files and the pins of the session
+func (s *Session) installTLS(cfg *tls.Config) {
+	if old := s.transport.TLSClientConfig; old != nil {
+		//the transport already added h2 when NewSession registered http+unix
+		cfg.NextProtos = old.NextProtos
+	}
+	if (s.tlsState != nil && s.tlsState.manual) || s.pins != nil {
+		if verify := cfg.VerifyConnection; verify != nil {
+			cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+				if err := verify(cs); err != nil {
+					return err
+				}
+				return s.verifyConnection(cs)
+			}
+		} else {
+			cfg.VerifyConnection = s.verifyConnection
+		}
+	}
+	s.transport.TLSClientConfig = cfg
+	s.useTLSDialer()
+	s.transportChanged()
+}
+
+func (s *Session) verifyConnection(cs tls.ConnectionState) error {
+	chains := cs.VerifiedChains
+	if s.tlsState != nil && s.tlsState.manual {
+		var err error
+		if chains, err = s.tlsState.verify(cs); err != nil {
+			return err
+		}
+	}
+	if s.pins != nil {
+		return s.pins.check(cs, chains)
+	}
+	return nil
+}
+
+//returns a middleware failing every request with err
+func errorMiddleware(err error) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, err
+		})
+	}
+}
+
+type tlsState struct {
+	opts TLSOptions
+	//the system roots and CAPEM
+	static  *x509.CertPool
+	caFiles []fileCache
+	//the chain is verified by verify since the roots can change
+	manual bool
+
+	certFile fileCache
+	keyFile  fileCache
+	mu       sync.Mutex
+	pair     *tls.Certificate
+	certPEM  []byte
+	keyPEM   []byte
+}
+
+//builds the tls config, everything is loaded once to report errors early
+func (st *tlsState) config() (*tls.Config, error) {
+	o := st.opts
+	cfg := &tls.Config{MinVersion: o.MinVersion}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	if len(o.CipherSuites) > 0 {
+		if err := checkCipherSuites(o.CipherSuites); err != nil {
+			return nil, err
+		}
+		cfg.CipherSuites = o.CipherSuites
+	}
+
+	if len(o.CAFiles) > 0 || len(o.CAPEM) > 0 {
+		st.static = x509.NewCertPool()
+		if o.SystemRoots {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				return nil, err
+			}
+			st.static = pool
+		}
+		if len(o.CAPEM) > 0 {
+			certs, err := parseCertificates(o.CAPEM)
+			if err != nil {
+				return nil, fmt.Errorf("tls: CAPEM: %v", err)
+			}
+			for _, cert := range certs {
+				st.static.AddCert(cert)
+			}
+		}
+		st.caFiles = make([]fileCache, len(o.CAFiles))
+		roots, err := st.roots()
+		if err != nil {
+			return nil, err
+		}
+		if len(o.CAFiles) == 0 {
+			cfg.RootCAs = roots
+		} else {
+			cfg.InsecureSkipVerify = true
+			st.manual = true
+		}
+	}
+
+	if o.CertFile != "" || len(o.CertPEM) > 0 {
+		if _, err := st.clientCertificate(nil); err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = st.clientCertificate
+	} else if o.KeyFile != "" || len(o.KeyPEM) > 0 {
+		return nil, errors.New("tls: client key without certificate")
+	}
+	return cfg, nil
+}
+
+//rejects unknown and insecure cipher suites
+func checkCipherSuites(ids []uint16) error {
+	secure := map[uint16]bool{}
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.ID] = true
+	}
+	for _, id := range ids {
+		if !secure[id] {
+			return fmt.Errorf("tls: insecure or unknown cipher suite %s", tls.CipherSuiteName(id))
+		}
+	}
+	return nil
+}
+
+func parseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return certs, nil
+}
+
+//returns the static roots with the current content of the CA files
+func (st *tlsState) roots() (*x509.CertPool, error) {
+	pool := st.static.Clone()
+	for i, path := range st.opts.CAFiles {
+		v, err := st.caFiles[i].load(path, func(b []byte) (interface{}, error) {
+			certs, err := parseCertificates(b)
+			if err != nil {
+				return nil, fmt.Errorf("tls: %s: %v", path, err)
+			}
+			return certs, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range v.([]*x509.Certificate) {
+			pool.AddCert(cert)
+		}
+	}
+	return pool, nil
+}
+
+//verifies the presented chain against the current roots
+func (st *tlsState) verify(cs tls.ConnectionState) ([][]*x509.Certificate, error) {
+	if cs.ServerName == "" {
+		return nil, errors.New("tls: no server name to verify the certificate")
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return nil, errors.New("tls: no server certificate")
+	}
+	roots, err := st.roots()
+	if err != nil {
+		return nil, err
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	return cs.PeerCertificates[0].Verify(opts)
+}
+
+//returns the client certificate, while a rotation replaces the certificate
+//and key files one after another the previous pair is kept
+func (st *tlsState) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	o := st.opts
+	certPEM, keyPEM := o.CertPEM, o.KeyPEM
+	raw := func(b []byte) (interface{}, error) {
+		return b, nil
+	}
+	if o.CertFile != "" {
+		v, err := st.certFile.load(o.CertFile, raw)
+		if err != nil {
+			return nil, err
+		}
+		certPEM = v.([]byte)
+		keyPEM = certPEM
+	}
+	if o.KeyFile != "" {
+		v, err := st.keyFile.load(o.KeyFile, raw)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM = v.([]byte)
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.pair != nil && bytes.Equal(certPEM, st.certPEM) && bytes.Equal(keyPEM, st.keyPEM) {
+		return st.pair, nil
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		if st.pair != nil {
+			return st.pair, nil
+		}
+		return nil, fmt.Errorf("tls: client certificate: %v", err)
+	}
+	st.pair, st.certPEM, st.keyPEM = &pair, certPEM, keyPEM
+	return st.pair, nil
+}
+
+//context key set by connections that can't use HTTP/2
+type http1OnlyKey struct{}
+
+//installs a TLS dialer that applies the server names of the session. The
+//name is also passed to VerifyConnection for ip addresses, which aren't
+//sent as server name. net/http sets up TLS through a proxy without the
+//dialer, so https requests are tunnelled through the proxy by the dialer
+//itself.
+func (s *Session) useTLSDialer() {
+	t := s.transport
+	if t.DialTLSContext != nil {
+		return
+	}
+	s.proxy = t.Proxy
+	s.tunnel = true
+	t.Proxy = s.plainProxy
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := s.dialTunnel(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return s.clientTLS(ctx, conn, host)
+	}
+}
+
+//runs the TLS handshake with host over conn
+func (s *Session) clientTLS(ctx context.Context, conn net.Conn, host string) (net.Conn, error) {
+	cfg := &tls.Config{}
+	if s.transport.TLSClientConfig != nil {
+		cfg = s.transport.TLSClientConfig.Clone()
+	}
+	name := host
+	if n, ok := s.serverNames[host]; ok {
+		name = n
+	}
+	cfg.ServerName = name
+	if verify := cfg.VerifyConnection; verify != nil {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if cs.ServerName == "" {
+				cs.ServerName = name
+			}
+			return verify(cs)
+		}
+	}
+	if ctx.Value(http1OnlyKey{}) != nil {
+		var protos []string
+		for _, p := range cfg.NextProtos {
+			if p != "h2" {
+				protos = append(protos, p)
+			}
+		}
+		cfg.NextProtos = protos
+	}
+
+	tc := tls.Client(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
@@ -0,0 +1,341 @@
+package httpcl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//testCert is a certificate with its key, signed by parent or self signed
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool, hosts ...string) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+}
+
+func (c *testCert) keyPEM() []byte {
+	b, _ := x509.MarshalECPrivateKey(c.key)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+//writes the file with a new modification time, so it is read again
+func writeRotated(t *testing.T, path string, b []byte) {
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Duration(len(b)) * time.Second)
+	os.Chtimes(path, later, later)
+}
+
+//a TLS server requiring client certificates of clientCA, it answers with the
+//common name of the client and the server name it was asked for
+func mtlsServer(t *testing.T, serverCert *testCert, clientCA *testCert) *httptest.Server {
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCA.cert)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName + " " + r.TLS.ServerName))
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func Test_TLSClientCertificate(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	server := mtlsServer(t, newTestCert(t, "server", ca, false, "127.0.0.1"), ca)
+
+	client := newTestCert(t, "client", ca, false)
+	s := NewSession().SetTLS(TLSOptions{
+		CAPEM:   ca.certPEM(),
+		CertPEM: client.certPEM(),
+		KeyPEM:  client.keyPEM(),
+	})
+	var body string
+	if _, err := s.Get(server.URL).DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "client " {
+		t.Errorf("response should be client is %s", body)
+	}
+
+	if _, err := NewSession().SetTLS(TLSOptions{CAPEM: ca.certPEM()}).Get(server.URL).Do(); err == nil {
+		t.Error("request without client certificate should fail")
+	}
+	if _, err := NewSession().Get(server.URL).Do(); err == nil {
+		t.Error("request without the CA should fail")
+	}
+}
+
+func Test_TLSReload(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	other := newTestCert(t, "other", nil, true)
+	server := mtlsServer(t, newTestCert(t, "server", ca, false, "127.0.0.1"), ca)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	first := newTestCert(t, "first", ca, false)
+	writeRotated(t, caFile, other.certPEM())
+	writeRotated(t, certFile, first.certPEM())
+	writeRotated(t, keyFile, first.keyPEM())
+
+	s := NewSession().SetTLS(TLSOptions{CAFiles: []string{caFile}, CertFile: certFile, KeyFile: keyFile})
+	if _, err := s.Get(server.URL).Do(); err == nil {
+		t.Fatal("server of an untrusted CA should fail")
+	}
+
+	writeRotated(t, caFile, append(other.certPEM(), ca.certPEM()...))
+	var body string
+	if _, err := s.Get(server.URL).DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "first " {
+		t.Errorf("response should be first is %s", body)
+	}
+
+	//a certificate without its key keeps the previous pair
+	second := newTestCert(t, "second-client", ca, false)
+	writeRotated(t, certFile, second.certPEM())
+	s.Transport().CloseIdleConnections()
+	if _, err := s.Get(server.URL).DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "first " {
+		t.Errorf("response should still be first is %s", body)
+	}
+
+	writeRotated(t, keyFile, second.keyPEM())
+	s.Transport().CloseIdleConnections()
+	if _, err := s.Get(server.URL).DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "second-client " {
+		t.Errorf("response should be second-client is %s", body)
+	}
+}
+
+func Test_TLSServerName(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	client := newTestCert(t, "client", ca, false)
+	server := mtlsServer(t, newTestCert(t, "server", ca, false, "api.internal"), ca)
+
+	dir := t.TempDir()
+	combined := filepath.Join(dir, "client.pem")
+	writeRotated(t, combined, append(client.certPEM(), client.keyPEM()...))
+	caFile := filepath.Join(dir, "ca.pem")
+	writeRotated(t, caFile, ca.certPEM())
+
+	for _, o := range []TLSOptions{
+		{CAPEM: ca.certPEM(), CertFile: combined},
+		{CAFiles: []string{caFile}, CertFile: combined},
+	} {
+		if _, err := NewSession().SetTLS(o).Get(server.URL).Do(); err == nil {
+			t.Error("certificate of another host should fail")
+		}
+		o.ServerNames = map[string]string{"127.0.0.1": "api.internal"}
+		var body string
+		if _, err := NewSession().SetTLS(o).Get(server.URL).DoTransform(TransformToString, &body); err != nil {
+			t.Fatal(err)
+		}
+		if body != "client api.internal" {
+			t.Errorf("response should be client api.internal is %s", body)
+		}
+	}
+}
+
+func Test_TLSServerNameProxy(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	client := newTestCert(t, "client", ca, false)
+	server := mtlsServer(t, newTestCert(t, "server", ca, false, "api.internal"), ca)
+
+	var connects int32
+	handler := proxyHandler(t)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			atomic.AddInt32(&connects, 1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+	proxyURL := "http://user:passwd@" + proxy.Listener.Addr().String()
+	socks := socks5Server(t, server.Listener.Addr().String(), 443)
+
+	o := TLSOptions{
+		CAPEM:       ca.certPEM(),
+		CertPEM:     client.certPEM(),
+		KeyPEM:      client.keyPEM(),
+		ServerNames: map[string]string{"127.0.0.1": "api.internal", "backend.invalid": "api.internal"},
+	}
+	tests := []struct {
+		name    string
+		session *Session
+		url     string
+	}{
+		{"proxy after tls", NewSession().SetTLS(o).SetProxyURL(proxyURL), server.URL},
+		{"proxy before tls", NewSession().SetProxyURL(proxyURL).SetTLS(o), server.URL},
+		{"socks5", NewSession().SetTLS(o).SetProxyURL("socks5://user:passwd@" + socks), "https://backend.invalid/"},
+	}
+	for _, test := range tests {
+		var body string
+		if _, err := test.session.Get(test.url).DoTransform(TransformToString, &body); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if body != "client api.internal" {
+			t.Errorf("%s: response should be client api.internal is %s", test.name, body)
+		}
+	}
+	if connects != 2 {
+		t.Errorf("https requests should be tunnelled through the proxy, got %v CONNECT requests", connects)
+	}
+
+	resp, err := NewSession().SetTLS(o).SetProxyURL(proxy.URL).Get(server.URL).Do()
+	if err == nil {
+		resp.Body.Close()
+		t.Error("proxy without credentials should fail")
+	} else if !strings.Contains(err.Error(), "407") {
+		t.Errorf("error should contain the status of the proxy is %v", err)
+	}
+}
+
+func Test_TLSVersion(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(tls.CipherSuiteName(r.TLS.CipherSuite)))
+	}))
+	ts.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+
+	if _, err := NewSession().SetTLS(TLSOptions{CAPEM: ca, MinVersion: tls.VersionTLS13}).Get(ts.URL).Do(); err == nil {
+		t.Error("TLS 1.2 server should fail with min version TLS 1.3")
+	}
+
+	var body string
+	s := NewSession().SetTLS(TLSOptions{
+		CAPEM:        ca,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256},
+	})
+	if _, err := s.Get(ts.URL).DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256" {
+		t.Errorf("cipher suite should be TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256 is %s", body)
+	}
+}
+
+func Test_TLSInvalidOptions(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	options := map[string]TLSOptions{
+		"insecure or unknown cipher suite": {CipherSuites: []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}},
+		"no PEM encoded":                   {CAPEM: []byte("garbage")},
+		"no such file":                     {CAFiles: []string{filepath.Join(t.TempDir(), "missing.pem")}},
+		"client certificate":               {CertPEM: ca.certPEM()},
+		"key without certificate":          {KeyPEM: ca.keyPEM()},
+	}
+	for want, o := range options {
+		_, err := NewSession().SetTLS(o).Get("http://example.invalid/").Do()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error should contain %q is %v", want, err)
+		}
+	}
+}
+
+func Test_TLSWebSocket(t *testing.T) {
+	ts := httptest.NewUnstartedServer(&wsTestServer{})
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	s := NewSession().SetTLS(TLSOptions{
+		CAPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}),
+	})
+
+	resp, err := s.Get(ts.URL).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Proto != "HTTP/2.0" {
+		t.Errorf("protocol should be HTTP/2.0 is %s", resp.Proto)
+	}
+
+	//the upgrade has to negotiate HTTP/1.1
+	ws, err := s.Get(wsURL(ts)).AddHeader("Authorization", "Bearer token").WebSocket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.WriteText("hello")
+	if _, data, err := ws.ReadMessage(); err != nil || string(data) != "hello" {
+		t.Errorf("message should be hello is %s %v", data, err)
+	}
+}
@@ -0,0 +1,127 @@
+package httpcl
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+//the scheme of urls sent to a unix socket, the host is the escaped socket
+//path like http+unix://%2Fvar%2Frun%2Fdocker.sock/info
+const unixScheme = "http+unix"
+
+//used for clients without session, so their connections are pooled too
+var defaultUnixTransport = newUnixTransport(http.DefaultTransport.(*http.Transport), nil)
+
+//url.Parse rejects escaped slashes in the host, so the socket path of a
+//http+unix url is hex encoded before the url is parsed
+func encodeUnixURL(rawurl string) string {
+	prefix := unixScheme + "://"
+	if len(rawurl) < len(prefix) || !strings.EqualFold(rawurl[:len(prefix)], prefix) {
+		return rawurl
+	}
+	rest := rawurl[len(prefix):]
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	socket, err := url.PathUnescape(rest[:end])
+	if err != nil || socket == "" {
+		//left for url.Parse to report
+		return rawurl
+	}
+	return prefix + hex.EncodeToString([]byte(socket)) + rest[end:]
+}
+
+//returns the socket path of a hex encoded host
+func unixSocketPath(host string) (string, error) {
+	path, err := hex.DecodeString(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid unix socket host %q", host)
+	}
+	return string(path), nil
+}
+
+//unixTransport sends http+unix requests through its own transport, which
+//keeps a connection pool per socket
+type unixTransport struct {
+	inner *http.Transport
+}
+
+//copies base, the sockets are dialed with dial or a net.Dialer if it is nil
+func newUnixTransport(base *http.Transport, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *unixTransport {
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	t := base.Clone()
+	t.Proxy = nil
+	t.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		path, err := unixSocketPath(host)
+		if err != nil {
+			return nil, err
+		}
+		return dial(ctx, "unix", path)
+	}
+	return &unixTransport{inner: t}
+}
+
+func (t *unixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme = "http"
+	if r.Host == "" || r.Host == req.URL.Host {
+		//the socket path is no valid Host header
+		r.Host = "localhost"
+	}
+	return t.inner.RoundTrip(r)
+}
+
+//sessionUnixTransport sends the http+unix requests of a session through a
+//copy of the session transport, which is made again after the session
+//transport was configured
+type sessionUnixTransport struct {
+	s       *Session
+	mu      sync.Mutex
+	changes int
+	inner   *unixTransport
+}
+
+func (t *sessionUnixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	if t.inner == nil || t.changes != t.s.changes {
+		if t.inner != nil {
+			t.inner.inner.CloseIdleConnections()
+		}
+		t.inner = newUnixTransport(t.s.transport, t.s.dialSocket)
+		t.changes = t.s.changes
+	}
+	inner := t.inner
+	t.mu.Unlock()
+	return inner.RoundTrip(req)
+}
+
+//sends all requests of the session to the unix socket at path, the host of
+//the url is only used for the Host header. The socket is dialed with the
+//dialer of the transport, a proxy set with SetProxy is reached through the
+//socket.
+//
+//	httpcl.NewSession().UnixSocket("/var/run/docker.sock").Get("http://docker/info")
+func (s *Session) UnixSocket(path string) *Session {
+	if !s.proxySet {
+		//the proxy of the environment isn't meant for the socket
+		s.setProxyFunc(nil)
+	}
+	s.socket = path
+	s.useDialer()
+	s.transportChanged()
+	return s
+}
@@ -0,0 +1,117 @@
+package httpcl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+//serves on a unix socket and counts the accepted connections
+func unixServer(t *testing.T) (string, *int32) {
+	path := filepath.Join(t.TempDir(), "d.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip("unix sockets not supported:", err)
+	}
+	var conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method + " " + r.Host + " " + r.URL.RequestURI() + " " + r.Header.Get("X-Test")))
+	}))
+	ts.Listener.Close()
+	ts.Listener = l
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return path, &conns
+}
+
+func Test_UnixSocketURL(t *testing.T) {
+	path, conns := unixServer(t)
+	base := "http+unix://" + url.PathEscape(path)
+
+	var s string
+	if _, err := Get(base+"/info?all=1").AddHeader("X-Test", "1").DoTransform(TransformToString, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "GET localhost /info?all=1 1" {
+		t.Errorf("response should be GET localhost /info?all=1 1 is %s", s)
+	}
+
+	session := NewSession()
+	for i := 0; i < 3; i++ {
+		if _, err := session.Post(base+"/items/{id}", Vars{"id": i}, "a", "b").DoTransform(TransformToString, &s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s != "POST localhost /items/2 " {
+		t.Errorf("response should be POST localhost /items/2 is %s", s)
+	}
+	if n := atomic.LoadInt32(conns); n != 2 {
+		t.Errorf("connections should be reused, got %v connections", n)
+	}
+
+	if _, err := Get("http+unix://%2Fmissing.sock/").Do(); err == nil {
+		t.Error("missing socket should fail")
+	}
+}
+
+func Test_UnixSocketSession(t *testing.T) {
+	path, conns := unixServer(t)
+	s := NewSession().UnixSocket(path)
+	var body string
+	for i := 0; i < 3; i++ {
+		if _, err := s.Get("http://docker/version").DoTransform(TransformToString, &body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if body != "GET docker /version " {
+		t.Errorf("response should be GET docker /version is %s", body)
+	}
+	if n := atomic.LoadInt32(conns); n != 1 {
+		t.Errorf("connections should be reused, got %v connections", n)
+	}
+}
+
+func Test_UnixSocketConfig(t *testing.T) {
+	path, _ := unixServer(t)
+	base := "http+unix://" + url.PathEscape(path)
+
+	//the dialer is set after the session is created
+	var networks []string
+	s := NewSession()
+	s.Transport().DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		networks = append(networks, network)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	var body string
+	if _, err := s.Get(base+"/info").DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	s.UnixSocket(path)
+	if _, err := s.Get("http://docker/version").DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(networks) != "[unix unix]" {
+		t.Errorf("both requests should use the dialer of the transport, got %v", networks)
+	}
+
+	//the proxy is reached through the socket
+	s = NewSession().SetProxyURL("http://proxy.test:3128").UnixSocket(path)
+	if _, err := s.Get("http://docker/version").DoTransform(TransformToString, &body); err != nil {
+		t.Fatal(err)
+	}
+	if s.Transport().Proxy == nil || body != "GET docker /version " {
+		t.Errorf("the proxy should be kept and reached through the socket, got %s", body)
+	}
+}
@@ -0,0 +1,324 @@
+package httpcl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//Vars are the variables of a URI template (RFC 6570). Values can be scalars,
+//slices or maps, nil and empty slices or maps are undefined.
+type Vars map[string]interface{}
+
+type routeKey struct{}
+
+//returns the uri template the request was created from, or the url path
+//if it wasn't created from a template. The result is a low cardinality
+//label for metrics and logs.
+func RouteLabel(req *http.Request) string {
+	if route, ok := req.Context().Value(routeKey{}).(string); ok {
+		return route
+	}
+	return req.URL.Path
+}
+
+//returns the uri template the client was created from or the url path
+func (c *Client) Route() string {
+	if c.route != "" {
+		return c.route
+	}
+	if c.request != nil {
+		return c.request.URL.Path
+	}
+	return ""
+}
+
+//expands rawurl as uri template if there are vars
+func expandURL(rawurl string, vars []Vars) (string, string, error) {
+	if len(vars) == 0 {
+		return encodeUnixURL(rawurl), "", nil
+	}
+	merged := Vars{}
+	for _, v := range vars {
+		for key, value := range v {
+			merged[key] = value
+		}
+	}
+	expanded, err := ExpandTemplate(rawurl, merged)
+	if err != nil {
+		return "", "", err
+	}
+	return encodeUnixURL(expanded), rawurl, nil
+}
+
+//splits leading Vars from the body params
+func splitVars(params []interface{}) ([]Vars, []interface{}) {
+	var vars []Vars
+	for len(params) > 0 {
+		v, ok := params[0].(Vars)
+		if !ok {
+			break
+		}
+		vars = append(vars, v)
+		params = params[1:]
+	}
+	return vars, params
+}
+
+//records the template on the client and the context of its request
+func withRoute(c *Client, route string) *Client {
+	if route == "" {
+		return c
+	}
+	c.route = route
+	if c.request != nil {
+		c.request = c.request.WithContext(context.WithValue(c.request.Context(), routeKey{}, route))
+	}
+	return c
+}
+
+type templateOp struct {
+	first    string
+	sep      string
+	named    bool
+	ifEmpty  string
+	reserved bool
+}
+
+var templateOps = map[byte]templateOp{
+	0:   {"", ",", false, "", false},
+	'+': {"", ",", false, "", true},
+	'.': {".", ".", false, "", false},
+	'/': {"/", "/", false, "", false},
+	';': {";", ";", true, "", false},
+	'?': {"?", "&", true, "=", false},
+	'&': {"&", "&", true, "=", false},
+	'#': {"#", ",", false, "", true},
+}
+
+//expands a URI template (RFC 6570) up to level 4
+func ExpandTemplate(tmpl string, vars Vars) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(tmpl, '{')
+		if i < 0 {
+			if strings.IndexByte(tmpl, '}') >= 0 {
+				return "", fmt.Errorf("unmatched } in uri template")
+			}
+			b.WriteString(encodeTemplate(tmpl, true))
+			return b.String(), nil
+		}
+		j := strings.IndexByte(tmpl[i:], '}')
+		if j < 0 {
+			return "", fmt.Errorf("unterminated expression in uri template")
+		}
+		b.WriteString(encodeTemplate(tmpl[:i], true))
+		if err := expandExpression(&b, tmpl[i+1:i+j], vars); err != nil {
+			return "", err
+		}
+		tmpl = tmpl[i+j+1:]
+	}
+}
+
+func expandExpression(b *strings.Builder, expr string, vars Vars) error {
+	if expr == "" {
+		return fmt.Errorf("empty expression in uri template")
+	}
+	op, ok := templateOps[expr[0]]
+	if ok {
+		expr = expr[1:]
+	} else if strings.IndexByte("=,!@|", expr[0]) >= 0 {
+		return fmt.Errorf("reserved operator %c in uri template", expr[0])
+	} else {
+		op = templateOps[0]
+	}
+
+	first := true
+	for _, spec := range strings.Split(expr, ",") {
+		name := spec
+		explode := false
+		prefix := 0
+		if strings.HasSuffix(name, "*") {
+			explode = true
+			name = name[:len(name)-1]
+		} else if k := strings.IndexByte(name, ':'); k >= 0 {
+			if _, err := fmt.Sscanf(name[k+1:], "%d", &prefix); err != nil || prefix <= 0 || prefix >= 10000 {
+				return fmt.Errorf("invalid prefix in uri template variable %s", spec)
+			}
+			name = name[:k]
+		}
+		if !validVarName(name) {
+			return fmt.Errorf("invalid uri template variable %q", spec)
+		}
+
+		value, err := expandVar(op, name, vars[name], explode, prefix)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+		if first {
+			b.WriteString(op.first)
+			first = false
+		} else {
+			b.WriteString(op.sep)
+		}
+		b.WriteString(*value)
+	}
+	return nil
+}
+
+func validVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '_', c == '.':
+		case c == '%' && i+2 < len(name) && isHex(name[i+1]) && isHex(name[i+2]):
+			i += 2
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+//expands one variable, nil means it is undefined
+func expandVar(op templateOp, name string, value interface{}, explode bool, prefix int) (*string, error) {
+	v := indirect(reflect.ValueOf(value))
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	if s, ok, err := formScalar(v, formOptions{}); ok {
+		if err != nil {
+			return nil, err
+		}
+		if prefix > 0 && utf8.RuneCountInString(s) > prefix {
+			runes := []rune(s)
+			s = string(runes[:prefix])
+		}
+		out := encodeTemplate(s, op.reserved)
+		if op.named {
+			if s == "" {
+				out = name + op.ifEmpty
+			} else {
+				out = name + "=" + out
+			}
+		}
+		return &out, nil
+	}
+	if prefix > 0 {
+		return nil, fmt.Errorf("prefix modifier on composite uri template variable %s", name)
+	}
+
+	var pairs [][2]string
+	isMap := false
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			s, err := templateScalar(name, v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, [2]string{"", s})
+		}
+	case reflect.Map:
+		isMap = true
+		keys := make([]string, 0, v.Len())
+		index := map[string]reflect.Value{}
+		for _, k := range v.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			index[key] = v.MapIndex(k)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s, err := templateScalar(name, index[key])
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, [2]string{key, s})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type %s for uri template variable %s", v.Type(), name)
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	var parts []string
+	if !explode {
+		for _, p := range pairs {
+			if isMap {
+				parts = append(parts, encodeTemplate(p[0], op.reserved))
+			}
+			parts = append(parts, encodeTemplate(p[1], op.reserved))
+		}
+		out := strings.Join(parts, ",")
+		if op.named {
+			out = name + "=" + out
+		}
+		return &out, nil
+	}
+
+	for _, p := range pairs {
+		value := encodeTemplate(p[1], op.reserved)
+		switch {
+		case isMap:
+			parts = append(parts, encodeTemplate(p[0], op.reserved)+"="+value)
+		case op.named && p[1] == "":
+			parts = append(parts, name+op.ifEmpty)
+		case op.named:
+			parts = append(parts, name+"="+value)
+		default:
+			parts = append(parts, value)
+		}
+	}
+	out := strings.Join(parts, op.sep)
+	return &out, nil
+}
+
+func templateScalar(name string, v reflect.Value) (string, error) {
+	v = indirect(v)
+	if !v.IsValid() {
+		return "", nil
+	}
+	s, ok, err := formScalar(v, formOptions{})
+	if !ok {
+		return "", fmt.Errorf("nested composite value in uri template variable %s", name)
+	}
+	return s, err
+}
+
+//percent encodes s, reserved characters and existing percent encodings
+//are kept if allowReserved is set
+func encodeTemplate(s string, allowReserved bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case isUnreserved(c):
+			b.WriteByte(c)
+		case allowReserved && strings.IndexByte(":/?#[]@!$&'()*+,;=", c) >= 0:
+			b.WriteByte(c)
+		case allowReserved && c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteString(s[i : i+3])
+			i += 2
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,120 @@
+package httpcl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_ExpandTemplate(t *testing.T) {
+	vars := Vars{
+		"var":   "value",
+		"hello": "Hello World!",
+		"path":  "/foo/bar",
+		"list":  []string{"red", "green", "blue"},
+		"keys":  map[string]string{"semi": ";", "dot": ".", "comma": ","},
+		"x":     1024,
+		"y":     768,
+		"empty": "",
+		"none":  []string{},
+	}
+	cases := map[string]string{
+		"{var}":                "value",
+		"{hello}":              "Hello%20World%21",
+		"{+hello}":             "Hello%20World!",
+		"{undef}":              "",
+		"{x,hello,y}":          "1024,Hello%20World%21,768",
+		"{var:3}":              "val",
+		"{var:30}":             "value",
+		"{list}":               "red,green,blue",
+		"{list*}":              "red,green,blue",
+		"{keys}":               "comma,%2C,dot,.,semi,%3B",
+		"{keys*}":              "comma=%2C,dot=.,semi=%3B",
+		"{+path:6}/here":       "/foo/b/here",
+		"{+list}":              "red,green,blue",
+		"{+keys}":              "comma,,,dot,.,semi,;",
+		"{+keys*}":             "comma=,,dot=.,semi=;",
+		"{#path:6}/here":       "#/foo/b/here",
+		"{#keys*}":             "#comma=,,dot=.,semi=;",
+		"X{.var:3}":            "X.val",
+		"X{.list*}":            "X.red.green.blue",
+		"X{.keys*}":            "X.comma=%2C.dot=..semi=%3B",
+		"{/var:1,var}":         "/v/value",
+		"{/list*,path:4}":      "/red/green/blue/%2Ffoo",
+		"{/keys*}":             "/comma=%2C/dot=./semi=%3B",
+		"{;hayes,x,y,empty}":   ";x=1024;y=768;empty",
+		"{;list*}":             ";list=red;list=green;list=blue",
+		"{;keys}":              ";keys=comma,%2C,dot,.,semi,%3B",
+		"{?var:3}":             "?var=val",
+		"{?list*}":             "?list=red&list=green&list=blue",
+		"{?keys*}":             "?comma=%2C&dot=.&semi=%3B",
+		"{&list*}":             "&list=red&list=green&list=blue",
+		"{?x,y,empty}":         "?x=1024&y=768&empty=",
+		"{?none,undef}":        "",
+		"/map?{x,y}":           "/map?1024,768",
+		"{var}/with space":     "value/with%20space",
+		"{/var}{?x}{&list*}":   "/value?x=1024&list=red&list=green&list=blue",
+		"{;x:2}{#hello:5}":     ";x=10#Hello",
+		"{.list}{/keys}":       ".red,green,blue/comma,%2C,dot,.,semi,%3B",
+		"{?var,hello}{#path}":  "?var=value&hello=Hello%20World%21#/foo/bar",
+		"{+path}/{var}{?list}": "/foo/bar/value?list=red,green,blue",
+	}
+	for tmpl, expected := range cases {
+		s, err := ExpandTemplate(tmpl, vars)
+		if err != nil {
+			t.Errorf("%s: %s", tmpl, err.Error())
+			continue
+		}
+		if s != expected {
+			t.Errorf("%s should expand to %s is %s", tmpl, expected, s)
+		}
+	}
+
+	for _, tmpl := range []string{"{", "}", "{}", "{=var}", "{var:0}", "{list:3}", "{a b}"} {
+		if _, err := ExpandTemplate(tmpl, vars); err == nil {
+			t.Errorf("%s should fail", tmpl)
+		}
+	}
+}
+
+func Test_GetTemplate(t *testing.T) {
+	var uri, route string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uri = r.RequestURI
+	}))
+	defer ts.Close()
+
+	tmpl := ts.URL + "/orgs/{org}/repos/{repo}/issues{?state,labels*}"
+	cl := Get(tmpl, Vars{"org": "a b", "repo": "x/y", "state": "open", "labels": []string{"bug", "ui"}})
+	cl.Use(func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			route = RouteLabel(req)
+			return next.RoundTrip(req)
+		})
+	})
+	cl.Do()
+
+	if uri != "/orgs/a%20b/repos/x%2Fy/issues?state=open&labels=bug&labels=ui" {
+		t.Errorf("unexpected request uri %s", uri)
+	}
+	if route != tmpl || cl.Route() != tmpl {
+		t.Errorf("route should be %s is %s", tmpl, route)
+	}
+
+	cl = NewSession().Post(ts.URL+"/items/{id}", Vars{"id": 5}, "name", "x")
+	if cl.Error != nil {
+		t.Fatal(cl.Error.Error())
+	}
+	if cl.GetRequest().URL.Path != "/items/5" {
+		t.Errorf("path should be /items/5 is %s", cl.GetRequest().URL.Path)
+	}
+
+	cl = ClientBuilder{Method: "PUT", Url: ts.URL + "/items/{id}", Vars: Vars{"id": 6}}.Build()
+	if cl.Error != nil || cl.GetRequest().URL.Path != "/items/6" {
+		t.Errorf("builder should expand the template, got %v", cl.Error)
+	}
+
+	if Get(ts.URL+"/plain").Route() != "/plain" {
+		t.Error("route should fall back to the path")
+	}
+}
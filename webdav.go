@@ -0,0 +1,598 @@
+package httpcl
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Depth is the Depth header of PROPFIND, COPY and LOCK requests
+type Depth int
+
+const (
+	DepthZero     Depth = 0
+	DepthOne      Depth = 1
+	DepthInfinity Depth = -1
+)
+
+func (d Depth) String() string {
+	if d < 0 {
+		return "infinity"
+	}
+	return strconv.Itoa(int(d))
+}
+
+const davContentType = "application/xml; charset=utf-8"
+
+//WebDAV works with the resources of a WebDAV server below URL. Paths are
+//relative to URL, collections end with a slash.
+type WebDAV struct {
+	URL string
+	//requests are created from the session if set, so they share its
+	//transport, cookies and auth
+	Session *Session
+	//headers added to every request
+	Header http.Header
+
+	mu    sync.Mutex
+	locks map[string]*DAVLock
+}
+
+//a DAVResource is a response of a multistatus body
+type DAVResource struct {
+	//the path relative to the WebDAV URL
+	Path string
+	//the href as sent by the server
+	Href          string
+	IsCollection  bool
+	DisplayName   string
+	ContentLength int64
+	ContentType   string
+	ETag          string
+	LastModified  time.Time
+	//the inner xml of all properties found
+	Props map[xml.Name]string
+}
+
+//a DAVProperty is set by PropPatch, Value is sent as text
+type DAVProperty struct {
+	Name  xml.Name
+	Value string
+}
+
+//a DAVLock is a lock created by Lock
+type DAVLock struct {
+	Path    string
+	Token   string
+	Timeout time.Duration
+}
+
+//a WebDAVError is a failed request or a failed part of a multistatus
+//response
+type WebDAVError struct {
+	Method     string
+	Path       string
+	StatusCode int
+}
+
+func (e *WebDAVError) Error() string {
+	return fmt.Sprintf("webdav: %s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+//creates a WebDAV client for the resources below url
+func NewWebDAV(url string) *WebDAV {
+	return &WebDAV{URL: url}
+}
+
+//creates a WebDAV client for the resources below url sending through the session
+func (s *Session) WebDAV(url string) *WebDAV {
+	return &WebDAV{URL: url, Session: s}
+}
+
+//returns the absolute url of the path
+func (w *WebDAV) resolve(p string) (*url.URL, error) {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(p, "/")
+	u.RawPath = ""
+	return u, nil
+}
+
+//returns the path of an href relative to the WebDAV URL
+func (w *WebDAV) relative(href string) string {
+	p := href
+	if u, err := url.Parse(href); err == nil {
+		p = u.Path
+	}
+	if base, err := url.Parse(w.URL); err == nil {
+		p = strings.TrimPrefix(p, strings.TrimSuffix(base.Path, "/"))
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+func (w *WebDAV) request(ctx context.Context, method, p string, body io.Reader, header http.Header) (*http.Response, error) {
+	u, err := w.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	var params []interface{}
+	if body != nil {
+		params = append(params, body)
+	}
+	c := getRequestWithBody(method, u.String(), params)
+	if w.Session != nil {
+		c = w.Session.bind(c)
+	}
+	for key, values := range w.Header {
+		for _, value := range values {
+			c.AddHeader(key, value)
+		}
+	}
+	c.runWithHasRequest(func() {
+		for key, values := range header {
+			c.request.Header[key] = values
+		}
+	})
+	if c.Error == nil && ctx != nil {
+		c.SetRequest(c.GetRequest().WithContext(ctx))
+	}
+	return c.Do()
+}
+
+//sends a request expecting a status of 2xx, the body is discarded
+func (w *WebDAV) exec(ctx context.Context, method, p string, body io.Reader, header http.Header) error {
+	resp, err := w.request(ctx, method, p, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode == http.StatusMultiStatus {
+		//a multistatus for a single resource request reports failed members
+		return &WebDAVError{Method: method, Path: p, StatusCode: resp.StatusCode}
+	}
+	return statusError(method, p, resp)
+}
+
+func statusError(method, p string, resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &WebDAVError{Method: method, Path: p, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+//PropFind returns the resources of path down to depth with the given
+//properties, all properties if none are given
+func (w *WebDAV) PropFind(ctx context.Context, p string, depth Depth, props ...xml.Name) ([]DAVResource, error) {
+	var body bytes.Buffer
+	body.WriteString(xml.Header + `<D:propfind xmlns:D="DAV:">`)
+	if len(props) == 0 {
+		body.WriteString("<D:allprop/>")
+	} else {
+		body.WriteString("<D:prop>")
+		for _, name := range props {
+			writeDAVName(&body, name, "")
+		}
+		body.WriteString("</D:prop>")
+	}
+	body.WriteString("</D:propfind>")
+
+	header := http.Header{"Depth": {depth.String()}, "Content-Type": {davContentType}}
+	resp, err := w.request(ctx, "PROPFIND", p, &body, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		if err := statusError("PROPFIND", p, resp); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("webdav: PROPFIND %s: expected multistatus, got %s", p, resp.Status)
+	}
+	ms, err := decodeMultistatus(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]DAVResource, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		if len(r.Hrefs) == 0 {
+			continue
+		}
+		res := DAVResource{Href: r.Hrefs[0], Path: w.relative(r.Hrefs[0]), Props: map[xml.Name]string{}}
+		for _, ps := range r.Propstats {
+			if code := parseDAVStatus(ps.Status); code < 200 || code > 299 {
+				continue
+			}
+			for _, prop := range ps.Prop.Props {
+				res.Props[prop.XMLName] = prop.Inner
+				if prop.XMLName.Space == "DAV:" {
+					res.setLive(prop)
+				}
+			}
+		}
+		resources = append(resources, res)
+	}
+	return resources, nil
+}
+
+func (r *DAVResource) setLive(prop davAnyProp) {
+	text := strings.TrimSpace(prop.Text)
+	switch prop.XMLName.Local {
+	case "resourcetype":
+		r.IsCollection = prop.Collection != nil
+	case "displayname":
+		r.DisplayName = text
+	case "getcontentlength":
+		r.ContentLength, _ = strconv.ParseInt(text, 10, 64)
+	case "getcontenttype":
+		r.ContentType = text
+	case "getetag":
+		r.ETag = text
+	case "getlastmodified":
+		r.LastModified, _ = http.ParseTime(text)
+	}
+}
+
+//returns the properties of the resource at path
+func (w *WebDAV) Stat(ctx context.Context, p string, props ...xml.Name) (*DAVResource, error) {
+	resources, err := w.PropFind(ctx, p, DepthZero, props...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("webdav: PROPFIND %s: empty multistatus", p)
+	}
+	return &resources[0], nil
+}
+
+//returns the members of the collection at path without the collection itself
+func (w *WebDAV) ReadDir(ctx context.Context, p string, props ...xml.Name) ([]DAVResource, error) {
+	resources, err := w.PropFind(ctx, p, DepthOne, props...)
+	if err != nil {
+		return nil, err
+	}
+	self := "/" + strings.Trim(p, "/")
+	members := resources[:0]
+	for _, r := range resources {
+		if "/"+strings.Trim(r.Path, "/") != self {
+			members = append(members, r)
+		}
+	}
+	return members, nil
+}
+
+//Walk calls fn for the resource at root and everything below it, parents
+//before their members. Collections are listed with depth 1 one by one, as
+//servers often refuse depth infinity. Returning fs.SkipDir for a collection
+//skips its members, fs.SkipAll stops the walk without an error.
+func (w *WebDAV) Walk(ctx context.Context, root string, fn func(DAVResource) error) error {
+	res, err := w.Stat(ctx, root)
+	if err != nil {
+		return err
+	}
+	err = w.walk(ctx, *res, fn)
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (w *WebDAV) walk(ctx context.Context, res DAVResource, fn func(DAVResource) error) error {
+	if err := fn(res); err != nil {
+		return err
+	}
+	if !res.IsCollection {
+		return nil
+	}
+	members, err := w.ReadDir(ctx, res.Path)
+	if err != nil {
+		return err
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Path < members[j].Path })
+	for _, m := range members {
+		err := w.walk(ctx, m, fn)
+		if err == fs.SkipDir && !m.IsCollection {
+			//skips the remaining members like filepath.Walk
+			return nil
+		}
+		if err != nil && err != fs.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
+
+//creates the collection at path
+func (w *WebDAV) Mkcol(ctx context.Context, p string) error {
+	return w.exec(ctx, "MKCOL", p, nil, w.ifHeader(p))
+}
+
+//uploads body to path
+func (w *WebDAV) Put(ctx context.Context, p string, body io.Reader) error {
+	return w.exec(ctx, "PUT", p, body, w.ifHeader(p))
+}
+
+//deletes the resource at path, collections with their members
+func (w *WebDAV) Delete(ctx context.Context, p string) error {
+	return w.exec(ctx, "DELETE", p, nil, w.ifHeader(p))
+}
+
+//copies the resource at src to dst, collections with all members
+func (w *WebDAV) Copy(ctx context.Context, src, dst string, overwrite bool) error {
+	header, err := w.destination(dst, overwrite, w.ifHeader(dst))
+	if err != nil {
+		return err
+	}
+	header.Set("Depth", DepthInfinity.String())
+	return w.exec(ctx, "COPY", src, nil, header)
+}
+
+//moves the resource at src to dst
+func (w *WebDAV) Move(ctx context.Context, src, dst string, overwrite bool) error {
+	header, err := w.destination(dst, overwrite, w.ifHeader(src, dst))
+	if err != nil {
+		return err
+	}
+	return w.exec(ctx, "MOVE", src, nil, header)
+}
+
+func (w *WebDAV) destination(dst string, overwrite bool, header http.Header) (http.Header, error) {
+	u, err := w.resolve(dst)
+	if err != nil {
+		return nil, err
+	}
+	header.Set("Destination", u.String())
+	if overwrite {
+		header.Set("Overwrite", "T")
+	} else {
+		header.Set("Overwrite", "F")
+	}
+	return header, nil
+}
+
+//PropPatch sets and removes dead properties of the resource at path. The
+//changes are atomic, a failure is returned as *WebDAVError with the status
+//of the first failed property.
+func (w *WebDAV) PropPatch(ctx context.Context, p string, set []DAVProperty, remove []xml.Name) error {
+	var body bytes.Buffer
+	body.WriteString(xml.Header + `<D:propertyupdate xmlns:D="DAV:">`)
+	if len(set) > 0 {
+		body.WriteString("<D:set><D:prop>")
+		for _, prop := range set {
+			writeDAVName(&body, prop.Name, prop.Value)
+		}
+		body.WriteString("</D:prop></D:set>")
+	}
+	if len(remove) > 0 {
+		body.WriteString("<D:remove><D:prop>")
+		for _, name := range remove {
+			writeDAVName(&body, name, "")
+		}
+		body.WriteString("</D:prop></D:remove>")
+	}
+	body.WriteString("</D:propertyupdate>")
+
+	header := w.ifHeader(p)
+	header.Set("Content-Type", davContentType)
+	resp, err := w.request(ctx, "PROPPATCH", p, &body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return statusError("PROPPATCH", p, resp)
+	}
+	ms, err := decodeMultistatus(resp.Body)
+	if err != nil {
+		return err
+	}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			//424 failed dependency marks the properties that failed because of another
+			if code := parseDAVStatus(ps.Status); (code < 200 || code > 299) && code != http.StatusFailedDependency {
+				return &WebDAVError{Method: "PROPPATCH", Path: p, StatusCode: code}
+			}
+		}
+	}
+	return nil
+}
+
+//Lock takes an exclusive write lock on the resource at path, collections
+//are locked with their members. The lock token is sent with all following
+//requests changing a locked resource until Unlock. A timeout of 0 asks for
+//an infinite lock.
+func (w *WebDAV) Lock(ctx context.Context, p string, timeout time.Duration) (*DAVLock, error) {
+	body := xml.Header + `<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	header := w.ifHeader(p)
+	header.Set("Content-Type", davContentType)
+	header.Set("Depth", DepthInfinity.String())
+	if timeout > 0 {
+		header.Set("Timeout", fmt.Sprintf("Second-%d", int64(timeout/time.Second)))
+	} else {
+		header.Set("Timeout", "Infinite")
+	}
+	resp, err := w.request(ctx, "LOCK", p, strings.NewReader(body), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := statusError("LOCK", p, resp); err != nil {
+		return nil, err
+	}
+
+	lock := &DAVLock{Path: p, Token: strings.Trim(resp.Header.Get("Lock-Token"), "<> ")}
+	var prop davLockProp
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&prop); err == nil {
+		for _, active := range prop.LockDiscovery.ActiveLocks {
+			token := strings.TrimSpace(active.LockToken.Href)
+			if lock.Token == "" || lock.Token == token {
+				lock.Token = token
+				lock.Timeout = parseDAVTimeout(active.Timeout)
+				break
+			}
+		}
+	}
+	if lock.Token == "" {
+		return nil, fmt.Errorf("webdav: LOCK %s: no lock token in response", p)
+	}
+
+	w.mu.Lock()
+	if w.locks == nil {
+		w.locks = map[string]*DAVLock{}
+	}
+	w.locks[p] = lock
+	w.mu.Unlock()
+	return lock, nil
+}
+
+//releases the lock taken on path
+func (w *WebDAV) Unlock(ctx context.Context, p string) error {
+	w.mu.Lock()
+	lock := w.locks[p]
+	w.mu.Unlock()
+	if lock == nil {
+		return fmt.Errorf("webdav: no lock on %s", p)
+	}
+	header := http.Header{"Lock-Token": {"<" + lock.Token + ">"}}
+	if err := w.exec(ctx, "UNLOCK", p, nil, header); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	delete(w.locks, p)
+	w.mu.Unlock()
+	return nil
+}
+
+//returns the tokens of the locks held on the paths or their collections
+//as tagged If header
+func (w *WebDAV) ifHeader(paths ...string) http.Header {
+	header := http.Header{}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var conditions []string
+	for lp, lock := range w.locks {
+		for _, p := range paths {
+			if !lockCovers(lp, p) {
+				continue
+			}
+			if u, err := w.resolve(lp); err == nil {
+				conditions = append(conditions, fmt.Sprintf("<%s> (<%s>)", u, lock.Token))
+			}
+			break
+		}
+	}
+	if len(conditions) > 0 {
+		sort.Strings(conditions)
+		header.Set("If", strings.Join(conditions, " "))
+	}
+	return header
+}
+
+//reports if a lock on lockPath covers p, locks on collections cover their members
+func lockCovers(lockPath, p string) bool {
+	lockPath = "/" + strings.Trim(lockPath, "/")
+	p = "/" + strings.Trim(p, "/")
+	return p == lockPath || strings.HasPrefix(p, strings.TrimSuffix(lockPath, "/")+"/")
+}
+
+//writes an empty element or one with the escaped value as text
+func writeDAVName(w *bytes.Buffer, name xml.Name, value string) {
+	if name.Space == "DAV:" {
+		w.WriteString("<D:" + name.Local)
+	} else {
+		fmt.Fprintf(w, `<x:%s xmlns:x="`, name.Local)
+		xml.EscapeText(w, []byte(name.Space))
+		w.WriteString(`"`)
+	}
+	if value == "" {
+		w.WriteString("/>")
+		return
+	}
+	w.WriteString(">")
+	xml.EscapeText(w, []byte(value))
+	if name.Space == "DAV:" {
+		w.WriteString("</D:" + name.Local + ">")
+	} else {
+		w.WriteString("</x:" + name.Local + ">")
+	}
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Hrefs     []string      `xml:"DAV: href"`
+	Status    string        `xml:"DAV: status"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Prop struct {
+		Props []davAnyProp `xml:",any"`
+	} `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+type davAnyProp struct {
+	XMLName    xml.Name
+	Inner      string    `xml:",innerxml"`
+	Text       string    `xml:",chardata"`
+	Collection *struct{} `xml:"DAV: collection"`
+}
+
+type davLockProp struct {
+	LockDiscovery struct {
+		ActiveLocks []struct {
+			LockToken struct {
+				Href string `xml:"DAV: href"`
+			} `xml:"DAV: locktoken"`
+			Timeout string `xml:"DAV: timeout"`
+		} `xml:"DAV: activelock"`
+	} `xml:"DAV: lockdiscovery"`
+}
+
+func decodeMultistatus(r io.Reader) (*davMultistatus, error) {
+	var ms davMultistatus
+	if err := xml.NewDecoder(io.LimitReader(r, 64<<20)).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("webdav: invalid multistatus: %v", err)
+	}
+	return &ms, nil
+}
+
+//returns the code of a status line like HTTP/1.1 200 OK
+func parseDAVStatus(status string) int {
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(fields[1])
+	return code
+}
+
+//parses a timeout like Second-3600, Infinite is returned as 0
+func parseDAVTimeout(timeout string) time.Duration {
+	timeout = strings.TrimSpace(timeout)
+	if s, ok := strings.CutPrefix(timeout, "Second-"); ok {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 0
+}
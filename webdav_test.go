@@ -0,0 +1,358 @@
+package httpcl
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type davNode struct {
+	dir   bool
+	data  []byte
+	props map[xml.Name]string
+}
+
+// an in memory WebDAV server below /dav/ with a single lock
+type davTestServer struct {
+	mu     sync.Mutex
+	nodes  map[string]*davNode
+	lock   string
+	lockOn string
+	ifs    []string
+}
+
+func newDAVTestServer() *davTestServer {
+	return &davTestServer{nodes: map[string]*davNode{
+		"/dav":             {dir: true},
+		"/dav/docs":        {dir: true},
+		"/dav/docs/a.txt":  {data: []byte("hello")},
+		"/dav/docs/sub":    {dir: true},
+		"/dav/docs/sub/b":  {data: []byte("b")},
+		"/dav/other":       {dir: true},
+		"/dav/readme.md":   {data: []byte("# readme")},
+		"/dav/docs/x y.md": {data: []byte("spaces")},
+	}}
+}
+
+func (s *davTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, pass, _ := r.BasicAuth(); user != "user" || pass != "passwd" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	p := strings.TrimSuffix(r.URL.Path, "/")
+	node := s.nodes[p]
+	body, _ := ioutil.ReadAll(r.Body)
+
+	locked := func(target string) bool {
+		if s.lock == "" || !(target == s.lockOn || strings.HasPrefix(target, s.lockOn+"/")) {
+			return false
+		}
+		s.ifs = append(s.ifs, r.Header.Get("If"))
+		return !strings.Contains(r.Header.Get("If"), "(<"+s.lock+">)")
+	}
+	destination := func() string {
+		u, _ := url.Parse(r.Header.Get("Destination"))
+		return strings.TrimSuffix(u.Path, "/")
+	}
+
+	switch r.Method {
+	case "PROPFIND":
+		if node == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		paths := []string{p}
+		if r.Header.Get("Depth") == "1" && node.dir {
+			for k := range s.nodes {
+				if path.Dir(k) == p {
+					paths = append(paths, k)
+				}
+			}
+		}
+		sort.Strings(paths)
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`)
+		for _, k := range paths {
+			n := s.nodes[k]
+			href := (&url.URL{Path: k}).EscapedPath()
+			resourcetype := ""
+			if n.dir {
+				href += "/"
+				resourcetype = "<d:collection/>"
+			}
+			fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype>%s</d:resourcetype><d:getcontentlength>%d</d:getcontentlength><d:getlastmodified>Mon, 02 Jan 2006 15:04:05 GMT</d:getlastmodified>`, href, resourcetype, len(n.data))
+			for name, value := range n.props {
+				fmt.Fprintf(w, `<x:%s xmlns:x="%s">`, name.Local, name.Space)
+				xml.EscapeText(w, []byte(value))
+				fmt.Fprintf(w, `</x:%s>`, name.Local)
+			}
+			fmt.Fprint(w, `</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:quota/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response>`)
+		}
+		fmt.Fprint(w, `</d:multistatus>`)
+	case "MKCOL":
+		if node != nil {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if locked(p) {
+			w.WriteHeader(http.StatusLocked)
+			return
+		}
+		s.nodes[p] = &davNode{dir: true}
+		w.WriteHeader(http.StatusCreated)
+	case "PUT", "DELETE":
+		if locked(p) {
+			w.WriteHeader(http.StatusLocked)
+			return
+		}
+		if r.Method == "DELETE" {
+			delete(s.nodes, p)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		s.nodes[p] = &davNode{data: body}
+		w.WriteHeader(http.StatusCreated)
+	case "COPY", "MOVE":
+		dst := destination()
+		if node == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if s.nodes[dst] != nil && r.Header.Get("Overwrite") == "F" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if locked(dst) || r.Method == "MOVE" && locked(p) {
+			w.WriteHeader(http.StatusLocked)
+			return
+		}
+		for k, n := range s.nodes {
+			if k == p || strings.HasPrefix(k, p+"/") {
+				s.nodes[dst+strings.TrimPrefix(k, p)] = n
+				if r.Method == "MOVE" {
+					delete(s.nodes, k)
+				}
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+	case "PROPPATCH":
+		if locked(p) {
+			w.WriteHeader(http.StatusLocked)
+			return
+		}
+		var update struct {
+			Set []struct {
+				Props []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			} `xml:"DAV: set>prop"`
+			Remove []struct {
+				Props []struct {
+					XMLName xml.Name
+				} `xml:",any"`
+			} `xml:"DAV: remove>prop"`
+		}
+		xml.Unmarshal(body, &update)
+		status := "HTTP/1.1 200 OK"
+		if node.props == nil {
+			node.props = map[xml.Name]string{}
+		}
+		for _, set := range update.Set {
+			for _, prop := range set.Props {
+				if prop.XMLName.Space == "DAV:" {
+					status = "HTTP/1.1 403 Forbidden"
+					continue
+				}
+				node.props[prop.XMLName] = prop.Value
+			}
+		}
+		for _, remove := range update.Remove {
+			for _, prop := range remove.Props {
+				delete(node.props, prop.XMLName)
+			}
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<d:multistatus xmlns:d="DAV:"><d:response><d:href>%s</d:href><d:propstat><d:prop/><d:status>%s</d:status></d:propstat></d:response></d:multistatus>`, r.URL.Path, status)
+	case "LOCK":
+		if s.lock != "" {
+			w.WriteHeader(http.StatusLocked)
+			return
+		}
+		if !strings.Contains(string(body), "exclusive") || r.Header.Get("Timeout") != "Second-60" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.lock, s.lockOn = "opaquelocktoken:1234", p
+		w.Header().Set("Lock-Token", "<"+s.lock+">")
+		fmt.Fprintf(w, `<d:prop xmlns:d="DAV:"><d:lockdiscovery><d:activelock><d:timeout>Second-60</d:timeout><d:locktoken><d:href>%s</d:href></d:locktoken></d:activelock></d:lockdiscovery></d:prop>`, s.lock)
+	case "UNLOCK":
+		if r.Header.Get("Lock-Token") != "<"+s.lock+">" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.lock, s.lockOn = "", ""
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestWebDAV(t *testing.T) (*WebDAV, *davTestServer) {
+	srv := newDAVTestServer()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	s := NewSession().SetBasicAuthFrom(Credentials{Username: "user", Password: "passwd"})
+	return s.WebDAV(ts.URL + "/dav/"), srv
+}
+
+func Test_WebDAVPropFind(t *testing.T) {
+	dav, _ := newTestWebDAV(t)
+
+	res, err := dav.Stat(nil, "docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Path != "/docs/a.txt" || res.IsCollection || res.ContentLength != 5 || res.LastModified.Year() != 2006 {
+		t.Errorf("resource should be the 5 byte file is %+v", res)
+	}
+	if _, ok := res.Props[xml.Name{Space: "DAV:", Local: "quota"}]; ok {
+		t.Error("properties with status 404 should be skipped")
+	}
+
+	members, err := dav.ReadDir(nil, "/docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, m := range members {
+		paths = append(paths, fmt.Sprintf("%s %v", m.Path, m.IsCollection))
+	}
+	if strings.Join(paths, ",") != "/docs/a.txt false,/docs/sub/ true,/docs/x y.md false" {
+		t.Errorf("members should be the files of docs are %v", paths)
+	}
+
+	_, err = dav.Stat(nil, "missing")
+	if e, ok := err.(*WebDAVError); !ok || e.StatusCode != http.StatusNotFound {
+		t.Errorf("error should be a 404 WebDAVError is %v", err)
+	}
+}
+
+func Test_WebDAVWalk(t *testing.T) {
+	dav, _ := newTestWebDAV(t)
+	var paths []string
+	err := dav.Walk(nil, "/", func(r DAVResource) error {
+		paths = append(paths, r.Path)
+		if r.Path == "/docs/sub/" {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "/,/docs/,/docs/a.txt,/docs/sub/,/docs/x y.md,/other/,/readme.md"
+	if strings.Join(paths, ",") != expected {
+		t.Errorf("walk should be\n%s\nis\n%s", expected, strings.Join(paths, ","))
+	}
+}
+
+func Test_WebDAVCollections(t *testing.T) {
+	dav, srv := newTestWebDAV(t)
+	if err := dav.Mkcol(nil, "new/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dav.Put(nil, "new/file.txt", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dav.Copy(nil, "new/", "copy/", false); err != nil {
+		t.Fatal(err)
+	}
+	err := dav.Copy(nil, "new/", "copy/", false)
+	if e, ok := err.(*WebDAVError); !ok || e.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("copy without overwrite should fail with 412 is %v", err)
+	}
+	if err := dav.Move(nil, "copy/", "moved/", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := dav.Delete(nil, "new/"); err != nil {
+		t.Fatal(err)
+	}
+	if string(srv.nodes["/dav/moved/file.txt"].data) != "data" || srv.nodes["/dav/copy/file.txt"] != nil || srv.nodes["/dav/new"] != nil {
+		t.Error("the file should only exist in moved")
+	}
+}
+
+func Test_WebDAVPropPatch(t *testing.T) {
+	dav, _ := newTestWebDAV(t)
+	color := xml.Name{Space: "urn:x", Local: "color"}
+	if err := dav.PropPatch(nil, "readme.md", []DAVProperty{{Name: color, Value: "a<b"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	res, _ := dav.Stat(nil, "readme.md")
+	if res.Props[color] != "a&lt;b" {
+		t.Errorf("property should be set is %q", res.Props[color])
+	}
+	if err := dav.PropPatch(nil, "readme.md", nil, []xml.Name{color}); err != nil {
+		t.Fatal(err)
+	}
+	res, _ = dav.Stat(nil, "readme.md")
+	if _, ok := res.Props[color]; ok {
+		t.Error("property should be removed")
+	}
+
+	err := dav.PropPatch(nil, "readme.md", []DAVProperty{{Name: xml.Name{Space: "DAV:", Local: "getetag"}, Value: "x"}}, nil)
+	if e, ok := err.(*WebDAVError); !ok || e.StatusCode != http.StatusForbidden {
+		t.Errorf("error should be the 403 of the property is %v", err)
+	}
+}
+
+func Test_WebDAVLock(t *testing.T) {
+	dav, srv := newTestWebDAV(t)
+	lock, err := dav.Lock(nil, "docs/", 60e9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Token != "opaquelocktoken:1234" || lock.Timeout.Seconds() != 60 {
+		t.Errorf("lock should have token and timeout is %+v", lock)
+	}
+
+	//another client without the token is refused
+	other := NewSession().SetBasicAuthFrom(Credentials{Username: "user", Password: "passwd"}).WebDAV(dav.URL)
+	err = other.Put(nil, "docs/new.txt", strings.NewReader("x"))
+	if e, ok := err.(*WebDAVError); !ok || e.StatusCode != http.StatusLocked {
+		t.Errorf("put without token should fail with 423 is %v", err)
+	}
+
+	if err := dav.Put(nil, "docs/new.txt", strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dav.Move(nil, "readme.md", "docs/readme.md", false); err != nil {
+		t.Fatal(err)
+	}
+	if ifh := srv.ifs[len(srv.ifs)-1]; !strings.HasSuffix(ifh, "/dav/docs/> (<opaquelocktoken:1234>)") {
+		t.Errorf("if header should be tagged with the locked collection is %s", ifh)
+	}
+
+	if err := dav.Unlock(nil, "docs/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Put(nil, "docs/new.txt", strings.NewReader("x")); err != nil {
+		t.Errorf("put after unlock should work, got %v", err)
+	}
+	if err := dav.Unlock(nil, "docs/"); err == nil {
+		t.Error("unlock without lock should fail")
+	}
+}
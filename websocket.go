@@ -0,0 +1,779 @@
+package httpcl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+//how long Close waits for the server to answer the close frame
+const wsCloseTimeout = 5 * time.Second
+
+//MessageType is the opcode of a websocket frame
+type MessageType int
+
+const (
+	ContinuationFrame MessageType = 0
+	TextMessage       MessageType = 1
+	BinaryMessage     MessageType = 2
+	CloseMessage      MessageType = 8
+	PingMessage       MessageType = 9
+	PongMessage       MessageType = 10
+)
+
+//websocket close codes (RFC 6455 section 7.4)
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	CloseMessageTooBig   = 1009
+)
+
+//ErrWebSocketClosed is returned when writing after the close frame was sent
+var ErrWebSocketClosed = errors.New("websocket: closed")
+
+var (
+	errFrameTooBig  = errors.New("websocket: message too big")
+	errReservedBits = errors.New("websocket: reserved bits set")
+)
+
+//a CloseError is returned by ReadMessage once the peer closed the connection
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("websocket: closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed with code %d: %s", e.Code, e.Text)
+}
+
+//WebSocketOptions configure the handshake and the connection
+type WebSocketOptions struct {
+	//offered in Sec-WebSocket-Protocol, the chosen one is WebSocket.Subprotocol
+	Subprotocols []string
+	//offers permessage-deflate, messages are compressed if the server accepts
+	Compression bool
+	//sends a ping at this interval and closes the connection if nothing was
+	//read for two intervals, pongs are only seen while ReadMessage is called
+	PingInterval time.Duration
+	//largest message ReadMessage accepts, defaults to 32 MiB
+	ReadLimit int64
+}
+
+//a WebSocket is a client connection, reads and writes may happen
+//concurrently but only one goroutine may read and one may write
+type WebSocket struct {
+	//the subprotocol chosen by the server
+	Subprotocol string
+	//if permessage-deflate was negotiated
+	Compressed bool
+	//the response of the handshake, its body is the connection
+	Response *http.Response
+
+	rwc       io.ReadWriteCloser
+	br        *bufio.Reader
+	readLimit int64
+
+	rmu           sync.Mutex
+	closeReceived *CloseError
+
+	//mmu orders data messages, wmu single frames
+	mmu       sync.Mutex
+	wmu       sync.Mutex
+	closeSent bool
+
+	lastRead  atomic.Int64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+//performs the websocket handshake (RFC 6455) with the request of the client,
+//ws and wss urls are sent as http and https. Headers, cookies, middleware
+//and the transport of the client or its session are used, HTTP/2 is disabled
+//for the handshake. The timeout of the session does not apply, use the
+//context of the request instead.
+func (c *Client) WebSocket(opts ...WebSocketOptions) (*WebSocket, error) {
+	var o WebSocketOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if err := c.hasRequest(); err != nil {
+		c.Error = err
+		return nil, err
+	}
+	if c.Error != nil {
+		return nil, c.Error
+	}
+
+	req := c.request.Clone(c.request.Context())
+	req.Method = "GET"
+	req.Body, req.GetBody, req.ContentLength = nil, nil, 0
+	switch req.URL.Scheme {
+	case "ws":
+		req.URL.Scheme = "http"
+	case "wss":
+		req.URL.Scheme = "https"
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(o.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(o.Subprotocols, ", "))
+	}
+	if o.Compression {
+		req.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	resp, err := c.webSocketClient().Do(req)
+	if err != nil {
+		c.Error = err
+		c.StatusCode = -1
+		return nil, err
+	}
+	c.StatusCode = resp.StatusCode
+	ws, err := acceptWebSocket(resp, key, o)
+	if err != nil {
+		resp.Body.Close()
+		c.Error = err
+		return nil, err
+	}
+	return ws, nil
+}
+
+//returns the http client of the handshake, redirects are not followed
+func (c *Client) webSocketClient() *http.Client {
+	cl := http.Client{}
+	if c.client != nil {
+		cl = *c.client
+	} else if c.session != nil {
+		cl.Transport = c.session.transport
+		cl.Jar = c.session.Jar
+	}
+	cl.Timeout = 0
+	cl.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	cl.Transport = chain(http1Transport(cl.Transport), c.allMiddleware())
+	return &cl
+}
+
+//returns a copy of the transport that only speaks HTTP/1.1, the upgrade
+//is not possible over HTTP/2
+func http1Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+	t = t.Clone()
+	t.ForceAttemptHTTP2 = false
+	t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	if dial := t.DialTLSContext; dial != nil {
+		t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dial(context.WithValue(ctx, http1OnlyKey{}, true), network, addr)
+		}
+	}
+	if t.TLSClientConfig != nil {
+		var protos []string
+		for _, p := range t.TLSClientConfig.NextProtos {
+			if p != "h2" {
+				protos = append(protos, p)
+			}
+		}
+		t.TLSClientConfig.NextProtos = protos
+	}
+	return t
+}
+
+//checks the handshake response and creates the connection
+func acceptWebSocket(resp *http.Response, key string, o WebSocketOptions) (*WebSocket, error) {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websocket: handshake failed with status %s", resp.Status)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || !headerContainsToken(resp.Header, "Connection", "upgrade") {
+		return nil, errors.New("websocket: handshake response without upgrade")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		return nil, errors.New("websocket: invalid Sec-WebSocket-Accept")
+	}
+
+	ws := &WebSocket{Response: resp, readLimit: o.ReadLimit, done: make(chan struct{})}
+	if ws.readLimit <= 0 {
+		ws.readLimit = 32 << 20
+	}
+	if proto := resp.Header.Get("Sec-WebSocket-Protocol"); proto != "" {
+		offered := false
+		for _, p := range o.Subprotocols {
+			offered = offered || p == proto
+		}
+		if !offered {
+			return nil, fmt.Errorf("websocket: server chose subprotocol %q that was not offered", proto)
+		}
+		ws.Subprotocol = proto
+	}
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); ext != "" {
+		compressed, err := parseDeflateExtension(ext, o.Compression)
+		if err != nil {
+			return nil, err
+		}
+		ws.Compressed = compressed
+	}
+
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return nil, errors.New("websocket: the transport does not support switching protocols")
+	}
+	ws.rwc = rwc
+	ws.br = bufio.NewReader(rwc)
+	ws.lastRead.Store(time.Now().UnixNano())
+	if o.PingInterval > 0 {
+		go ws.keepalive(o.PingInterval)
+	}
+	return ws, nil
+}
+
+func webSocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//checks the extensions accepted by the server, only permessage-deflate
+//without context takeover is supported
+func parseDeflateExtension(header string, offered bool) (bool, error) {
+	params := strings.Split(header, ";")
+	if !offered || strings.TrimSpace(params[0]) != "permessage-deflate" || strings.Contains(header, ",") {
+		return false, fmt.Errorf("websocket: unsupported extension %q", header)
+	}
+	serverNoTakeover := false
+	for _, p := range params[1:] {
+		switch strings.TrimSpace(p) {
+		case "server_no_context_takeover":
+			serverNoTakeover = true
+		case "client_no_context_takeover":
+		default:
+			return false, fmt.Errorf("websocket: unsupported permessage-deflate parameter %q", strings.TrimSpace(p))
+		}
+	}
+	if !serverNoTakeover {
+		return false, errors.New("websocket: server did not accept server_no_context_takeover")
+	}
+	return true, nil
+}
+
+//ReadMessage returns the next text or binary message. Fragmented messages
+//are joined, pings are answered and compressed messages are inflated. A
+//close frame of the peer is answered and returned as *CloseError.
+func (ws *WebSocket) ReadMessage() (MessageType, []byte, error) {
+	ws.rmu.Lock()
+	defer ws.rmu.Unlock()
+	return ws.readMessage()
+}
+
+func (ws *WebSocket) readMessage() (MessageType, []byte, error) {
+	if ws.closeReceived != nil {
+		return 0, nil, ws.closeReceived
+	}
+	var typ MessageType
+	var data []byte
+	compressed := false
+	for {
+		f, err := readWSFrame(ws.br, ws.readLimit-int64(len(data)))
+		if err == errFrameTooBig {
+			return 0, nil, ws.fail(CloseMessageTooBig, "message too big")
+		}
+		if err == errReservedBits {
+			return 0, nil, ws.fail(CloseProtocolError, "reserved bits set")
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		ws.lastRead.Store(time.Now().UnixNano())
+		if f.masked {
+			return 0, nil, ws.fail(CloseProtocolError, "masked frame from server")
+		}
+		if f.rsv1 && (!ws.Compressed || f.op != TextMessage && f.op != BinaryMessage) {
+			return 0, nil, ws.fail(CloseProtocolError, "unexpected rsv1 bit")
+		}
+		if f.op >= CloseMessage && (!f.fin || len(f.payload) > 125) {
+			return 0, nil, ws.fail(CloseProtocolError, "invalid control frame")
+		}
+
+		switch f.op {
+		case PingMessage:
+			if err := ws.writeControl(PongMessage, f.payload); err != nil && err != ErrWebSocketClosed {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			return 0, nil, ws.receiveClose(f.payload)
+		case TextMessage, BinaryMessage:
+			if typ != 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "new message inside a fragmented message")
+			}
+			typ, compressed = f.op, f.rsv1
+			data = append(data, f.payload...)
+		case ContinuationFrame:
+			if typ == 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "continuation frame without message")
+			}
+			data = append(data, f.payload...)
+		default:
+			return 0, nil, ws.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.op))
+		}
+		if f.fin {
+			break
+		}
+	}
+
+	if compressed {
+		var err error
+		data, err = inflateMessage(data, ws.readLimit)
+		if err == errFrameTooBig {
+			return 0, nil, ws.fail(CloseMessageTooBig, "message too big")
+		}
+		if err != nil {
+			return 0, nil, ws.fail(CloseInvalidPayload, "invalid compressed message")
+		}
+	}
+	if typ == TextMessage && !utf8.Valid(data) {
+		return 0, nil, ws.fail(CloseInvalidPayload, "invalid utf-8 in text message")
+	}
+	return typ, data, nil
+}
+
+//answers the close frame of the peer and closes the connection
+func (ws *WebSocket) receiveClose(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatus}
+	if len(payload) >= 2 {
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Text = string(payload[2:])
+	}
+	ws.closeReceived = ce
+	if ce.Code == CloseNoStatus {
+		ws.writeClose(-1, "")
+	} else {
+		ws.writeClose(ce.Code, "")
+	}
+	ws.closeConn()
+	return ce
+}
+
+//closes the connection with the code after a protocol violation of the peer
+func (ws *WebSocket) fail(code int, reason string) error {
+	ws.writeClose(code, reason)
+	ws.closeConn()
+	return fmt.Errorf("websocket: %s", reason)
+}
+
+//WriteMessage sends data as a single frame, compressed if permessage-deflate
+//was negotiated. Control messages are sent as they are.
+func (ws *WebSocket) WriteMessage(typ MessageType, data []byte) error {
+	if typ >= CloseMessage {
+		return ws.writeControl(typ, data)
+	}
+	ws.mmu.Lock()
+	defer ws.mmu.Unlock()
+	if ws.Compressed {
+		compressed, err := deflateMessage(data)
+		if err != nil {
+			return err
+		}
+		return ws.writeFrame(true, true, typ, compressed)
+	}
+	return ws.writeFrame(true, false, typ, data)
+}
+
+//sends a text message
+func (ws *WebSocket) WriteText(text string) error {
+	return ws.WriteMessage(TextMessage, []byte(text))
+}
+
+//NextWriter returns a writer for a fragmented message, every Write is sent
+//as a frame and Close ends the message. Other messages wait until then.
+func (ws *WebSocket) NextWriter(typ MessageType) (io.WriteCloser, error) {
+	if typ != TextMessage && typ != BinaryMessage {
+		return nil, fmt.Errorf("websocket: NextWriter needs a data message type, got %d", typ)
+	}
+	ws.mmu.Lock()
+	w := &fragmentWriter{ws: ws, typ: typ}
+	if !ws.Compressed {
+		return w, nil
+	}
+	trim := &trimSyncWriter{w: w}
+	fw, err := flate.NewWriter(trim, flate.DefaultCompression)
+	if err != nil {
+		ws.mmu.Unlock()
+		return nil, err
+	}
+	return &deflateWriter{fw: fw, trim: trim, frames: w}, nil
+}
+
+//sends a ping, the pong is handled by ReadMessage
+func (ws *WebSocket) Ping(data []byte) error {
+	return ws.writeControl(PingMessage, data)
+}
+
+//Close sends a normal close frame, waits for the answer of the server and
+//closes the connection
+func (ws *WebSocket) Close() error {
+	return ws.CloseWithReason(CloseNormal, "")
+}
+
+//sends a close frame with the code and reason, waits up to 5 seconds for the
+//answer of the server and closes the connection
+func (ws *WebSocket) CloseWithReason(code int, reason string) error {
+	err := ws.writeClose(code, reason)
+	if err == nil {
+		//closing the connection unblocks the read if the server doesn't answer
+		timer := time.AfterFunc(wsCloseTimeout, ws.closeConn)
+		ws.rmu.Lock()
+		for ws.closeReceived == nil {
+			if _, _, err := ws.readMessage(); err != nil {
+				break
+			}
+		}
+		ws.rmu.Unlock()
+		timer.Stop()
+	}
+	ws.closeConn()
+	if err == ErrWebSocketClosed {
+		return nil
+	}
+	return err
+}
+
+func (ws *WebSocket) closeConn() {
+	ws.closeOnce.Do(func() {
+		close(ws.done)
+		ws.rwc.Close()
+	})
+}
+
+//sends pings and closes the connection if nothing was read for two intervals
+func (ws *WebSocket) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ws.done:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, ws.lastRead.Load())) > 2*interval {
+				ws.closeConn()
+				return
+			}
+			if err := ws.Ping(nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+//sends a close frame once, a negative code sends an empty close frame
+func (ws *WebSocket) writeClose(code int, reason string) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+	var payload []byte
+	if code >= 0 {
+		payload = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > 125 {
+			payload = payload[:125]
+		}
+	}
+	ws.closeSent = true
+	return writeWSFrame(ws.rwc, true, false, CloseMessage, payload, true)
+}
+
+func (ws *WebSocket) writeControl(typ MessageType, payload []byte) error {
+	if typ == CloseMessage {
+		if len(payload) >= 2 {
+			return ws.writeClose(int(binary.BigEndian.Uint16(payload)), string(payload[2:]))
+		}
+		return ws.writeClose(-1, "")
+	}
+	if len(payload) > 125 {
+		return errors.New("websocket: control frame payload longer than 125 bytes")
+	}
+	return ws.writeFrame(true, false, typ, payload)
+}
+
+func (ws *WebSocket) writeFrame(fin, rsv1 bool, typ MessageType, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+	return writeWSFrame(ws.rwc, fin, rsv1, typ, payload, true)
+}
+
+//sends every write as frame of a fragmented message
+type fragmentWriter struct {
+	ws     *WebSocket
+	typ    MessageType
+	rsv1   bool
+	sent   bool
+	closed bool
+}
+
+func (w *fragmentWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrWebSocketClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.frame(false, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *fragmentWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	err := w.frame(true, nil)
+	w.closed = true
+	w.ws.mmu.Unlock()
+	return err
+}
+
+func (w *fragmentWriter) frame(fin bool, p []byte) error {
+	typ := ContinuationFrame
+	if !w.sent {
+		typ = w.typ
+	}
+	err := w.ws.writeFrame(fin, w.rsv1 && !w.sent, typ, p)
+	w.sent = true
+	return err
+}
+
+//compresses a fragmented message
+type deflateWriter struct {
+	fw     *flate.Writer
+	trim   *trimSyncWriter
+	frames *fragmentWriter
+}
+
+func (w *deflateWriter) Write(p []byte) (int, error) {
+	w.frames.rsv1 = true
+	return w.fw.Write(p)
+}
+
+func (w *deflateWriter) Close() error {
+	w.frames.rsv1 = true
+	err := w.fw.Flush()
+	if err == nil && !bytes.Equal(w.trim.tail, deflateTail) {
+		err = errors.New("websocket: deflate stream without sync flush")
+	}
+	if cerr := w.frames.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//holds back the last four bytes, the sync flush marker is not sent
+type trimSyncWriter struct {
+	w    io.Writer
+	tail []byte
+}
+
+func (t *trimSyncWriter) Write(p []byte) (int, error) {
+	buf := append(t.tail, p...)
+	if len(buf) <= 4 {
+		t.tail = buf
+		return len(p), nil
+	}
+	if _, err := t.w.Write(buf[:len(buf)-4]); err != nil {
+		return 0, err
+	}
+	t.tail = append([]byte(nil), buf[len(buf)-4:]...)
+	return len(p), nil
+}
+
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+//compresses a message without context takeover (RFC 7692)
+func deflateMessage(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+//inflates a message, the removed sync marker and a final empty block are
+//appended so the reader ends without an unexpected EOF
+func inflateMessage(data []byte, limit int64) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(data), strings.NewReader("\x00\x00\xff\xff\x01\x00\x00\xff\xff")))
+	defer fr.Close()
+	out, err := ioutil.ReadAll(io.LimitReader(fr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, errFrameTooBig
+	}
+	return out, nil
+}
+
+type wsFrame struct {
+	fin     bool
+	rsv1    bool
+	op      MessageType
+	masked  bool
+	payload []byte
+}
+
+//writes a frame, client frames are masked
+func writeWSFrame(w io.Writer, fin, rsv1 bool, op MessageType, payload []byte, mask bool) error {
+	buf := make([]byte, 0, 14+len(payload))
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	var m byte
+	if mask {
+		m = 0x80
+	}
+	buf = append(buf, b0)
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, m|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, m|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, m|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	if mask {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		buf = append(buf, key[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		for i := range payload {
+			buf[start+i] ^= key[i%4]
+		}
+	} else {
+		buf = append(buf, payload...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+//reads a frame and unmasks its payload, payloads longer than limit fail
+//with errFrameTooBig before they are read
+func readWSFrame(r *bufio.Reader, limit int64) (wsFrame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return wsFrame{}, err
+	}
+	f := wsFrame{
+		fin:    head[0]&0x80 != 0,
+		rsv1:   head[0]&0x40 != 0,
+		op:     MessageType(head[0] & 0x0f),
+		masked: head[1]&0x80 != 0,
+	}
+	if head[0]&0x30 != 0 {
+		return f, errReservedBits
+	}
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	var key [4]byte
+	if f.masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return f, err
+		}
+	}
+	if limit < 0 || length > uint64(limit) {
+		return f, errFrameTooBig
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return f, err
+	}
+	if f.masked {
+		for i := range f.payload {
+			f.payload[i] ^= key[i%4]
+		}
+	}
+	return f, nil
+}
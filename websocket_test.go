@@ -0,0 +1,238 @@
+package httpcl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//a minimal websocket echo server built on the frame functions of the client
+type wsTestServer struct {
+	pings      int32
+	closeCodes chan int
+}
+
+func (s *wsTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	compress := strings.HasPrefix(r.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + webSocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n")
+	if strings.Contains(r.Header.Get("Sec-WebSocket-Protocol"), "chat") {
+		rw.WriteString("Sec-WebSocket-Protocol: chat\r\n")
+	}
+	if compress {
+		rw.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover\r\n")
+	}
+	rw.WriteString("\r\n")
+	rw.Flush()
+
+	br := bufio.NewReader(conn)
+	var msg []byte
+	var typ MessageType
+	compressed := false
+	for {
+		f, err := readWSFrame(br, 1<<20)
+		if err != nil || !f.masked {
+			return
+		}
+		switch f.op {
+		case PingMessage:
+			atomic.AddInt32(&s.pings, 1)
+			writeWSFrame(conn, true, false, PongMessage, f.payload, false)
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			code := CloseNoStatus
+			if len(f.payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(f.payload))
+			}
+			if s.closeCodes != nil {
+				s.closeCodes <- code
+			}
+			writeWSFrame(conn, true, false, CloseMessage, f.payload, false)
+			return
+		case TextMessage, BinaryMessage:
+			typ, msg, compressed = f.op, f.payload, f.rsv1
+		default:
+			msg = append(msg, f.payload...)
+		}
+		if !f.fin {
+			continue
+		}
+		if compressed {
+			msg, _ = inflateMessage(msg, 1<<20)
+		}
+
+		switch string(msg) {
+		case "fragment":
+			writeWSFrame(conn, false, false, TextMessage, []byte("frag"), false)
+			writeWSFrame(conn, true, false, PingMessage, []byte("keep"), false)
+			writeWSFrame(conn, false, false, ContinuationFrame, []byte("men"), false)
+			writeWSFrame(conn, true, false, ContinuationFrame, []byte("ted"), false)
+		case "bye":
+			payload := binary.BigEndian.AppendUint16(nil, 4000)
+			writeWSFrame(conn, true, false, CloseMessage, append(payload, "bye"...), false)
+		case "bad utf8":
+			writeWSFrame(conn, true, false, TextMessage, []byte{0xff, 0xfe}, false)
+		default:
+			if compress {
+				out, _ := deflateMessage(msg)
+				writeWSFrame(conn, true, true, typ, out, false)
+			} else {
+				writeWSFrame(conn, true, false, typ, msg, false)
+			}
+		}
+	}
+}
+
+func wsURL(ts *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func Test_WebSocketEcho(t *testing.T) {
+	srv := &wsTestServer{closeCodes: make(chan int, 1)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	if _, err := Get(wsURL(ts)).WebSocket(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("handshake without auth should fail with 401, got %v", err)
+	}
+
+	for _, compression := range []bool{false, true} {
+		ws, err := Get(wsURL(ts)).AddHeader("Authorization", "Bearer token").
+			WebSocket(WebSocketOptions{Subprotocols: []string{"chat", "v2"}, Compression: compression})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws.Subprotocol != "chat" {
+			t.Errorf("subprotocol should be chat is %s", ws.Subprotocol)
+		}
+		if ws.Compressed != compression {
+			t.Errorf("compressed should be %v is %v", compression, ws.Compressed)
+		}
+
+		big := strings.Repeat("websocket ", 10000)
+		if err := ws.WriteText(big); err != nil {
+			t.Fatal(err)
+		}
+		typ, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != TextMessage || string(data) != big {
+			t.Errorf("echo should be the text message, got type %v with %v bytes", typ, len(data))
+		}
+
+		w, _ := ws.NextWriter(BinaryMessage)
+		w.Write([]byte{1, 2})
+		w.Write([]byte{3})
+		w.Close()
+		typ, data, err = ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != BinaryMessage || string(data) != "\x01\x02\x03" {
+			t.Errorf("echo should be the fragmented binary message, got %v %v", typ, data)
+		}
+
+		if err := ws.Close(); err != nil {
+			t.Error(err)
+		}
+		if code := <-srv.closeCodes; code != CloseNormal {
+			t.Errorf("close code should be %v is %v", CloseNormal, code)
+		}
+		if err := ws.WriteText("late"); err != ErrWebSocketClosed {
+			t.Errorf("write after close should be ErrWebSocketClosed is %v", err)
+		}
+	}
+}
+
+func Test_WebSocketFragmentsAndClose(t *testing.T) {
+	srv := &wsTestServer{closeCodes: make(chan int, 1)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ws, err := NewSession().AddHeader("Authorization", "Bearer token").Get(wsURL(ts)).WebSocket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.WriteText("fragment")
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "fragmented" {
+		t.Errorf("message should be fragmented is %s", data)
+	}
+	if n := atomic.LoadInt32(&srv.pings); n != 0 {
+		t.Errorf("server should not get pings, got %v", n)
+	}
+
+	ws.WriteText("bye")
+	_, _, err = ws.ReadMessage()
+	ce, ok := err.(*CloseError)
+	if !ok || ce.Code != 4000 || ce.Text != "bye" {
+		t.Fatalf("error should be close 4000 bye is %v", err)
+	}
+	if code := <-srv.closeCodes; code != 4000 {
+		t.Errorf("client should echo close code 4000, got %v", code)
+	}
+	if _, _, err := ws.ReadMessage(); err != ce {
+		t.Errorf("read after close should return the close error, got %v", err)
+	}
+}
+
+func Test_WebSocketInvalidText(t *testing.T) {
+	srv := &wsTestServer{closeCodes: make(chan int, 1)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ws, err := Get(wsURL(ts)).AddHeader("Authorization", "Bearer token").WebSocket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.WriteText("bad utf8")
+	if _, _, err := ws.ReadMessage(); err == nil || !strings.Contains(err.Error(), "utf-8") {
+		t.Errorf("error should be invalid utf-8 is %v", err)
+	}
+	if code := <-srv.closeCodes; code != CloseInvalidPayload {
+		t.Errorf("close code should be %v is %v", CloseInvalidPayload, code)
+	}
+}
+
+func Test_WebSocketKeepalive(t *testing.T) {
+	srv := &wsTestServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ws, err := Get(wsURL(ts)).AddHeader("Authorization", "Bearer token").
+		WebSocket(WebSocketOptions{PingInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ws.ReadMessage()
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&srv.pings); n < 3 {
+		t.Errorf("server should get at least 3 pings, got %v", n)
+	}
+	ws.Close()
+}
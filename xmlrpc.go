@@ -0,0 +1,537 @@
+package httpcl
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const xmlrpcTimeFormat = "20060102T15:04:05"
+
+//XMLRPC calls methods of an XML-RPC endpoint
+type XMLRPC struct {
+	URL string
+	//requests are created from the session if set
+	Session *Session
+	//http headers added to every request
+	Header http.Header
+}
+
+//an XMLRPCFault is the fault of a method response
+type XMLRPCFault struct {
+	Code   int    `xmlrpc:"faultCode"`
+	String string `xmlrpc:"faultString"`
+}
+
+func (f *XMLRPCFault) Error() string {
+	return fmt.Sprintf("xmlrpc fault %d: %s", f.Code, f.String)
+}
+
+//creates an XML-RPC client for the endpoint
+func NewXMLRPC(url string) *XMLRPC {
+	return &XMLRPC{URL: url}
+}
+
+//creates an XML-RPC client for the endpoint sending through the session
+func (s *Session) XMLRPC(url string) *XMLRPC {
+	return &XMLRPC{URL: url, Session: s}
+}
+
+//calls the method with params and decodes the returned value into result,
+//a fault is returned as *XMLRPCFault
+func (x *XMLRPC) Call(ctx context.Context, method string, result interface{}, params ...interface{}) error {
+	body, err := MarshalXMLRPC(method, params...)
+	if err != nil {
+		return err
+	}
+	var c *Client
+	if x.Session != nil {
+		c = x.Session.Post(x.URL, bytes.NewReader(body))
+	} else {
+		c = Post(x.URL, bytes.NewReader(body))
+	}
+	c.SetContentType("text/xml")
+	for key, values := range x.Header {
+		for _, value := range values {
+			c.AddHeader(key, value)
+		}
+	}
+	if c.Error == nil && ctx != nil {
+		c.SetRequest(c.GetRequest().WithContext(ctx))
+	}
+
+	resp, err := c.Do()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("xmlrpc: unexpected status %s", resp.Status)
+	}
+	return decodeXMLRPCResponse(xml.NewDecoder(resp.Body), result)
+}
+
+//MarshalXMLRPC encodes a methodCall. Supported are bools, integers up to 32
+//bit, floats, strings, time.Time, []byte as base64, slices and arrays, maps
+//with string keys and structs, whose member names can be set with a tag
+//like `xmlrpc:"name,omitempty"`.
+func MarshalXMLRPC(method string, params ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<methodCall><methodName>")
+	xml.EscapeText(&buf, []byte(method))
+	buf.WriteString("</methodName><params>")
+	for _, p := range params {
+		buf.WriteString("<param>")
+		if err := writeXMLRPCValue(&buf, reflect.ValueOf(p)); err != nil {
+			return nil, err
+		}
+		buf.WriteString("</param>")
+	}
+	buf.WriteString("</params></methodCall>")
+	return buf.Bytes(), nil
+}
+
+var bytesType = reflect.TypeOf([]byte(nil))
+
+func writeXMLRPCValue(buf *bytes.Buffer, v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return errors.New("xmlrpc: nil values are not supported")
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return errors.New("xmlrpc: nil values are not supported")
+	}
+	buf.WriteString("<value>")
+	switch {
+	case v.Type() == timeType:
+		fmt.Fprintf(buf, "<dateTime.iso8601>%s</dateTime.iso8601>", v.Interface().(time.Time).Format(xmlrpcTimeFormat))
+	case v.Type() == bytesType:
+		fmt.Fprintf(buf, "<base64>%s</base64>", base64.StdEncoding.EncodeToString(v.Bytes()))
+	default:
+		switch v.Kind() {
+		case reflect.Bool:
+			b := "0"
+			if v.Bool() {
+				b = "1"
+			}
+			fmt.Fprintf(buf, "<boolean>%s</boolean>", b)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n := v.Int()
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return fmt.Errorf("xmlrpc: %d overflows int", n)
+			}
+			fmt.Fprintf(buf, "<int>%d</int>", n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n := v.Uint()
+			if n > math.MaxInt32 {
+				return fmt.Errorf("xmlrpc: %d overflows int", n)
+			}
+			fmt.Fprintf(buf, "<int>%d</int>", n)
+		case reflect.Float32, reflect.Float64:
+			fmt.Fprintf(buf, "<double>%s</double>", strconv.FormatFloat(v.Float(), 'f', -1, 64))
+		case reflect.String:
+			buf.WriteString("<string>")
+			xml.EscapeText(buf, []byte(v.String()))
+			buf.WriteString("</string>")
+		case reflect.Slice, reflect.Array:
+			buf.WriteString("<array><data>")
+			for i := 0; i < v.Len(); i++ {
+				if err := writeXMLRPCValue(buf, v.Index(i)); err != nil {
+					return err
+				}
+			}
+			buf.WriteString("</data></array>")
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return fmt.Errorf("xmlrpc: unsupported map key type %s", v.Type().Key())
+			}
+			keys := make([]string, 0, v.Len())
+			for _, k := range v.MapKeys() {
+				keys = append(keys, k.String())
+			}
+			sort.Strings(keys)
+			buf.WriteString("<struct>")
+			for _, k := range keys {
+				if err := writeXMLRPCMember(buf, k, v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key()))); err != nil {
+					return err
+				}
+			}
+			buf.WriteString("</struct>")
+		case reflect.Struct:
+			buf.WriteString("<struct>")
+			for _, f := range xmlrpcFields(v.Type()) {
+				fv := v.Field(f.index)
+				if f.omitEmpty && fv.IsZero() {
+					continue
+				}
+				if err := writeXMLRPCMember(buf, f.name, fv); err != nil {
+					return err
+				}
+			}
+			buf.WriteString("</struct>")
+		default:
+			return fmt.Errorf("xmlrpc: unsupported type %s", v.Type())
+		}
+	}
+	buf.WriteString("</value>")
+	return nil
+}
+
+func writeXMLRPCMember(buf *bytes.Buffer, name string, v reflect.Value) error {
+	buf.WriteString("<member><name>")
+	xml.EscapeText(buf, []byte(name))
+	buf.WriteString("</name>")
+	if err := writeXMLRPCValue(buf, v); err != nil {
+		return err
+	}
+	buf.WriteString("</member>")
+	return nil
+}
+
+type xmlrpcField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+//returns the exported fields of a struct with their member names
+func xmlrpcFields(t reflect.Type) []xmlrpcField {
+	var fields []xmlrpcField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("xmlrpc"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, xmlrpcField{name: name, index: i, omitEmpty: opts == "omitempty"})
+	}
+	return fields
+}
+
+//UnmarshalXMLRPC decodes the value of a methodResponse into v, a fault is
+//returned as *XMLRPCFault. Values decoded into an interface{} are int64,
+//bool, string, float64, time.Time, []byte, []interface{} and
+//map[string]interface{}.
+func UnmarshalXMLRPC(data []byte, v interface{}) error {
+	return decodeXMLRPCResponse(xml.NewDecoder(bytes.NewReader(data)), v)
+}
+
+//reads the methodResponse from the stream, up to its first value
+func decodeXMLRPCResponse(dec *xml.Decoder, v interface{}) error {
+	fault := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return errors.New("xmlrpc: response without value")
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "fault":
+			fault = true
+		case "value":
+			value, err := decodeXMLRPCValue(dec)
+			if err != nil {
+				return err
+			}
+			if fault {
+				f := &XMLRPCFault{}
+				if err := assignXMLRPC(reflect.ValueOf(f).Elem(), value); err != nil {
+					return err
+				}
+				return f
+			}
+			if v == nil {
+				return nil
+			}
+			rv := reflect.ValueOf(v)
+			if rv.Kind() != reflect.Ptr || rv.IsNil() {
+				return errors.New("xmlrpc: result must be a non nil pointer")
+			}
+			return assignXMLRPC(rv.Elem(), value)
+		}
+	}
+}
+
+//decodes the content of a value element including its end
+func decodeXMLRPCValue(dec *xml.Decoder) (interface{}, error) {
+	var text []byte
+	var value interface{}
+	typed := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.StartElement:
+			if typed {
+				return nil, fmt.Errorf("xmlrpc: unexpected element %s in value", t.Name.Local)
+			}
+			typed = true
+			if value, err = decodeXMLRPCTyped(dec, t); err != nil {
+				return nil, err
+			}
+		case xml.EndElement:
+			if !typed {
+				//a value without type is a string
+				return string(text), nil
+			}
+			return value, nil
+		}
+	}
+}
+
+func decodeXMLRPCTyped(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "array":
+		return decodeXMLRPCArray(dec)
+	case "struct":
+		return decodeXMLRPCStruct(dec)
+	case "nil":
+		return nil, dec.Skip()
+	}
+	text, err := readXMLText(dec)
+	if err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "int", "i4", "i8":
+		return strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	case "boolean":
+		switch strings.TrimSpace(text) {
+		case "1":
+			return true, nil
+		case "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("xmlrpc: invalid boolean %q", text)
+	case "string":
+		return text, nil
+	case "double":
+		return strconv.ParseFloat(strings.TrimSpace(text), 64)
+	case "dateTime.iso8601":
+		text = strings.TrimSpace(text)
+		for _, layout := range []string{xmlrpcTimeFormat, "2006-01-02T15:04:05", "20060102T15:04:05Z07:00", time.RFC3339} {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("xmlrpc: invalid dateTime %q", text)
+	case "base64":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	}
+	return nil, fmt.Errorf("xmlrpc: unknown type %s", start.Name.Local)
+}
+
+func decodeXMLRPCArray(dec *xml.Decoder) (interface{}, error) {
+	values := []interface{}{}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "value" {
+				value, err := decodeXMLRPCValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "array" {
+				return values, nil
+			}
+		}
+	}
+}
+
+func decodeXMLRPCStruct(dec *xml.Decoder) (interface{}, error) {
+	members := map[string]interface{}{}
+	var name string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "name":
+				if name, err = readXMLText(dec); err != nil {
+					return nil, err
+				}
+			case "value":
+				value, err := decodeXMLRPCValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				members[name] = value
+			}
+		case xml.EndElement:
+			if t.Name.Local == "struct" {
+				return members, nil
+			}
+		}
+	}
+}
+
+//reads the text of an element without children including its end
+func readXMLText(dec *xml.Decoder) (string, error) {
+	var text []byte
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.StartElement:
+			return "", fmt.Errorf("xmlrpc: unexpected element %s", t.Name.Local)
+		case xml.EndElement:
+			return string(text), nil
+		}
+	}
+}
+
+//stores a decoded value in dst converting it to the type of dst
+func assignXMLRPC(dst reflect.Value, value interface{}) error {
+	if value == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assignXMLRPC(dst.Elem(), value)
+	}
+	src := reflect.ValueOf(value)
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		dst.Set(src)
+		return nil
+	}
+	mismatch := fmt.Errorf("xmlrpc: cannot decode %T into %s", value, dst.Type())
+	switch {
+	case dst.Type() == timeType, dst.Type() == bytesType:
+		if src.Type() != dst.Type() {
+			return mismatch
+		}
+		dst.Set(src)
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return mismatch
+		}
+		dst.SetBool(b)
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return mismatch
+		}
+		dst.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := value.(int64)
+		if !ok {
+			return mismatch
+		}
+		if dst.OverflowInt(n) {
+			return fmt.Errorf("xmlrpc: %d overflows %s", n, dst.Type())
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := value.(int64)
+		if !ok || n < 0 || dst.OverflowUint(uint64(n)) {
+			return mismatch
+		}
+		dst.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		switch n := value.(type) {
+		case float64:
+			dst.SetFloat(n)
+		case int64:
+			dst.SetFloat(float64(n))
+		default:
+			return mismatch
+		}
+	case reflect.Slice:
+		values, ok := value.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		out := reflect.MakeSlice(dst.Type(), len(values), len(values))
+		for i, v := range values {
+			if err := assignXMLRPC(out.Index(i), v); err != nil {
+				return err
+			}
+		}
+		dst.Set(out)
+	case reflect.Map:
+		members, ok := value.(map[string]interface{})
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			return mismatch
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(dst.Type(), len(members)))
+		}
+		for k, v := range members {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := assignXMLRPC(elem, v); err != nil {
+				return err
+			}
+			dst.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), elem)
+		}
+	case reflect.Struct:
+		members, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		for _, f := range xmlrpcFields(dst.Type()) {
+			for k, v := range members {
+				if strings.EqualFold(k, f.name) {
+					if err := assignXMLRPC(dst.Field(f.index), v); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		}
+	default:
+		return mismatch
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package httpcl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type xmlrpcItem struct {
+	Name    string    `xmlrpc:"name"`
+	Count   int       `xmlrpc:"count"`
+	Price   float64   `xmlrpc:"price"`
+	Active  bool      `xmlrpc:"active"`
+	Created time.Time `xmlrpc:"created"`
+	Data    []byte    `xmlrpc:"data"`
+	Tags    []string  `xmlrpc:"tags,omitempty"`
+	Skipped string    `xmlrpc:"-"`
+}
+
+func Test_XMLRPCMarshal(t *testing.T) {
+	created := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	body, err := MarshalXMLRPC("shop.add", xmlrpcItem{Name: "a<b", Count: 2, Price: 1.5, Active: true, Created: created, Data: []byte("hi"), Skipped: "x"}, []int{1, 2}, map[string]interface{}{"b": "x", "a": int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<methodCall><methodName>shop.add</methodName><params>` +
+		`<param><value><struct>` +
+		`<member><name>name</name><value><string>a&lt;b</string></value></member>` +
+		`<member><name>count</name><value><int>2</int></value></member>` +
+		`<member><name>price</name><value><double>1.5</double></value></member>` +
+		`<member><name>active</name><value><boolean>1</boolean></value></member>` +
+		`<member><name>created</name><value><dateTime.iso8601>20240506T07:08:09</dateTime.iso8601></value></member>` +
+		`<member><name>data</name><value><base64>aGk=</base64></value></member>` +
+		`</struct></value></param>` +
+		`<param><value><array><data><value><int>1</int></value><value><int>2</int></value></data></array></value></param>` +
+		`<param><value><struct><member><name>a</name><value><int>1</int></value></member><member><name>b</name><value><string>x</string></value></member></struct></value></param>` +
+		`</params></methodCall>`
+	if !strings.HasSuffix(string(body), expected) {
+		t.Errorf("method call should be\n%s\nis\n%s", expected, body)
+	}
+
+	if _, err := MarshalXMLRPC("m", int64(1)<<40); err == nil {
+		t.Error("integers over 32 bit should fail")
+	}
+	if _, err := MarshalXMLRPC("m", nil); err == nil {
+		t.Error("nil should fail")
+	}
+}
+
+func Test_XMLRPCUnmarshal(t *testing.T) {
+	response := `<?xml version="1.0"?><methodResponse><params><param><value><struct>
+		<member><name>name</name><value>plain</value></member>
+		<member><name>COUNT</name><value><i4>7</i4></value></member>
+		<member><name>price</name><value><int>3</int></value></member>
+		<member><name>active</name><value><boolean>0</boolean></value></member>
+		<member><name>created</name><value><dateTime.iso8601>20240506T07:08:09</dateTime.iso8601></value></member>
+		<member><name>data</name><value><base64>aG
+		k=</base64></value></member>
+		<member><name>tags</name><value><array><data><value><string>x</string></value><value>y</value></data></array></value></member>
+	</struct></value></param></params></methodResponse>`
+	var item xmlrpcItem
+	if err := UnmarshalXMLRPC([]byte(response), &item); err != nil {
+		t.Fatal(err)
+	}
+	expected := xmlrpcItem{Name: "plain", Count: 7, Price: 3, Created: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), Data: []byte("hi"), Tags: []string{"x", "y"}}
+	if !reflect.DeepEqual(item, expected) {
+		t.Errorf("item should be %+v is %+v", expected, item)
+	}
+
+	var generic interface{}
+	if err := UnmarshalXMLRPC([]byte(response), &generic); err != nil {
+		t.Fatal(err)
+	}
+	m := generic.(map[string]interface{})
+	if m["COUNT"] != int64(7) || m["active"] != false || !reflect.DeepEqual(m["tags"], []interface{}{"x", "y"}) {
+		t.Errorf("generic value is %v", m)
+	}
+
+	var n int
+	if err := UnmarshalXMLRPC([]byte(response), &n); err == nil {
+		t.Error("decoding a struct into an int should fail")
+	}
+
+	fault := `<methodResponse><fault><value><struct><member><name>faultCode</name><value><int>4</int></value></member><member><name>faultString</name><value><string>Too many parameters.</string></value></member></struct></value></fault></methodResponse>`
+	err := UnmarshalXMLRPC([]byte(fault), &n)
+	f, ok := err.(*XMLRPCFault)
+	if !ok || f.Code != 4 || f.String != "Too many parameters." {
+		t.Errorf("error should be fault 4 is %v", err)
+	}
+}
+
+func Test_XMLRPCCall(t *testing.T) {
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "text/xml" {
+			http.Error(w, "wrong content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`<methodResponse><params><param><value><double>4.5</double></value></param></params></methodResponse>`))
+	}))
+	defer ts.Close()
+
+	var sum float64
+	if err := NewSession().XMLRPC(ts.URL).Call(nil, "add", &sum, 2, 2.5); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 4.5 {
+		t.Errorf("sum should be 4.5 is %v", sum)
+	}
+	if !bytes.Contains(body, []byte("<methodName>add</methodName><params><param><value><int>2</int></value></param><param><value><double>2.5</double></value></param>")) {
+		t.Errorf("request body is %s", body)
+	}
+}
+
+func Test_XMLRPCLargeResponse(t *testing.T) {
+	if testing.Short() {
+		t.Skip("large response")
+	}
+	const size = 65 << 20
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<methodResponse><params><param><value><string>`))
+		chunk := bytes.Repeat([]byte("a"), 1<<20)
+		for i := 0; i < size>>20; i++ {
+			w.Write(chunk)
+		}
+		w.Write([]byte(`</string></value></param></params></methodResponse>`))
+	}))
+	defer ts.Close()
+
+	var result string
+	if err := NewXMLRPC(ts.URL).Call(nil, "big", &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != size {
+		t.Errorf("result length should be %v is %v", size, len(result))
+	}
+}